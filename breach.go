@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// breachChunk is the read size, it is enough to fit HIBP line
+// "40 hex digits:count\r\n" in one read
+const breachChunk = 128
+
+// Breached passwords checker, nil if POLICYBREACH is not set
+var breaches *BreachChecker
+
+// BreachChecker looks up passwords in the locally downloaded
+// HIBP-style file. Each line is "SHA1:COUNT", lines are sorted by
+// the hash, so binary search is used without loading the file
+type BreachChecker struct {
+	f    *os.File
+	size int64
+}
+
+// NewBreachChecker opens hash corpus file
+func NewBreachChecker(name string) (b *BreachChecker, err error) {
+	var (
+		f    *os.File
+		info os.FileInfo
+	)
+
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+
+	if info, err = f.Stat(); err != nil {
+		f.Close()
+		return
+	}
+
+	return &BreachChecker{
+		f:    f,
+		size: info.Size(),
+	}, nil
+}
+
+// Breached returns how many times the password was seen in the
+// breaches, 0 if it is not found
+func (b *BreachChecker) Breached(password string) (count int64, err error) {
+	var (
+		sum  = sha1.Sum([]byte(password))
+		hash = strings.ToUpper(hex.EncodeToString(sum[:]))
+
+		lo, hi = int64(0), b.size
+	)
+
+	for lo < hi {
+		var (
+			start, end, mid int64
+			line            string
+		)
+
+		mid = lo + (hi-lo)/2
+
+		if start, err = b.lineStart(mid); err != nil {
+			return
+		}
+
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		if line, end, err = b.line(start); err != nil {
+			return
+		}
+
+		key, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			key, value = line[:i], line[i+1:]
+		}
+
+		switch strings.Compare(strings.ToUpper(key), hash) {
+		case 0:
+			if count, _ = strconv.ParseInt(value, 10, 64); count < 1 {
+				count = 1
+			}
+
+			return
+		case -1:
+			lo = end
+		default:
+			hi = mid
+		}
+	}
+
+	return 0, nil
+}
+
+// Close releases the file
+func (b *BreachChecker) Close() error {
+	return b.f.Close()
+}
+
+// lineStart returns offset of the first line starting at or after off
+func (b *BreachChecker) lineStart(off int64) (int64, error) {
+	var buf = make([]byte, breachChunk)
+
+	if off == 0 {
+		return 0, nil
+	}
+
+	for off--; off < b.size; off += breachChunk {
+		n, err := b.f.ReadAt(buf, off)
+
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return off + int64(i) + 1, nil
+		}
+
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+
+	return b.size, nil
+}
+
+// line reads the line from the offset and returns it without
+// line ending and the offset of the next line
+func (b *BreachChecker) line(off int64) (string, int64, error) {
+	var (
+		buf  = make([]byte, breachChunk)
+		line []byte
+	)
+
+	for pos := off; pos < b.size; pos += breachChunk {
+		n, err := b.f.ReadAt(buf, pos)
+
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			line = append(line, buf[:i]...)
+			return strings.TrimSpace(string(line)), pos + int64(i) + 1, nil
+		}
+
+		line = append(line, buf[:n]...)
+
+		if err != nil && err != io.EOF {
+			return "", 0, err
+		}
+	}
+
+	return strings.TrimSpace(string(line)), b.size, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_BreachCheckerLookup(t *testing.T) {
+	var (
+		lines     = make([]string, 0)
+		passwords = make([]string, 0)
+	)
+
+	for i := 0; i < 500; i++ {
+		passwords = append(passwords, "password"+strconv.Itoa(i))
+	}
+
+	for i, p := range passwords {
+		sum := sha1.Sum([]byte(p))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":"+strconv.Itoa(i+1))
+	}
+
+	sort.Strings(lines)
+
+	f, err := ioutil.TempFile("", "mbmi-breach")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(f.Name())
+
+	f.WriteString(strings.Join(lines, "\r\n") + "\r\n")
+	f.Close()
+
+	b, err := NewBreachChecker(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	for i, p := range passwords {
+		count, err := b.Breached(p)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != int64(i+1) {
+			t.Errorf("Expecting %s seen %d times, but got %d", p, i+1, count)
+		}
+	}
+
+	for _, p := range []string{"", "password500", "Secret123x"} {
+		if count, _ := b.Breached(p); count != 0 {
+			t.Errorf("Unexpected password %s found in the breaches", p)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"mbmi-go/models"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type Controller func(*http.Request, Enviroment) ResponseIface
@@ -21,6 +22,14 @@ func NewHandler(fn Controller, env Enviroment) http.HandlerFunc {
 			return
 		}
 
+		if stream, ok := response.(StreamIface); ok {
+			if err := stream.Stream(w); err != nil {
+				env.Error("%s: Stream is broken: %s", r.Context().Value("Id"), err.Error())
+			}
+
+			return
+		}
+
 		data, _ = response.Get()
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
@@ -34,14 +43,29 @@ func NewHandler(fn Controller, env Enviroment) http.HandlerFunc {
 
 // Wrap Controller function to prevent unauthorized requests
 func Protect(fn Controller) Controller {
+	return ProtectWith(fn, subjectAuthentication)
+}
+
+// ProtectWith wraps Controller function to allow requests with valid token
+// issued for one of the subjects
+func ProtectWith(fn Controller, subjects ...string) Controller {
 	return func(r *http.Request, env Enviroment) ResponseIface {
 		var (
+			allowed bool
+
 			id = r.Context().Value("Id").(string)
 			tk = r.Context().Value(tokenKey).(IdentityIface)
 		)
 
-		if tk.Subject() != "authentication" {
-			env.Error("%s: Invalid token: subject(authentication)=%s", id, tk.Subject())
+		for _, s := range subjects {
+			if tk.Subject() == s {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			env.Error("%s: Invalid token: subject(%s)=%s", id, strings.Join(subjects, ","), tk.Subject())
 
 			return NewResponse(&Error{
 				Code:    401,
@@ -66,13 +90,25 @@ func Protect(fn Controller) Controller {
 	}
 }
 
-// Authorization
+// Authorization of the managers
 func Login(r *http.Request, env Enviroment) ResponseIface {
+	return login(r, env, subjectAuthentication)
+}
+
+// SelfLogin authorizes mailbox owner to work with own account only
+func SelfLogin(r *http.Request, env Enviroment) ResponseIface {
+	return login(r, env, subjectSelf)
+}
+
+// login checks credentials and returns token for the subject, manager
+// role is required for the authentication subject
+func login(r *http.Request, env Enviroment, subject string) ResponseIface {
 	var (
-		err   error
-		model []*models.User
-		token *Token
-		claim TokenClaims
+		err    error
+		model  []*models.User
+		policy *models.PasswordPolicy
+		token  *Token
+		claim  TokenClaims
 
 		flt    = models.NewFilter()
 		form   = models.User{}
@@ -117,8 +153,11 @@ func Login(r *http.Request, env Enviroment) ResponseIface {
 
 	flt.Where("login", form.Login).
 		Where("domain", form.DomainName).
-		Where("passwd", form.Password).
-		Where("manager", 1)
+		Where("passwd", form.Password)
+
+	if subject == subjectAuthentication {
+		flt.Where("manager", 1)
+	}
 
 	if model, _, err = env.Users(flt, false); err != nil || len(model) != 1 {
 		if err != nil {
@@ -136,7 +175,24 @@ func Login(r *http.Request, env Enviroment) ResponseIface {
 		})
 	}
 
-	claim = NewClaims(model[0].Id, "authentication")
+	if policy, err = passwordPolicy(env, model[0].Domain); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch password policy from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	// Expired password must be changed before any other action
+	if passwordExpired(model[0], policy) {
+		env.Notice("%s: Password of %s is expired, token allows password change only", id, form.Email)
+
+		subject = subjectPassword
+	}
+
+	claim = NewClaims(model[0].Id, subject)
 	claim.Issuer = string(form.Email)
 
 	token = NewToken([]byte(secret)).Sign(claim)
@@ -217,10 +273,10 @@ func Transports(r *http.Request, env Enviroment) ResponseIface {
 		err   error
 		resp  *Response
 		m     []*models.Transport
+		flt   models.FilterIface
 
 		//tid int64 = -1
-		flt = models.NewFilter()
-		id  = r.Context().Value("Id")
+		id = r.Context().Value("Id")
 	)
 
 	if err = r.ParseForm(); err != nil {
@@ -233,9 +289,7 @@ func Transports(r *http.Request, env Enviroment) ResponseIface {
 		})
 	}
 
-	if _, ok := r.Form["domain"]; ok {
-		flt.Where("domain", r.Form.Get("domain")+"%")
-	}
+	flt = transportsFilter(r)
 
 	// Apply page limitation
 	helperLimit(r, flt)
@@ -256,6 +310,17 @@ func Transports(r *http.Request, env Enviroment) ResponseIface {
 	return resp
 }
 
+// transportsFilter returns transports list filter from the parsed query
+func transportsFilter(r *http.Request) models.FilterIface {
+	var flt = models.NewFilter()
+
+	if _, ok := r.Form["domain"]; ok {
+		flt.Where("domain", r.Form.Get("domain")+"%")
+	}
+
+	return flt
+}
+
 // Get Single transport item
 func Transport(r *http.Request, env Enviroment) ResponseIface {
 	var (
@@ -360,14 +425,22 @@ func MailSearch(r *http.Request, env Enviroment) ResponseIface {
 }
 
 // Get random passwords list
+//
+// Supported modes:
+// random - random characters, default set can be reduced with noupper,
+// nodigits, nosymbols switches or replaced with charset
+// passphrase - diceware words joined with separator
+// pronounceable - alternating consonants and vowels
 func Password(r *http.Request, env Enviroment) ResponseIface {
 	var (
 		count  uint64
 		err    error
 		resp   *Response
 		pass_l int
+		policy *models.PasswordPolicy
+		domain uint64
 
-		m  = make([]string, 3)
+		m  = make([]*GeneratedPassword, 3)
 		id = r.Context().Value("Id")
 	)
 
@@ -387,20 +460,64 @@ func Password(r *http.Request, env Enviroment) ResponseIface {
 		pass_l = 16
 	}
 
+	domain, _ = strconv.ParseUint(r.Form.Get("domain"), 10, 32)
+
+	if policy, err = passwordPolicy(env, uint(domain)); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch password policy from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
 	for l, i := len(m), 0; i < l; i++ {
-		var str string
+		switch r.Form.Get("mode") {
+		case "passphrase":
+			words, _ := strconv.Atoi(r.Form.Get("words"))
+			sep := "-"
 
-		if str, err = createSecret(pass_l, false, false, false); err != nil {
-			env.Error("%s: %s", id, err.Error())
+			if _, ok := r.Form["separator"]; ok {
+				sep = r.Form.Get("separator")
+			}
+
+			m[i], err = generatePassphrase(policy, words, sep)
+
+		case "pronounceable":
+			m[i], err = generatePronounceable(policy, pass_l)
+
+		case "", "random":
+			letters := r.Form.Get("charset")
 
+			if letters == "" {
+				letters = passwordAlphabet(
+					policy,
+					formBool(r, "noupper"),
+					formBool(r, "nodigits"),
+					formBool(r, "nosymbols"),
+				)
+			}
+
+			m[i], err = generateRandom(policy, pass_l, letters)
+
+		default:
 			return NewResponse(&Error{
 				Code:    500,
-				Message: "Cannot fetch transports from database",
+				Message: "Unknown password mode",
 				Title:   http.StatusText(500),
 			})
 		}
 
-		m[i] = str
+		if err != nil {
+			env.Error("%s: %s", id, err.Error())
+
+			return NewResponse(&Error{
+				Code:    500,
+				Message: err.Error(),
+				Title:   http.StatusText(500),
+			})
+		}
 	}
 
 	resp = NewResponse(m)
@@ -430,10 +547,21 @@ func secretWrap(fn Controller, secret string) Controller {
 
 // Helper to parse query and paste page limitation to the filler object
 func helperLimit(r *http.Request, flt models.FilterIface) {
-	var (
-		limit, offset uint64
-	)
+	flt.Limit(helperPage(r))
+}
+
+// Helper to read boolean switch from the query, accepts 1 or true
+func formBool(r *http.Request, name string) bool {
+	switch r.Form.Get(name) {
+	case "1", "true":
+		return true
+	}
+
+	return false
+}
 
+// Helper to parse page limitation from the query
+func helperPage(r *http.Request) (limit, offset uint64) {
 	limit = 10
 	offset = 0
 
@@ -445,5 +573,5 @@ func helperLimit(r *http.Request, flt models.FilterIface) {
 		offset, _ = strconv.ParseUint(r.Form.Get("offset"), 10, 64)
 	}
 
-	flt.Limit(limit, offset)
+	return
 }
@@ -12,9 +12,9 @@ func Accesses(r *http.Request, env Enviroment) ResponseIface {
 		err   error
 		resp  *Response
 		t     []*models.Access
+		flt   models.FilterIface
 
-		flt = models.NewFilter()
-		id  = r.Context().Value("Id")
+		id = r.Context().Value("Id")
 	)
 
 	if err = r.ParseForm(); err != nil {
@@ -27,9 +27,7 @@ func Accesses(r *http.Request, env Enviroment) ResponseIface {
 		})
 	}
 
-	if _, ok := r.Form["sort"]; ok {
-		flt.Order(r.Form.Get("sort"), false)
-	}
+	flt = accessesFilter(r)
 
 	// Apply page limitation
 	helperLimit(r, flt)
@@ -53,3 +51,14 @@ func Accesses(r *http.Request, env Enviroment) ResponseIface {
 
 	return resp
 }
+
+// accessesFilter returns access list filter from the parsed query
+func accessesFilter(r *http.Request) models.FilterIface {
+	var flt = models.NewFilter()
+
+	if _, ok := r.Form["sort"]; ok {
+		flt.Order(r.Form.Get("sort"), false)
+	}
+
+	return flt
+}
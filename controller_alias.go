@@ -5,6 +5,8 @@ import (
 	"mbmi-go/models"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Get Aliases list
@@ -14,9 +16,9 @@ func Aliases(r *http.Request, env Enviroment) ResponseIface {
 		err   error
 		resp  *Response
 		a     []*models.Alias
+		flt   models.FilterIface
 
-		flt = models.NewFilter()
-		id  = r.Context().Value("Id")
+		id = r.Context().Value("Id")
 	)
 
 	if err = r.ParseForm(); err != nil {
@@ -29,13 +31,7 @@ func Aliases(r *http.Request, env Enviroment) ResponseIface {
 		})
 	}
 
-	if _, ok := r.Form["alias"]; ok {
-		flt.Where("alias", r.Form.Get("alias"))
-	}
-
-	if _, ok := r.Form["recipient"]; ok {
-		flt.Where("recipient", r.Form.Get("recipient")+"%")
-	}
+	flt = aliasesFilter(r)
 
 	if g := r.Context().Value("Group"); g != nil {
 		flt.Group(g.(string))
@@ -60,6 +56,34 @@ func Aliases(r *http.Request, env Enviroment) ResponseIface {
 	return resp
 }
 
+// aliasesFilter returns aliases list filter from the parsed query
+func aliasesFilter(r *http.Request) models.FilterIface {
+	var flt = models.NewFilter()
+
+	if _, ok := r.Form["alias"]; ok {
+		flt.Where("alias", r.Form.Get("alias"))
+	}
+
+	if _, ok := r.Form["recipient"]; ok {
+		flt.Where("recipient", r.Form.Get("recipient")+"%")
+	}
+
+	// Temporary aliases expiring within the days
+	if days, err := strconv.ParseUint(r.Form.Get("expiring"), 10, 16); err == nil {
+		flt.Where("expiring", time.Now().AddDate(0, 0, int(days)))
+	}
+
+	return flt
+}
+
+// aliasAllowExternal returns allow_external flag of the query or the
+// form, it permits recipients outside of the hosted domains
+func aliasAllowExternal(r *http.Request) bool {
+	r.ParseForm()
+
+	return formBool(r, "allow_external")
+}
+
 // Get Alias by id
 func Alias(r *http.Request, env Enviroment) ResponseIface {
 	var (
@@ -123,6 +147,10 @@ func SetAlias(r *http.Request, env Enviroment) ResponseIface {
 		params = r.Context().Value("Params").(routerParams)
 	)
 
+	// The flag is not the alias field
+	external := aliasAllowExternal(r)
+	r.PostForm.Del("allow_external")
+
 	if err = parseFormTo(r, &form); err != nil {
 		env.Error("%s, %#v, %s", id, r.PostForm, err.Error())
 
@@ -133,28 +161,6 @@ func SetAlias(r *http.Request, env Enviroment) ResponseIface {
 		})
 	}
 
-	// Validate Recipient
-	if _, _, err = form.Recipient.Split(); err != nil {
-		env.Error("%s: %s", id, err.Error())
-
-		return NewResponse(&Error{
-			Code:    500,
-			Message: err.Error(),
-			Title:   http.StatusText(500),
-		})
-	}
-
-	// Validate Alias
-	if _, _, err = form.Alias.Split(); err != nil {
-		env.Error("%s, %s", id, err.Error())
-
-		return NewResponse(&Error{
-			Code:    500,
-			Message: err.Error(),
-			Title:   http.StatusText(500),
-		})
-	}
-
 	if r.Method == "PUT" {
 		// Check
 		if aid, err = strconv.ParseInt(params.ByName("aid"), 10, 64); err != nil || aid < 1 {
@@ -176,6 +182,10 @@ func SetAlias(r *http.Request, env Enviroment) ResponseIface {
 		form.Id = 0
 	}
 
+	if verr := validateAlias(env, id, &form, external); verr != nil {
+		return NewResponse(verr)
+	}
+
 	env.Debug("%s: Alias data is valid", id)
 
 	if err = env.SetAlias(&form); err != nil {
@@ -191,6 +201,179 @@ func SetAlias(r *http.Request, env Enviroment) ResponseIface {
 	return NewResponse(nil)
 }
 
+// validateAlias checks alias and recipient addresses and the validity
+// period. Recipient of the hosted domain must exist, external one is
+// accepted with the external flag only. Aliases must not loop
+func validateAlias(env Enviroment, id interface{}, form *models.Alias, external bool) *Error {
+	// Validate Recipient
+	if _, _, err := form.Recipient.Split(); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return &Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+		}
+	}
+
+	// Validate Alias
+	if _, _, err := form.Alias.Split(); err != nil {
+		env.Error("%s, %s", id, err.Error())
+
+		return &Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+		}
+	}
+
+	// Validate period
+	if form.ValidFrom != nil && form.ValidUntil != nil && !form.ValidUntil.After(*form.ValidFrom) {
+		env.Error("%s: Alias valid_until is not after valid_from", id)
+
+		return &Error{
+			Code:    500,
+			Message: "valid_until must be after valid_from",
+			Title:   http.StatusText(500),
+			Fields:  map[string]string{"valid_until": form.ValidUntil.Format(time.RFC3339)},
+		}
+	}
+
+	if verr := validateAliasRecipient(env, id, form, external); verr != nil {
+		return verr
+	}
+
+	return validateAliasLoop(env, id, form)
+}
+
+// validateAliasRecipient checks that the recipient of the hosted domain
+// is the user or alias
+func validateAliasRecipient(env Enviroment, id interface{}, form *models.Alias, external bool) *Error {
+	var (
+		err   error
+		t     []*models.Transport
+		u     []*models.User
+		list  []string
+		login string
+		dom   string
+	)
+
+	login, dom, _ = form.Recipient.Split()
+
+	if t, _, err = env.Transports(models.NewFilter().Where("domain", dom), false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return &Error{
+			Code:    500,
+			Message: "Cannot fetch transport from database",
+			Title:   http.StatusText(500),
+		}
+	}
+
+	if len(t) == 0 {
+		if external {
+			return nil
+		}
+
+		env.Error("%s: External recipient %s is not allowed", id, form.Recipient)
+
+		return &Error{
+			Code:    500,
+			Message: "Recipient domain is not hosted, use allow_external for external recipients",
+			Title:   http.StatusText(500),
+			Fields:  map[string]string{"recipient": string(form.Recipient)},
+		}
+	}
+
+	// Alias domain
+	if login == "" {
+		return nil
+	}
+
+	flt := models.NewFilter().
+		Where("login", login).
+		Where("domain", dom)
+
+	if u, _, err = env.Users(flt, false); err == nil && len(u) == 0 {
+		// Stored version of the alias does not count
+		rs := newResolver(env)
+		rs.pending = &models.Alias{Id: form.Id}
+		rs.period = "unexpired"
+
+		list, _, err = rs.aliases(string(form.Recipient))
+	}
+
+	if err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return &Error{
+			Code:    500,
+			Message: "Cannot fetch recipient from database",
+			Title:   http.StatusText(500),
+		}
+	}
+
+	if len(u) == 0 && len(list) == 0 {
+		env.Error("%s: Recipient %s does not exist", id, form.Recipient)
+
+		return &Error{
+			Code:    500,
+			Message: "Recipient is neither user nor alias",
+			Title:   http.StatusText(500),
+			Fields:  map[string]string{"recipient": string(form.Recipient)},
+		}
+	}
+
+	return nil
+}
+
+// validateAliasLoop walks the alias graph from the recipient and
+// returns the loop path if the alias is reached again
+func validateAliasLoop(env Enviroment, id interface{}, form *models.Alias) *Error {
+	var (
+		err  error
+		node *ResolveNode
+		loop []string
+
+		rs = newResolver(env)
+	)
+
+	rs.pending = form
+	rs.graph = true
+	rs.period = "unexpired"
+
+	if node, err = rs.expand(string(form.Recipient), ruleAlias, string(form.Alias), []string{string(form.Alias)}); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return &Error{
+			Code:    500,
+			Message: "Cannot fetch aliases from database",
+			Title:   http.StatusText(500),
+		}
+	}
+
+	node.walk(func(leaf *ResolveNode) {
+		if loop == nil && leaf.Path != nil {
+			loop = leaf.Path
+		}
+	})
+
+	if loop == nil {
+		return nil
+	}
+
+	path := strings.Join(loop, " -> ")
+
+	env.Error("%s: Alias loop %s", id, path)
+
+	return &Error{
+		Code:    500,
+		Message: "Alias loop: " + path,
+		Title:   http.StatusText(500),
+		Fields:  map[string]string{"recipient": path},
+	}
+}
+
 func DelAlias(r *http.Request, env Enviroment) ResponseIface {
 	var (
 		aid int64
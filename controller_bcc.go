@@ -15,9 +15,9 @@ func Bccs(r *http.Request, env Enviroment) ResponseIface {
 		params routerParams
 		bid    int64
 		b      []*models.BccItem
+		flt    models.FilterIface
 
 		cnt = true
-		flt = models.NewFilter()
 		id  = r.Context().Value("Id")
 	)
 
@@ -39,20 +39,9 @@ func Bccs(r *http.Request, env Enviroment) ResponseIface {
 		}
 
 		cnt = false
-		flt.Where("id", bid)
+		flt = models.NewFilter().Where("id", bid)
 	} else {
-		if v := r.FormValue("query"); v != "" {
-			flt.Where("search", "%"+v+"%")
-		}
-	}
-
-	if srt := r.FormValue("sort"); srt != "" {
-		dir := true
-		if r.FormValue("dir") == "desc" {
-			dir = false
-		}
-
-		flt.Order(srt, dir)
+		flt = bccsFilter(r)
 	}
 
 	if cnt {
@@ -89,6 +78,26 @@ func Bccs(r *http.Request, env Enviroment) ResponseIface {
 	})
 }
 
+// bccsFilter returns bcc list filter from the query
+func bccsFilter(r *http.Request) models.FilterIface {
+	var flt = models.NewFilter()
+
+	if v := r.FormValue("query"); v != "" {
+		flt.Where("search", "%"+v+"%")
+	}
+
+	if srt := r.FormValue("sort"); srt != "" {
+		dir := true
+		if r.FormValue("dir") == "desc" {
+			dir = false
+		}
+
+		flt.Order(srt, dir)
+	}
+
+	return flt
+}
+
 func SetBcc(r *http.Request, env Enviroment) ResponseIface {
 	var (
 		err error
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/subtle"
+	"mbmi-go/models"
+	"net/http"
+)
+
+// Passdb returns user password and login restrictions in the form of
+// Dovecot passdb fields
+func Passdb(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err    error
+		login  string
+		domain string
+		policy *models.PasswordPolicy
+		user   []*models.User
+		flt    models.FilterIface
+
+		id     = r.Context().Value("Id")
+		params = r.Context().Value("Params").(routerParams)
+		email  = models.Email(params.ByName("email"))
+	)
+
+	if login, domain, err = email.Split(); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(err)
+	}
+
+	flt = models.NewFilter().
+		Where("login", login).
+		Where("domain", domain)
+
+	if user, _, err = env.Users(flt, false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch user from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if len(user) != 1 {
+		env.Error("%s: Can't find user %s", id, email)
+
+		return NewResponse(&Error{
+			Code:    404,
+			Message: http.StatusText(404),
+			Title:   http.StatusText(404),
+		})
+	}
+
+	if policy, err = passwordPolicy(env, user[0].Domain); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch password policy from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(passdbFields(user[0], policy))
+}
+
+// Dovecot passdb lookup results returned by PassdbAuth
+const (
+	passdbOk               = "PASSDB_RESULT_OK"
+	passdbUserUnknown      = "PASSDB_RESULT_USER_UNKNOWN"
+	passdbPasswordMismatch = "PASSDB_RESULT_PASSWORD_MISMATCH"
+	passdbUserDisabled     = "PASSDB_RESULT_USER_DISABLED"
+	passdbPasswordExpired  = "PASSDB_RESULT_PASS_EXPIRED"
+)
+
+// PassdbResult is the reply of the Dovecot Lua passdb script
+type PassdbResult struct {
+	Result string            `json:"result"`
+	Reason string            `json:"reason,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// PassdbAuth verifies credentials for the Dovecot Lua passdb, checks
+// that the service is enabled and records the successful login to the
+// statistics. It replaces the /stat/imap webhook
+func PassdbAuth(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err    error
+		login  string
+		domain string
+		policy *models.PasswordPolicy
+		user   []*models.User
+
+		id = r.Context().Value("Id")
+	)
+
+	if err = r.ParseForm(); err != nil {
+		env.Error("%s, %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	var (
+		email    = models.Email(r.Form.Get("user"))
+		password = r.Form.Get("password")
+		service  = r.Form.Get("service")
+		ip       = r.Form.Get("remote_ip")
+	)
+
+	if login, domain, err = email.Split(); err != nil || password == "" {
+		return NewResponse(&PassdbResult{Result: passdbUserUnknown})
+	}
+
+	flt := models.NewFilter().
+		Where("login", login).
+		Where("domain", domain)
+
+	if user, _, err = env.Users(flt, false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch user from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if len(user) != 1 {
+		env.Notice("%s: %s login of unknown user %s from %s", id, service, email, ip)
+
+		return NewResponse(&PassdbResult{Result: passdbUserUnknown})
+	}
+
+	u := user[0]
+
+	if subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) != 1 {
+		env.Notice("%s: %s login of %s from %s with wrong password", id, service, email, ip)
+
+		return NewResponse(&PassdbResult{Result: passdbPasswordMismatch})
+	}
+
+	if !serviceAllowed(u, service) {
+		env.Notice("%s: %s login of %s from %s, service is disabled", id, service, email, ip)
+
+		return NewResponse(&PassdbResult{
+			Result: passdbUserDisabled,
+			Reason: "Service " + service + " is disabled",
+		})
+	}
+
+	if policy, err = passwordPolicy(env, u.Domain); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch password policy from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	fields := passdbFields(u, policy)
+	delete(fields, "password")
+
+	if fields["nologin"] != "" {
+		return NewResponse(&PassdbResult{
+			Result: passdbPasswordExpired,
+			Reason: fields["reason"],
+		})
+	}
+
+	if err = env.SetStatImapLogin(&models.Stat{UID: u.Id, Service: service, IP: ip}); err != nil {
+		// Login is not refused because of the statistics
+		env.Error("%s: %s", id, err.Error())
+	}
+
+	return NewResponse(&PassdbResult{
+		Result: passdbOk,
+		Fields: fields,
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"net/http"
+)
+
+// userColumns are exported user fields, credentials are never exported
+var userColumns = []string{
+	"id", "name", "login", "domain", "domainname", "email", "uid", "gid",
+	"smtp", "imap", "pop3", "sieve", "manager", "must_change", "password_changed_at",
+}
+
+// Export streams users, aliases, bccs, accesses or transports in csv, json
+// or ndjson format. List filters are applied, page limitation is not
+func Export(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err     error
+		columns []string
+		each    func(func([]interface{}) error) error
+
+		id     = r.Context().Value("Id")
+		params = r.Context().Value("Params").(routerParams)
+		entity = params.ByName("entity")
+	)
+
+	if err = r.ParseForm(); err != nil {
+		env.Error("%s, %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	format := r.Form.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	if _, ok := exportFormats[format]; !ok {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Unknown export format " + format,
+			Title:   http.StatusText(500),
+		})
+	}
+
+	switch entity {
+	case "users":
+		flt := usersFilter(r)
+		columns = userColumns
+		each = func(fn func([]interface{}) error) error {
+			return env.UsersEach(flt, func(i *models.User) error {
+				return fn([]interface{}{
+					i.Id, i.Name, i.Login, i.Domain, i.DomainName, i.Email, i.Uid, i.Gid,
+					i.Smtp, i.Imap, i.Pop3, i.Sieve, i.Manager, i.MustChange, i.PasswordChanged,
+				})
+			})
+		}
+
+	case "aliases":
+		// Expired aliases are left for the cleanup
+		flt := aliasesFilter(r).Where("unexpired", nil)
+		columns = []string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}
+		each = func(fn func([]interface{}) error) error {
+			return env.AliasesEach(flt, func(i *models.Alias) error {
+				return fn([]interface{}{i.Id, i.Alias, i.Recipient, i.Comment, i.ValidFrom, i.ValidUntil})
+			})
+		}
+
+	case "bccs":
+		flt := bccsFilter(r)
+		columns = []string{"id", "sender", "recipient", "copy", "comment"}
+		each = func(fn func([]interface{}) error) error {
+			return env.BccsEach(flt, func(i *models.BccItem) error {
+				return fn([]interface{}{i.ID, i.Sender, i.Recipient, i.Copy, i.Comment})
+			})
+		}
+
+	case "accesses":
+		flt := accessesFilter(r)
+		columns = []string{"client", "access"}
+		each = func(fn func([]interface{}) error) error {
+			return env.AccessesEach(flt, func(i *models.Access) error {
+				return fn([]interface{}{i.Client, i.Access})
+			})
+		}
+
+	case "transports":
+		flt := transportsFilter(r)
+		columns = []string{"id", "domain", "transport", "rootdir", "uid", "gid"}
+		each = func(fn func([]interface{}) error) error {
+			return env.TransportsEach(flt, func(i *models.Transport) error {
+				return fn([]interface{}{i.Id, i.Domain, i.Transport, i.Root, i.Uid, i.Gid})
+			})
+		}
+
+	default:
+		return NewResponse(&Error{
+			Code:    404,
+			Message: "Unknown export " + entity,
+			Title:   http.StatusText(404),
+		})
+	}
+
+	env.Info("%s: Export of %s in %s", id, entity, format)
+
+	return &Stream{
+		ContentType: exportFormats[format],
+		Filename:    entity + "." + format,
+		Write: func(w http.ResponseWriter) (err error) {
+			var ew = newExportWriter(format, w, columns)
+
+			if err = each(ew.Write); err != nil {
+				return
+			}
+
+			return ew.Close()
+		},
+	}
+}
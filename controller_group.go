@@ -0,0 +1,462 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"mbmi-go/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Groups returns list of the distribution groups with the member count
+func Groups(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		count uint64
+		err   error
+		resp  *Response
+		g     []*models.Group
+
+		flt = models.NewFilter()
+		id  = r.Context().Value("Id")
+	)
+
+	if err = r.ParseForm(); err != nil {
+		env.Error("%s, %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if v := r.Form.Get("search"); v != "" {
+		flt.Where("search", "%"+v+"%")
+	}
+
+	if formBool(r, "restricted") {
+		flt.Where("restricted", nil)
+	}
+
+	switch srt := r.Form.Get("sort"); srt {
+	case "":
+		flt.Order("address", true)
+
+	case "id", "address", "members":
+		flt.Order(srt, r.Form.Get("dir") != "desc")
+
+	default:
+		env.Error("%s: Unknown sort key %s", id, srt)
+
+		return NewResponse(&Error{
+			Code:    400,
+			Message: "Unknown sort key",
+			Title:   http.StatusText(400),
+			Fields:  map[string]string{"sort": srt},
+		})
+	}
+
+	// Apply page limitation
+	helperLimit(r, flt)
+
+	if g, count, err = env.Groups(flt, true); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch groups from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	resp = NewResponse(g)
+	resp.Count = count
+
+	return resp
+}
+
+// Group returns the group with the member list
+func Group(r *http.Request, env Enviroment) ResponseIface {
+	g, resp := paramGroup(r, env)
+	if resp != nil {
+		return resp
+	}
+
+	return NewResponse(g)
+}
+
+// SetGroup creates or updates the group, members are not changed
+func SetGroup(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		old  *models.Group
+		resp ResponseIface
+
+		form = models.Group{}
+		id   = r.Context().Value("Id")
+	)
+
+	if err = parseFormTo(r, &form); err != nil {
+		env.Error("%s, %#v, %s", id, r.PostForm, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	form.Id = 0
+
+	if r.Method == "PUT" {
+		if old, resp = paramGroup(r, env); resp != nil {
+			return resp
+		}
+
+		form.Id = old.Id
+	}
+
+	if verr := validateGroup(env, id, &form); verr != nil {
+		return NewResponse(verr)
+	}
+
+	if verr := validateGroupAddress(env, id, &form, old); verr != nil {
+		return NewResponse(verr)
+	}
+
+	if err = env.SetGroup(&form); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot save group data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(&form)
+}
+
+// validateGroup checks group address, send policy and allowed senders.
+// Sender is the address or the domain
+func validateGroup(env Enviroment, id interface{}, form *models.Group) *Error {
+	if _, _, err := form.Address.Split(); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return &Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+			Fields:  map[string]string{"address": err.Error()},
+		}
+	}
+
+	switch form.SendPolicy {
+	case "":
+		form.SendPolicy = models.GroupSendAnyone
+	case models.GroupSendAnyone, models.GroupSendMembers, models.GroupSendSenders:
+	default:
+		env.Error("%s: Unknown send policy %s", id, form.SendPolicy)
+
+		return &Error{
+			Code:    500,
+			Message: "Unknown send policy",
+			Title:   http.StatusText(500),
+			Fields:  map[string]string{"send_policy": form.SendPolicy},
+		}
+	}
+
+	var senders = make([]string, 0, len(form.Senders))
+
+	for _, s := range form.Senders {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		e := models.Email(s)
+		if !strings.Contains(s, "@") {
+			e = models.Email("@" + s)
+		}
+
+		if _, _, err := e.Split(); err != nil {
+			env.Error("%s: %s %s", id, err.Error(), s)
+
+			return &Error{
+				Code:    500,
+				Message: err.Error(),
+				Title:   http.StatusText(500),
+				Fields:  map[string]string{"senders": s},
+			}
+		}
+
+		senders = append(senders, s)
+	}
+
+	form.Senders = senders
+
+	return nil
+}
+
+// validateGroupAddress checks the new or changed address the way the
+// rename does: it must not be used by another user, alias or group, and
+// the members moved to it must not loop back. Old is nil on create
+func validateGroupAddress(env Enviroment, id interface{}, form, old *models.Group) *Error {
+	var (
+		err    error
+		users  []*models.User
+		groups []*models.Group
+		count  uint64
+	)
+
+	if old != nil && strings.EqualFold(string(form.Address), string(old.Address)) {
+		return nil
+	}
+
+	login, domain, _ := form.Address.Split()
+
+	flt := models.NewFilter().
+		Where("login", login).
+		Where("domain", domain)
+
+	if users, _, err = env.Users(flt, false); err == nil {
+		_, count, err = env.Aliases(models.NewFilter().Where("alias", form.Address), true)
+	}
+
+	if err == nil {
+		groups, _, err = env.Groups(models.NewFilter().Where("address", form.Address), false)
+	}
+
+	if err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return &Error{
+			Code:    500,
+			Message: "Cannot check address usage",
+			Title:   http.StatusText(500),
+		}
+	}
+
+	for _, g := range groups {
+		if g.Id != form.Id {
+			count++
+		}
+	}
+
+	if len(users) > 0 || count > 0 {
+		env.Error("%s: Address %s is used by %d users and %d aliases or groups", id, form.Address, len(users), count)
+
+		return &Error{
+			Code:    500,
+			Message: "Address is already used",
+			Title:   http.StatusText(500),
+			Fields:  map[string]string{"address": "Address is already used"},
+		}
+	}
+
+	if old == nil {
+		return nil
+	}
+
+	for _, m := range old.Members {
+		if verr := validateAliasLoop(env, id, &models.Alias{Alias: form.Address, Recipient: m}); verr != nil {
+			return verr
+		}
+	}
+
+	return nil
+}
+
+// DelGroup removes the group, members are moved to the trash
+func DelGroup(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err error
+		gid int64
+
+		id = r.Context().Value("Id")
+	)
+
+	if gid, err = paramGroupId(r); err != nil {
+		env.Error("%s: %s (id=%d)", id, err.Error(), gid)
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if err = env.DelGroup(gid); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		if err == sql.ErrNoRows {
+			return NewResponse(&Error{
+				Code:    404,
+				Message: http.StatusText(404),
+				Title:   http.StatusText(404),
+			})
+		}
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot remove group data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(nil)
+}
+
+// GroupMembers adds (POST), removes (DELETE) or replaces (PUT) the group
+// members given by the member fields, DELETE takes them from the query.
+// Added members are validated as the aliases, allow_external permits
+// external recipients
+func GroupMembers(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		op   string
+		g    *models.Group
+		resp ResponseIface
+		list []models.Email
+
+		id = r.Context().Value("Id")
+	)
+
+	switch r.Method {
+	case "POST":
+		op = models.GroupMembersAdd
+	case "DELETE":
+		op = models.GroupMembersRemove
+	default:
+		op = models.GroupMembersReplace
+	}
+
+	if err = r.ParseForm(); err != nil {
+		env.Error("%s, %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if g, resp = paramGroup(r, env); resp != nil {
+		return resp
+	}
+
+	for _, m := range r.Form["member"] {
+		if m = strings.TrimSpace(m); m != "" {
+			list = append(list, models.Email(m))
+		}
+	}
+
+	if len(list) == 0 && op != models.GroupMembersReplace {
+		env.Error("%s: No members given", id)
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "member is required",
+			Title:   http.StatusText(500),
+			Fields:  map[string]string{"member": "required"},
+		})
+	}
+
+	if op != models.GroupMembersRemove {
+		for _, m := range list {
+			form := &models.Alias{Alias: g.Address, Recipient: m}
+
+			if verr := validateAlias(env, id, form, aliasAllowExternal(r)); verr != nil {
+				return NewResponse(verr)
+			}
+		}
+	}
+
+	if err = env.SetGroupMembers(g.Id, op, list); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot save group members",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if g, resp = loadGroup(env, id, g.Id); resp != nil {
+		return resp
+	}
+
+	return NewResponse(g)
+}
+
+// paramGroupId returns group id of the route
+func paramGroupId(r *http.Request) (gid int64, err error) {
+	params := r.Context().Value("Params").(routerParams)
+
+	if gid, err = strconv.ParseInt(params.ByName("gid"), 10, 64); err == nil && gid < 1 {
+		err = errors.New("Invalid record id")
+	}
+
+	return
+}
+
+// paramGroup returns group of the route with the members
+func paramGroup(r *http.Request, env Enviroment) (*models.Group, ResponseIface) {
+	var id = r.Context().Value("Id")
+
+	gid, err := paramGroupId(r)
+	if err != nil {
+		return nil, NewResponse(&Error{
+			Code:    404,
+			Message: "empty group id",
+			Title:   http.StatusText(404),
+		})
+	}
+
+	return loadGroup(env, id, gid)
+}
+
+// loadGroup returns the group with the members
+func loadGroup(env Enviroment, id interface{}, gid int64) (*models.Group, ResponseIface) {
+	g, _, err := env.Groups(models.NewFilter().Where("id", gid), false)
+	if err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return nil, NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch group from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if len(g) != 1 {
+		env.Error("%s: Can't find group with id=(%d)", id, gid)
+
+		return nil, NewResponse(&Error{
+			Code:    404,
+			Message: http.StatusText(404),
+			Title:   http.StatusText(404),
+		})
+	}
+
+	g[0].Members = make([]models.Email, 0)
+
+	flt := models.NewFilter().
+		Where("alias", string(g[0].Address)).
+		Order("id", true)
+
+	if err = env.AliasesEach(flt, func(a *models.Alias) error {
+		g[0].Members = append(g[0].Members, a.Recipient)
+		return nil
+	}); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return nil, NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch group members from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return g[0], nil
+}
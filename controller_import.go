@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"mbmi-go/models"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ImportUsers creates mailboxes from CSV or JSON rows. Domain is the
+// transport id or name, email can be used instead of login and domain.
+// Rows are validated as SetUser does and saved all or nothing, mailbox
+// hooks are called once the rows are committed
+func ImportUsers(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err        error
+		transports []*models.Transport
+		created    []*models.User
+
+		domains = make(map[string]uint)
+		id      = r.Context().Value("Id")
+	)
+
+	if transports, _, err = env.Transports(nil, false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch transports from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	for _, t := range transports {
+		domains[t.Domain] = uint(t.Id)
+	}
+
+	commit := func() {
+		for _, u := range created {
+			runMailboxHook(env, id, hookCreated, u)
+		}
+	}
+
+	return runImport(r, env, commit, func(tenv Enviroment, v url.Values, res *ImportResult) {
+		var (
+			err    error
+			policy *models.PasswordPolicy
+			verr   *Error
+
+			form = models.User{}
+		)
+
+		// Email is split before decoding, domain name is replaced
+		// with the transport id
+		if email := v.Get("email"); email != "" && v.Get("login") == "" {
+			login, domain, err := models.Email(email).Split()
+			if err != nil {
+				res.invalid(&Error{Message: err.Error(), Fields: map[string]string{"email": err.Error()}})
+				return
+			}
+
+			v.Set("login", login)
+			v.Set("domain", domain)
+		}
+
+		v.Del("email")
+
+		if d := v.Get("domain"); d != "" {
+			if _, err = strconv.ParseUint(d, 10, 32); err != nil {
+				if did, ok := domains[d]; ok {
+					v.Set("domain", strconv.FormatUint(uint64(did), 10))
+				}
+			}
+		}
+
+		res.Key = v.Get("login")
+
+		if err = formDecoder().Decode(&form, v); err != nil {
+			res.invalid(&Error{Message: err.Error()})
+			return
+		}
+
+		// Import creates new records only
+		form.Id = 0
+
+		if policy, verr = validateUser(tenv, fmt.Sprintf("%s row %d", id, res.Row), &form); verr != nil {
+			res.invalid(verr)
+			return
+		}
+
+		res.Key = string(form.Email)
+
+		if err = tenv.SetUser(&form); err != nil {
+			env.Error("%s: %s", id, err.Error())
+			res.invalid(&Error{Message: "Cannot save user data: " + err.Error()})
+			return
+		}
+
+		if policy != nil && policy.History > 0 {
+			var hash string
+
+			if hash, err = passwordHash(form.Password); err == nil {
+				err = tenv.SetPasswordHistory(form.Id, hash)
+			}
+
+			if err != nil {
+				res.invalid(&Error{Message: "Cannot save password history: " + err.Error()})
+				return
+			}
+		}
+
+		res.Id = form.Id
+		created = append(created, &form)
+	})
+}
+
+// ImportAliases creates aliases from CSV or JSON rows, all or nothing
+func ImportAliases(r *http.Request, env Enviroment) ResponseIface {
+	var id = r.Context().Value("Id")
+
+	return runImport(r, env, nil, func(tenv Enviroment, v url.Values, res *ImportResult) {
+		var (
+			err  error
+			verr *Error
+
+			form = models.Alias{}
+		)
+
+		res.Key = v.Get("alias")
+
+		if err = formDecoder().Decode(&form, v); err != nil {
+			res.invalid(&Error{Message: err.Error()})
+			return
+		}
+
+		form.Id = 0
+
+		if verr = validateAlias(tenv, fmt.Sprintf("%s row %d", id, res.Row), &form, aliasAllowExternal(r)); verr != nil {
+			res.invalid(verr)
+			return
+		}
+
+		if err = tenv.SetAlias(&form); err != nil {
+			env.Error("%s: %s", id, err.Error())
+			res.invalid(&Error{Message: "Cannot save alias data: " + err.Error()})
+			return
+		}
+
+		res.Id = form.Id
+	})
+}
+
+// runImport reads rows and calls fn for each one in the transaction.
+// The transaction is rolled back if any row failed or on dry run,
+// commit is called after the rows are saved
+func runImport(r *http.Request, env Enviroment, commit func(), fn func(Enviroment, url.Values, *ImportResult)) ResponseIface {
+	var (
+		err  error
+		rows []url.Values
+		resp *Response
+
+		report = &ImportReport{DryRun: importDryRun(r), Rows: make([]*ImportResult, 0)}
+		id     = r.Context().Value("Id")
+	)
+
+	if rows, err = importRows(r); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse import data: " + err.Error(),
+			Title:   http.StatusText(500),
+		})
+	}
+
+	err = transaction(env, func(tenv Enviroment) error {
+		for i, v := range rows {
+			var res = &ImportResult{Row: i + 1, Status: importCreated}
+
+			if report.DryRun {
+				res.Status = importValid
+			}
+
+			fn(tenv, v, res)
+
+			report.Rows = append(report.Rows, res)
+		}
+
+		return report.finish()
+	})
+
+	switch err {
+	case nil:
+		report.Committed = true
+
+		if commit != nil {
+			commit()
+		}
+
+	case errDryRun:
+
+	case errImportFailed:
+		env.Error("%s: %d of %d rows failed", id, report.Failed, report.Total)
+
+		resp = NewResponse(report)
+		resp.Success = false
+		resp.Error = &Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+		}
+
+		return resp
+
+	default:
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot save imported data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	resp = NewResponse(report)
+	resp.Count = uint64(report.Total)
+
+	return resp
+}
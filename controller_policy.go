@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"mbmi-go/models"
+	"net/http"
+	"strconv"
+)
+
+// PasswordPolicy returns effective password policy, common or
+// overridden for the domain
+func PasswordPolicy(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err    error
+		domain uint64
+		p      *models.PasswordPolicy
+
+		id = r.Context().Value("Id")
+	)
+
+	if err = r.ParseForm(); err != nil {
+		env.Error("%s, %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	domain, _ = strconv.ParseUint(r.Form.Get("domain"), 10, 32)
+
+	if p, err = passwordPolicy(env, uint(domain)); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch password policy from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(p)
+}
+
+// SetPasswordPolicy overrides password policy for the domain
+func SetPasswordPolicy(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		did uint64
+		err error
+
+		form   = models.PasswordPolicy{}
+		id     = r.Context().Value("Id")
+		params = r.Context().Value("Params").(routerParams)
+	)
+
+	if err = parseFormTo(r, &form); err != nil {
+		env.Error("%s, %#v, %s", id, r.PostForm, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if did, err = strconv.ParseUint(params.ByName("did"), 10, 32); err != nil || did < 1 {
+		if err == nil {
+			err = errors.New("Invalid domain id")
+		}
+
+		env.Error("%s: %s (id=%d)", id, err.Error(), did)
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+		})
+	}
+
+	fields := make(map[string]string)
+
+	if form.MinLength < 1 {
+		fields["min_length"] = "must be positive"
+	}
+
+	if form.History < 0 {
+		fields["history"] = "can't be negative"
+	}
+
+	if len(fields) > 0 {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Invalid policy data",
+			Title:   http.StatusText(500),
+			Fields:  fields,
+		})
+	}
+
+	form.Domain = uint(did)
+
+	if err = env.SetPasswordPolicy(&form); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot save password policy",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(nil)
+}
+
+// DelPasswordPolicy removes domain policy, common policy will be used
+func DelPasswordPolicy(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		did uint64
+		err error
+
+		id     = r.Context().Value("Id")
+		params = r.Context().Value("Params").(routerParams)
+	)
+
+	if did, err = strconv.ParseUint(params.ByName("did"), 10, 32); err != nil || did < 1 {
+		if err == nil {
+			err = errors.New("Invalid domain id")
+		}
+
+		env.Error("%s: %s (id=%d)", id, err.Error(), did)
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if err = env.DelPasswordPolicy(uint(did)); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot remove password policy",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(nil)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"net/http"
+	"strconv"
+)
+
+// RenameUser changes mailbox login and rewrites aliases, bcc rules and
+// group senders referencing the old address. With keep_alias the old
+// address is left as alias to the new one. Change summary is returned
+func RenameUser(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+		resp ResponseIface
+		t    *models.Transport
+
+		id = r.Context().Value("Id")
+	)
+
+	if user, resp = paramUser(r, env); resp != nil {
+		return resp
+	}
+
+	if err = r.ParseForm(); err != nil {
+		env.Error("%s, %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	rename := &models.Rename{
+		UID:       user.Id,
+		From:      user.Email,
+		Login:     r.Form.Get("login"),
+		Domain:    user.Domain,
+		Uid:       user.Uid,
+		Gid:       user.Gid,
+		KeepAlias: models.Boolean(formBool(r, "keep_alias")),
+	}
+
+	rename.To = models.Email(rename.Login + "@" + user.DomainName)
+
+	if verr := validateRename(env, id, rename); verr != nil {
+		return NewResponse(verr)
+	}
+
+	if t, resp = userTransport(env, id, user.Domain); resp != nil {
+		return resp
+	}
+
+	if resp = relocateUser(env, id, rename, t, t); resp != nil {
+		return resp
+	}
+
+	env.Notice("%s: User %s renamed to %s", id, rename.From, rename.To)
+
+	return NewResponse(rename)
+}
+
+// MoveUser moves mailbox to another domain, the maildir is relocated to
+// the target transport root and the user gets its uid and gid. Login is
+// kept unless the new one is passed
+func MoveUser(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+		resp ResponseIface
+		from *models.Transport
+		to   *models.Transport
+
+		id = r.Context().Value("Id")
+	)
+
+	if user, resp = paramUser(r, env); resp != nil {
+		return resp
+	}
+
+	if err = r.ParseForm(); err != nil {
+		env.Error("%s, %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	domain, err := strconv.ParseUint(r.Form.Get("domain"), 10, 64)
+	if err != nil || domain == 0 {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Target domain required",
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"domain": "required",
+			},
+		})
+	}
+
+	if uint(domain) == user.Domain {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Mailbox is already in the domain",
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"domain": "Mailbox is already in the domain",
+			},
+		})
+	}
+
+	if from, resp = userTransport(env, id, user.Domain); resp != nil {
+		return resp
+	}
+
+	if to, resp = userTransport(env, id, uint(domain)); resp != nil {
+		return resp
+	}
+
+	if to.Transport != from.Transport {
+		// Maildir is delivered locally only by the same transport
+		env.Error("%s: Transport %s of %s differs from %s", id, to.Transport, to.Domain, from.Transport)
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Target domain uses another transport",
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"domain": "Target domain uses another transport",
+			},
+		})
+	}
+
+	rename := &models.Rename{
+		UID:       user.Id,
+		From:      user.Email,
+		Login:     user.Login,
+		Domain:    uint(to.Id),
+		KeepAlias: models.Boolean(formBool(r, "keep_alias")),
+	}
+
+	if login := r.Form.Get("login"); login != "" {
+		rename.Login = login
+	}
+
+	// Zero ids mean the transport ones and stay as is
+	if user.Uid != 0 {
+		rename.Uid, rename.Gid = to.Uid, to.Gid
+	}
+
+	rename.To = models.Email(rename.Login + "@" + to.Domain)
+
+	if verr := validateRename(env, id, rename); verr != nil {
+		return NewResponse(verr)
+	}
+
+	if resp = relocateUser(env, id, rename, from, to); resp != nil {
+		return resp
+	}
+
+	env.Notice("%s: User %s moved to %s", id, rename.From, rename.To)
+
+	return NewResponse(rename)
+}
+
+// userTransport returns transport of the domain
+func userTransport(env Enviroment, id interface{}, domain uint) (*models.Transport, ResponseIface) {
+	t, _, err := env.Transports(models.NewFilter().Where("id", domain), false)
+
+	if err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return nil, NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch transport from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if len(t) != 1 {
+		env.Error("%s: Can't find transport with id=(%d)", id, domain)
+
+		return nil, NewResponse(&Error{
+			Code:    500,
+			Message: "Unknown domain",
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"domain": "Unknown domain",
+			},
+		})
+	}
+
+	return t[0], nil
+}
+
+// relocateUser moves maildir to the new address location and saves the
+// rename. The maildir is returned back if the database update fails
+func relocateUser(env Enviroment, id interface{}, rename *models.Rename, from, to *models.Transport) ResponseIface {
+	var (
+		err  error
+		move *mailboxMove
+
+		src string
+		dst string
+
+		login, domain, _       = rename.From.Split()
+		newLogin, newDomain, _ = rename.To.Split()
+	)
+
+	if src, err = mailboxDir(from, login, domain); err == nil {
+		dst, err = mailboxDir(to, newLogin, newDomain)
+	}
+
+	if err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Invalid mailbox location",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if move, err = moveMailbox(src, dst); err != nil {
+		env.Error("%s: Cannot move mailbox %s to %s: %s", id, src, dst, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot move mailbox",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if err = env.RenameUser(rename); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		if rerr := move.revert(); rerr != nil {
+			env.Error("%s: Cannot return mailbox %s back to %s: %s", id, dst, src, rerr.Error())
+		}
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot rename user",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if move == nil {
+		return nil
+	}
+
+	rename.MailboxFrom, rename.MailboxTo = src, dst
+
+	uid, gid := rename.Uid, rename.Gid
+	if uid == 0 {
+		uid, gid = to.Uid, to.Gid
+	}
+
+	// Database is already changed, so the mailbox stays at the new place
+	if err = move.finish(uid, gid); err != nil {
+		env.Error("%s: Cannot finish mailbox move to %s: %s", id, dst, err.Error())
+	}
+
+	return nil
+}
+
+// validateRename checks new address and that it is not used by another
+// mailbox or alias
+func validateRename(env Enviroment, id interface{}, rename *models.Rename) *Error {
+	var (
+		err    error
+		login  string
+		domain string
+		users  []*models.User
+		count  uint64
+	)
+
+	if login, domain, err = rename.To.Split(); err == nil {
+		err = checkMailboxLogin(login)
+	}
+
+	if err != nil {
+		return &Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"login": err.Error(),
+			},
+		}
+	}
+
+	if rename.To == rename.From {
+		return &Error{
+			Code:    500,
+			Message: "Address is not changed",
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"login": "Address is not changed",
+			},
+		}
+	}
+
+	flt := models.NewFilter().
+		Where("login", login).
+		Where("domain", domain)
+
+	if users, _, err = env.Users(flt, false); err == nil {
+		_, count, err = env.Aliases(models.NewFilter().Where("alias", rename.To), true)
+	}
+
+	if err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return &Error{
+			Code:    500,
+			Message: "Cannot check address usage",
+			Title:   http.StatusText(500),
+		}
+	}
+
+	if len(users) > 0 || count > 0 {
+		env.Error("%s: Address %s is used by %d users and %d aliases", id, rename.To, len(users), count)
+
+		return &Error{
+			Code:    500,
+			Message: "Address is already used",
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"login": "Address is already used",
+			},
+		}
+	}
+
+	return nil
+}
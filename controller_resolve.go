@@ -0,0 +1,44 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"net/http"
+)
+
+// Resolve simulates delivery of the message to the address: aliases are
+// expanded recursively with catch-all and alias domain rules, sender and
+// recipient bcc are added and the transport of each final recipient
+// is selected. The whole derivation tree is returned
+func Resolve(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err error
+		res *Resolution
+
+		id      = r.Context().Value("Id")
+		address = r.FormValue("address")
+		sender  = r.FormValue("sender")
+	)
+
+	if _, _, err = models.Email(address).Split(); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    400,
+			Message: "Invalid address",
+			Title:   http.StatusText(400),
+			Fields:  map[string]string{"address": err.Error()},
+		})
+	}
+
+	if res, err = resolveAddress(env, address, sender); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot resolve address",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(res)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"mbmi-go/models"
+	"net/http"
+	"strconv"
+)
+
+// SelfAccount returns account of the token owner
+func SelfAccount(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+	)
+
+	if user, err = selfUser(r, env); err != nil {
+		return selfError(err)
+	}
+
+	// Do not send credentials back
+	user.Password = ""
+
+	return NewResponse(user)
+}
+
+// SelfForwards returns forwarding aliases of the token owner
+func SelfForwards(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+		a    []*models.Alias
+		resp *Response
+
+		id = r.Context().Value("Id")
+	)
+
+	if user, err = selfUser(r, env); err != nil {
+		return selfError(err)
+	}
+
+	if a, _, err = env.Aliases(models.NewFilter().Where("alias", user.Email), false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch aliases from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	resp = NewResponse(a)
+	resp.Count = uint64(len(a))
+
+	return resp
+}
+
+// SetSelfForward adds forwarding of the token owner mail to the recipient
+func SetSelfForward(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+
+		form = models.Alias{}
+		id   = r.Context().Value("Id")
+	)
+
+	if user, err = selfUser(r, env); err != nil {
+		return selfError(err)
+	}
+
+	if err = parseFormTo(r, &form); err != nil {
+		env.Error("%s, %#v, %s", id, r.PostForm, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if _, _, err = form.Recipient.Split(); err != nil || form.Recipient == user.Email {
+		if err == nil {
+			err = errors.New("Forwarding to itself")
+		}
+
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"recipient": err.Error(),
+			},
+		})
+	}
+
+	form.Id = 0
+	form.Alias = user.Email
+
+	// Forwarding out is the point, hosted recipients must still exist
+	if verr := validateAlias(env, id, &form, true); verr != nil {
+		return NewResponse(verr)
+	}
+
+	if err = env.SetAlias(&form); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot save alias data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(nil)
+}
+
+// DelSelfForward removes forwarding alias of the token owner
+func DelSelfForward(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		aid  int64
+		err  error
+		a    []*models.Alias
+		user *models.User
+
+		id     = r.Context().Value("Id")
+		params = r.Context().Value("Params").(routerParams)
+	)
+
+	if user, err = selfUser(r, env); err != nil {
+		return selfError(err)
+	}
+
+	if aid, err = strconv.ParseInt(params.ByName("aid"), 10, 64); err != nil || aid < 1 {
+		return NewResponse(&Error{
+			Code:    404,
+			Message: "empty alias id",
+			Title:   http.StatusText(404),
+		})
+	}
+
+	flt := models.NewFilter().
+		Where("id", aid).
+		Where("alias", user.Email)
+
+	if a, _, err = env.Aliases(flt, false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch alias from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	// Alias of another address is hidden as not existing
+	if len(a) != 1 {
+		env.Error("%s: Can't find alias with id=(%d) of %s", id, aid, user.Email)
+
+		return NewResponse(&Error{
+			Code:    404,
+			Message: http.StatusText(404),
+			Title:   http.StatusText(404),
+		})
+	}
+
+	if err = env.DelAlias(aid); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot remove alias data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(nil)
+}
+
+// SelfVacation returns auto reply of the token owner
+func SelfVacation(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+	)
+
+	if user, err = selfUser(r, env); err != nil {
+		return selfError(err)
+	}
+
+	return vacationOf(r, env, user)
+}
+
+// SetSelfVacation saves auto reply of the token owner
+func SetSelfVacation(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+	)
+
+	if user, err = selfUser(r, env); err != nil {
+		return selfError(err)
+	}
+
+	return saveVacation(r, env, user)
+}
+
+// SelfSieveRules returns filter rules of the token owner
+func SelfSieveRules(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+	)
+
+	if user, err = selfUser(r, env); err != nil {
+		return selfError(err)
+	}
+
+	return rulesOf(r, env, user)
+}
+
+// SetSelfSieveRules replaces filter rules of the token owner
+func SetSelfSieveRules(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+	)
+
+	if user, err = selfUser(r, env); err != nil {
+		return selfError(err)
+	}
+
+	return saveRules(r, env, user)
+}
+
+// SelfStat returns services usage statistics of the token owner
+func SelfStat(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		m    []*models.Stat
+		user *models.User
+		resp *Response
+
+		id = r.Context().Value("Id")
+	)
+
+	if user, err = selfUser(r, env); err != nil {
+		return selfError(err)
+	}
+
+	flt := models.NewFilter().
+		Where("uid", user.Id).
+		Order("updated", false)
+
+	if m, _, err = env.ServicesStat(flt, false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch services statistcs from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	resp = NewResponse(m)
+	resp.Count = uint64(len(m))
+
+	return resp
+}
+
+// selfUser returns user identified by the token
+func selfUser(r *http.Request, env Enviroment) (*models.User, error) {
+	var (
+		id = r.Context().Value("Id")
+		tk = r.Context().Value(tokenKey).(IdentityIface)
+	)
+
+	u, _, err := env.Users(models.NewFilter().Where("id", tk.Identity()), false)
+
+	if err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return nil, errSelfFetch
+	}
+
+	if len(u) != 1 {
+		env.Error("%s: Can't find token owner with id=(%d)", id, tk.Identity())
+
+		return nil, errSelfUnknown
+	}
+
+	return u[0], nil
+}
+
+var (
+	errSelfFetch   = errors.New("Cannot fetch user from database")
+	errSelfUnknown = errors.New("Unknown token owner")
+)
+
+// selfError converts selfUser error to the response
+func selfError(err error) ResponseIface {
+	if err == errSelfUnknown {
+		return NewResponse(&Error{
+			Code:    401,
+			Message: http.StatusText(401),
+			Title:   http.StatusText(401),
+		})
+	}
+
+	return NewResponse(&Error{
+		Code:    500,
+		Message: err.Error(),
+		Title:   http.StatusText(500),
+	})
+}
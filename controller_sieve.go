@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"mbmi-go/models"
+	"net/http"
+)
+
+// SieveRules returns filter rules of the user
+func SieveRules(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		user *models.User
+		resp ResponseIface
+	)
+
+	if user, resp = paramUser(r, env); resp != nil {
+		return resp
+	}
+
+	return rulesOf(r, env, user)
+}
+
+// SetSieveRules replaces filter rules of the user
+func SetSieveRules(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		user *models.User
+		resp ResponseIface
+	)
+
+	if user, resp = paramUser(r, env); resp != nil {
+		return resp
+	}
+
+	return saveRules(r, env, user)
+}
+
+// ValidateSieve checks syntax of the script
+func ValidateSieve(r *http.Request, env Enviroment) ResponseIface {
+	var err error
+
+	if err = r.ParseForm(); err != nil {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if err = validateSieve(r.PostForm.Get("script")); err != nil {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"script": err.Error(),
+			},
+		})
+	}
+
+	return NewResponse(nil)
+}
+
+// ParseSieve reads rules back from the generated script
+func ParseSieve(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err   error
+		rules []*models.SieveRule
+	)
+
+	if err = r.ParseForm(); err != nil {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if rules, err = readRules(r.PostForm.Get("script")); err != nil {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"script": err.Error(),
+			},
+		})
+	}
+
+	return NewResponse(rules)
+}
+
+// rulesOf returns filter rules response of the user
+func rulesOf(r *http.Request, env Enviroment, user *models.User) ResponseIface {
+	var (
+		err   error
+		rules []*models.SieveRule
+		resp  *Response
+
+		id = r.Context().Value("Id")
+	)
+
+	if rules, err = env.SieveRules(user.Id); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch sieve rules from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	resp = NewResponse(rules)
+	resp.Count = uint64(len(rules))
+
+	return resp
+}
+
+// saveRules replaces filter rules of the user with JSON list from the
+// request body and applies the sieve scripts
+func saveRules(r *http.Request, env Enviroment, user *models.User) ResponseIface {
+	var (
+		err   error
+		t     []*models.Transport
+		rules []*models.SieveRule
+
+		fields = make(map[string]string)
+		id     = r.Context().Value("Id")
+	)
+
+	if err = parseFormTo(r, &rules); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse rules list",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	for i, rule := range rules {
+		for name, msg := range validateRule(rule) {
+			fields[fmt.Sprintf("%d.%s", i, name)] = msg
+		}
+	}
+
+	if len(fields) > 0 {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Invalid sieve rules",
+			Title:   http.StatusText(500),
+			Fields:  fields,
+		})
+	}
+
+	if err = env.SetSieveRules(user.Id, rules); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot save sieve rules",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if t, _, err = env.Transports(models.NewFilter().Where("id", user.Domain), false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+	} else if len(t) == 1 {
+		if err = applyUserSieve(env, user, t[0]); err != nil {
+			env.Error("%s: Cannot apply sieve scripts of %s: %s", id, user.Email, err.Error())
+		}
+	}
+
+	return NewResponse(rules)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"net/http"
+	"time"
+)
+
+// Suspension returns suspension of the user, data is empty if the
+// account is not suspended
+func Suspension(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+		list []*models.Suspension
+		resp ResponseIface
+
+		id = r.Context().Value("Id")
+	)
+
+	if user, resp = paramUser(r, env); resp != nil {
+		return resp
+	}
+
+	if list, _, err = env.Suspensions(models.NewFilter().Where("uid", user.Id), false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch suspension from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if len(list) == 1 {
+		return NewResponse(list[0])
+	}
+
+	return NewResponse(nil)
+}
+
+// Suspend disables all services of the user. The suspension without
+// start is applied immediately, otherwise the scheduler applies it.
+// Service flags are restored by the scheduler at the end
+func Suspend(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+		resp ResponseIface
+
+		now  = time.Now()
+		form = models.Suspension{}
+		id   = r.Context().Value("Id")
+	)
+
+	if user, resp = paramUser(r, env); resp != nil {
+		return resp
+	}
+
+	if err = parseFormTo(r, &form); err != nil {
+		env.Error("%s, %#v, %s", id, r.PostForm, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	form.UID = user.Id
+
+	if form.End != nil && (!now.Before(*form.End) || (form.Start != nil && !form.Start.Before(*form.End))) {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Invalid suspension period",
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"end": "must be after start and now",
+			},
+		})
+	}
+
+	apply, _ := suspensionDue(&form, now)
+
+	if err = env.SuspendUser(&form, apply); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot suspend user",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	env.Notice("%s: User %s is suspended (applied=%v): %s", id, user.Email, form.Applied, form.Reason)
+
+	return NewResponse(&form)
+}
+
+// Resume restores services of the suspended user and removes scheduled
+// suspension
+func Resume(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err  error
+		user *models.User
+		resp ResponseIface
+
+		id = r.Context().Value("Id")
+	)
+
+	if user, resp = paramUser(r, env); resp != nil {
+		return resp
+	}
+
+	if err = env.ResumeUser(user.Id); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot resume user",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(nil)
+}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"github.com/julienschmidt/httprouter"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 	"io"
+	"io/ioutil"
+	"mbmi-go/models"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -83,8 +88,8 @@ func Test_GetAliasesList(t *testing.T) {
 	req, _ := request("GET", "/aliases?limit=50&offset=0&alias=alerts%40doamin.com", nil)
 	env := initTestBus(t, false)
 
-	rows := sqlmock.NewRows([]string{"id", "alias", "recipient", "comment"}).
-		AddRow(1, "alert@doamin.com", "some@domain.com", "Any text")
+	rows := sqlmock.NewRows([]string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}).
+		AddRow(1, "alert@doamin.com", "some@domain.com", "Any text", nil, nil)
 
 	count := sqlmock.NewRows([]string{"count"}).AddRow(1)
 
@@ -98,13 +103,41 @@ func Test_GetAliasesList(t *testing.T) {
 	Aliases(req, env)
 }
 
+func Test_GetAliasesExpiring(t *testing.T) {
+	db, mock := initDBMock(t)
+	req, _ := request("GET", "/aliases?expiring=7", nil)
+	env := initTestBus(t, false)
+
+	until := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+
+	rows := sqlmock.NewRows([]string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}).
+		AddRow(1, "event@domain.com", "some@domain.com", "Event", nil, until)
+
+	mock.ExpectQuery("^SELECT.+aliases.+valid_until.+> NOW\\(\\) AND .+valid_until.+<= \\?").WillReturnRows(rows)
+	mock.ExpectQuery("^SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	resp := Aliases(req, env).(*Response)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if a, ok := resp.Data.([]*models.Alias); !ok || len(a) != 1 || a[0].ValidUntil == nil || !a[0].ValidUntil.Equal(until) {
+		t.Errorf("Unexpected expiring aliases %#v", resp.Data)
+	}
+}
+
 func Test_GetAliasGroupsList(t *testing.T) {
 	db, mock := initDBMock(t)
 	req, _ := request("GET", "/aliases?limit=50&offset=0&alias=alert%40domain.com&recipient=", nil)
 	env := initTestBus(t, false)
 
-	rows := sqlmock.NewRows([]string{"id", "alias", "recipient", "comment"}).
-		AddRow(1, "alert@doamin.com", "some@domain.com", "Any text")
+	rows := sqlmock.NewRows([]string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}).
+		AddRow(1, "alert@doamin.com", "some@domain.com", "Any text", nil, nil)
 
 	count := sqlmock.NewRows([]string{"count"}).AddRow(1)
 
@@ -124,29 +157,16 @@ func Test_GetUsersList(t *testing.T) {
 	req, _ := request("GET", "/users", nil)
 	env := initTestBus(t, false)
 
-	rows := sqlmock.NewRows([]string{
-		"id",
-		"name",
-		"login",
-		"domid",
-		"passwd",
-		"uid",
-		"gid",
-		"smtp",
-		"imap",
-		"pop3",
-		"sieve",
-		"manager",
-		"domainname",
-		"secret",
-		"token",
-	}).
-		AddRow(1, "Alert User Name", "alert", 1, "anypass", 8, 8, 1, 1, 0, 1, 1, "doamin.com", "", "")
+	rows := sqlmock.NewRows(userMockColumns).
+		AddRow(1, "Alert User Name", "alert", 1, "anypass", 8, 8, 1, 1, 0, 1, 1, "doamin.com", "", "", nil, 0, nil)
 
 	count := sqlmock.NewRows([]string{"count"}).AddRow(1)
 
 	mock.ExpectQuery("SELECT").WillReturnRows(rows)
 	mock.ExpectQuery("SELECT COUNT").WillReturnRows(count)
+	mock.ExpectQuery("SELECT.+transport").WillReturnRows(sqlmock.NewRows([]string{
+		"id", "domain", "transport", "rootdir", "uid", "gid",
+	}))
 
 	if err := env.openDB(db); err != nil {
 		t.Error(err)
@@ -164,24 +184,8 @@ func Test_SearchUserByEmailOrName(t *testing.T) {
 	req, _ := request("GET", "/users?query=alert%40domain.com", nil)
 	env := initTestBus(t, false)
 
-	rows := sqlmock.NewRows([]string{
-		"id",
-		"name",
-		"login",
-		"domid",
-		"passwd",
-		"uid",
-		"gid",
-		"smtp",
-		"imap",
-		"pop3",
-		"sieve",
-		"manager",
-		"domainname",
-		"secret",
-		"token",
-	}).
-		AddRow(1, "Alert User Name", "alert", 1, "anypass", 8, 8, 1, 1, 0, 1, 1, "doamin.com", "", "")
+	rows := sqlmock.NewRows(userMockColumns).
+		AddRow(1, "Alert User Name", "alert", 1, "anypass", 8, 8, 1, 1, 0, 1, 1, "doamin.com", "", "", nil, 0, nil)
 
 	count := sqlmock.NewRows([]string{"count"}).AddRow(1)
 
@@ -190,6 +194,9 @@ func Test_SearchUserByEmailOrName(t *testing.T) {
 		WillReturnRows(rows)
 
 	mock.ExpectQuery("SELECT COUNT").WillReturnRows(count)
+	mock.ExpectQuery("SELECT.+transport").WillReturnRows(sqlmock.NewRows([]string{
+		"id", "domain", "transport", "rootdir", "uid", "gid",
+	}))
 
 	if err := env.openDB(db); err != nil {
 		t.Error(err)
@@ -375,17 +382,31 @@ func Test_SaveAlias_Suites(t *testing.T) {
 		}
 
 		if data.code == 200 {
+			mock.ExpectQuery("^SELECT.+transport.+WHERE.+domain").WithArgs("domain.com").WillReturnRows(
+				sqlmock.NewRows([]string{"id", "domain", "transport", "rootdir", "uid", "gid"}).
+					AddRow(1, "domain.com", "virtual", "/var/mail", 8, 8))
+			mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("tosomewahere", "domain.com").WillReturnRows(
+				sqlmock.NewRows(userMockColumns).AddRow(userRow(1, "tosomewahere", "domain.com")...))
+			mock.ExpectQuery("^SELECT.+aliases").WithArgs("tosomewahere@domain.com").WillReturnRows(
+				sqlmock.NewRows([]string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}))
+			mock.ExpectQuery("^SELECT.+aliases").WithArgs("@domain.com").WillReturnRows(
+				sqlmock.NewRows([]string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}))
+
 			if data.method == "POST" {
 				mock.ExpectExec("^INSERT INTO.+VALUES").WithArgs(
 					data.values.Get("alias"),
 					data.values.Get("recipient"),
 					data.values.Get("comment"),
+					nil,
+					nil,
 				).WillReturnResult(sqlmock.NewResult(0, 0))
 			} else {
 				mock.ExpectExec("^UPDATE.+SET.+WHERE").WithArgs(
 					data.values.Get("alias"),
 					data.values.Get("recipient"),
 					data.values.Get("comment"),
+					nil,
+					nil,
 					id,
 				).WillReturnResult(sqlmock.NewResult(0, 0))
 			}
@@ -403,6 +424,107 @@ func Test_SaveAlias_Suites(t *testing.T) {
 	}
 }
 
+func Test_SetAliasAllowExternal(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("POST", "/alias", NewHandler(SetAlias, env))
+
+	aliases := []string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}
+
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+domain").WithArgs("other.org").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "domain", "transport", "rootdir", "uid", "gid"}))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("some@other.org").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("@other.org").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectExec("^INSERT INTO.+VALUES").WithArgs("any@domain.com", "some@other.org", "", nil, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w := httptest.NewRecorder()
+	req, _ := request("POST", "/alias", strings.NewReader("alias=any@domain.com&recipient=some@other.org&allow_external=1"))
+	router.ServeHTTP(w, req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if w.Code != 200 {
+		t.Errorf("Expected external recipient allowed by the form flag, got %d %s", w.Code, w.Body)
+	}
+}
+
+func Test_ValidateAlias(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	var (
+		aliases    = []string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}
+		transports = []string{"id", "domain", "transport", "rootdir", "uid", "gid"}
+	)
+
+	// External recipient
+	for _, external := range []bool{false, true} {
+		mock.ExpectQuery("^SELECT.+transport.+WHERE.+domain").WithArgs("other.org").WillReturnRows(sqlmock.NewRows(transports))
+
+		if external {
+			mock.ExpectQuery("^SELECT.+aliases").WithArgs("some@other.org").WillReturnRows(sqlmock.NewRows(aliases))
+			mock.ExpectQuery("^SELECT.+aliases").WithArgs("@other.org").WillReturnRows(sqlmock.NewRows(aliases))
+		}
+
+		verr := validateAlias(env, "test", &models.Alias{Alias: "a@domain.com", Recipient: "some@other.org"}, external)
+		if (verr == nil) != external {
+			t.Errorf("External recipient with external=%v: %v", external, verr)
+		}
+	}
+
+	// Validity period
+	from := time.Now()
+	until := from.Add(-time.Hour)
+
+	if verr := validateAlias(env, "test", &models.Alias{Alias: "a@domain.com", Recipient: "b@domain.com", ValidFrom: &from, ValidUntil: &until}, false); verr == nil || verr.Fields["valid_until"] == "" {
+		t.Errorf("Expected invalid period error, got %v", verr)
+	}
+
+	// Dangling recipient
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+domain").WithArgs("domain.com").WillReturnRows(
+		sqlmock.NewRows(transports).AddRow(1, "domain.com", "virtual", "/var/mail", 8, 8))
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("none", "domain.com").WillReturnRows(sqlmock.NewRows(userMockColumns))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("none@domain.com").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("@domain.com").WillReturnRows(sqlmock.NewRows(aliases))
+
+	if verr := validateAlias(env, "test", &models.Alias{Alias: "a@domain.com", Recipient: "none@domain.com"}, false); verr == nil || verr.Fields["recipient"] != "none@domain.com" {
+		t.Errorf("Expected dangling recipient error, got %v", verr)
+	}
+
+	// Loop a -> b -> c -> a
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+domain").WithArgs("domain.com").WillReturnRows(
+		sqlmock.NewRows(transports).AddRow(1, "domain.com", "virtual", "/var/mail", 8, 8))
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("b", "domain.com").WillReturnRows(
+		sqlmock.NewRows(userMockColumns).
+			AddRow(userRow(1, "b", "domain.com")...))
+	// Aliases which are not active yet are taken into account
+	mock.ExpectQuery("^SELECT.+aliases.+`alias` = \\? AND \\(`a`.`valid_until`").WithArgs("b@domain.com").WillReturnRows(
+		sqlmock.NewRows(aliases).AddRow(2, "b@domain.com", "c@domain.com", "", nil, nil))
+	mock.ExpectQuery("^SELECT.+aliases.+`alias` = \\? AND \\(`a`.`valid_until`").WithArgs("c@domain.com").WillReturnRows(
+		sqlmock.NewRows(aliases).AddRow(3, "c@domain.com", "a@domain.com", "", nil, nil))
+
+	verr := validateAlias(env, "test", &models.Alias{Alias: "a@domain.com", Recipient: "b@domain.com"}, false)
+	if verr == nil || verr.Message != "Alias loop: a@domain.com -> b@domain.com -> c@domain.com -> a@domain.com" {
+		t.Errorf("Expected alias loop error, got %v", verr)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
 func Test_SaveUser_Suites(t *testing.T) {
 	db, mock := initDBMock(t)
 	env := initTestBus(t, true)
@@ -415,7 +537,7 @@ func Test_SaveUser_Suites(t *testing.T) {
 				"id":       []string{"1"},
 				"name":     []string{"Any User"},
 				"login":    []string{"domain.com"},
-				"password": []string{"123"},
+				"password": []string{"Secret123x"},
 				"domain":   []string{"1"},
 				"uid":      []string{"8"},
 				"gid":      []string{"8"},
@@ -430,7 +552,7 @@ func Test_SaveUser_Suites(t *testing.T) {
 				"id":       []string{"1"},
 				"name":     []string{"Any User"},
 				"login":    []string{"domain.com"},
-				"password": []string{"123"},
+				"password": []string{"Secret123x"},
 				"domain":   []string{"1"},
 				"uid":      []string{"8"},
 				"gid":      []string{"8"},
@@ -471,23 +593,7 @@ func Test_SaveUser_Suites(t *testing.T) {
 
 			if data.method == "PUT" {
 				mock.ExpectQuery("^SELECT").WillReturnRows(
-					sqlmock.NewRows([]string{
-						"id",
-						"name",
-						"login",
-						"domid",
-						"passwd",
-						"uid",
-						"gid",
-						"smtp",
-						"imap",
-						"pop3",
-						"sieve",
-						"manager",
-						"domainname",
-						"secret",
-						"token",
-					}).
+					sqlmock.NewRows(userMockColumns).
 						AddRow(
 							data.values.Get("id"),
 							data.values.Get("name"),
@@ -496,23 +602,28 @@ func Test_SaveUser_Suites(t *testing.T) {
 							data.values.Get("password"),
 							data.values.Get("uid"),
 							data.values.Get("gid"),
-							data.values.Get("smtp"),
-							data.values.Get("imap"),
-							data.values.Get("pop3"),
+							data.values.Get("smtp") == "1",
+							data.values.Get("imap") == "1",
+							data.values.Get("pop3") == "1",
 							data.values.Get("sieve"),
 							data.values.Get("manager"),
 							data.values.Get("domainname"),
 							"",
 							"",
+							nil,
+							0,
+							nil,
 						))
 
+				mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(sqlmock.NewRows([]string{}))
 				mock.ExpectExec("^UPDATE.+users.+SET.+WHERE").WillReturnResult(sqlmock.NewResult(0, 1))
 			} else {
+				mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(sqlmock.NewRows([]string{}))
 				mock.ExpectExec("^INSERT\\s+INTO.+users.+VALUES").WillReturnResult(sqlmock.NewResult(1, 0))
 			}
 
 			if data.values.Get("password") != "" {
-				mock.ExpectExec("^UPDATE[\\s`]+users[\\s`]+SET[\\s`]+passwd[\\s`=\\?]+WHERE").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("^UPDATE[\\s`]+users[\\s`]+SET[\\s`]+passwd[\\s`=\\?]+,[\\s`]+password_changed_at.+WHERE").WillReturnResult(sqlmock.NewResult(0, 1))
 			}
 		}
 
@@ -528,6 +639,103 @@ func Test_SaveUser_Suites(t *testing.T) {
 	}
 }
 
+func Test_UpdateUserRejectsImmutableChange(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("PUT", "/user/:uid", NewHandler(SetUser, env))
+
+	for _, data := range []struct {
+		values url.Values
+		field  string
+	}{
+		{url.Values{"login": {"new"}, "domain": {"1"}, "smtp": {"1"}, "imap": {"1"}}, "login"},
+		{url.Values{"login": {"old"}, "domain": {"2"}, "smtp": {"1"}, "imap": {"1"}}, "domain"},
+		{url.Values{"login": {"old"}, "domain": {"1"}, "imap": {"1"}}, "services"},
+	} {
+		mock.ExpectQuery("^SELECT.+transport").WillReturnRows(
+			sqlmock.NewRows([]string{"id", "domain", "transport", "rootdir", "uid", "gid"}).
+				AddRow(data.values.Get("domain"), "user.net", "virtual", "/var/mail", 8, 8))
+		mock.ExpectQuery("^SELECT.+users.+WHERE.+id").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(userMockColumns).AddRow(userRow(1, "old", "user.net")...))
+
+		if data.field == "services" {
+			mock.ExpectQuery("^SELECT.+suspension.+WHERE.+uid.+applied").WithArgs(1, true).WillReturnRows(
+				sqlmock.NewRows([]string{"uid", "reason", "start", "end", "applied", "smtp", "imap", "pop3"}).
+					AddRow(1, "", nil, nil, true, true, true, false))
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := request("PUT", "/user/1", strings.NewReader(data.values.Encode()))
+		router.ServeHTTP(w, req)
+
+		if w.Code != 500 || !strings.Contains(w.Body.String(), `"`+data.field+`"`) {
+			t.Errorf("Expected %s change rejected, got %d %s", data.field, w.Code, w.Body)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_SaveUserRejectedByPasswordPolicy(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	values := url.Values(map[string][]string{
+		"name":     []string{"Any User"},
+		"login":    []string{"any"},
+		"password": []string{"secret"},
+		"domain":   []string{"1"},
+	})
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	w := httptest.NewRecorder()
+	router.Handle("POST", "/user", NewHandler(SetUser, env))
+	req, _ := request("POST", "/user", strings.NewReader(values.Encode()))
+
+	mock.ExpectQuery("^SELECT.+transport").WillReturnRows(
+		sqlmock.NewRows([]string{
+			"id", "domain", "transport", "rootdir", "uid", "gid",
+		}).
+			AddRow(1, "doamin.com", "virtual", "/mail", 8, 8))
+
+	mock.ExpectQuery("^SELECT.+password_policy").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{
+			"domid", "min_length", "lower", "upper", "digits", "symbols", "banned", "history", "max_age",
+		}).
+			AddRow(1, 6, int64(1), int64(0), int64(1), int64(0), "secret", 0, 0))
+
+	router.ServeHTTP(w, req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	resp := &Response{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Success || resp.Error == nil {
+		t.Fatalf("Required failed response, but got %s", w.Body)
+	}
+
+	if msg := resp.Error.Fields["password"]; msg != "digit required, contains banned word" {
+		t.Errorf("Unexpected password field error: %s", msg)
+	}
+}
+
 func Test_SetStatImapLogin(t *testing.T) {
 	db, mock := initDBMock(t)
 	env := initTestBus(t, true)
@@ -570,23 +778,7 @@ func Test_SetStatImapLogin(t *testing.T) {
 
 		if data.code == 200 {
 			mock.ExpectQuery("^SELECT").WillReturnRows(
-				sqlmock.NewRows([]string{
-					"id",
-					"name",
-					"login",
-					"domid",
-					"passwd",
-					"uid",
-					"gid",
-					"smtp",
-					"imap",
-					"pop3",
-					"sieve",
-					"manager",
-					"domainname",
-					"secert",
-					"token",
-				}).
+				sqlmock.NewRows(userMockColumns).
 					AddRow(
 						"1",
 						"Any user",
@@ -603,6 +795,9 @@ func Test_SetStatImapLogin(t *testing.T) {
 						"somedomain.net",
 						"",
 						"",
+						nil,
+						0,
+						nil,
 					))
 
 			mock.ExpectExec("^INSERT\\sINTO.+statistics").WillReturnResult(sqlmock.NewResult(1, 0))
@@ -636,6 +831,36 @@ func Test_GetPassword(t *testing.T) {
 				"length": []string{"22"},
 			}),
 		},
+		Tmock{
+			code:   200,
+			method: "GET",
+			values: url.Values(map[string][]string{
+				"mode":      []string{"passphrase"},
+				"words":     []string{"4"},
+				"separator": []string{" "},
+			}),
+		},
+		Tmock{
+			code:   200,
+			method: "GET",
+			values: url.Values(map[string][]string{
+				"mode": []string{"pronounceable"},
+			}),
+		},
+		Tmock{
+			code:   500,
+			method: "GET",
+			values: url.Values(map[string][]string{
+				"charset": []string{"abc"},
+			}),
+		},
+		Tmock{
+			code:   500,
+			method: "GET",
+			values: url.Values(map[string][]string{
+				"mode": []string{"unknown"},
+			}),
+		},
 	}
 
 	for _, data := range data {
@@ -698,23 +923,7 @@ func Test_ChangeAndGetUserJWT(t *testing.T) {
 
 		if data.code == 200 {
 			mock.ExpectQuery("^SELECT").WillReturnRows(
-				sqlmock.NewRows([]string{
-					"id",
-					"name",
-					"login",
-					"domid",
-					"passwd",
-					"uid",
-					"gid",
-					"smtp",
-					"imap",
-					"pop3",
-					"sieve",
-					"manager",
-					"domainname",
-					"secert",
-					"token",
-				}).
+				sqlmock.NewRows(userMockColumns).
 					AddRow(
 						data.values.Get("id"),
 						data.values.Get("name"),
@@ -731,6 +940,9 @@ func Test_ChangeAndGetUserJWT(t *testing.T) {
 						data.values.Get("domainname"),
 						"",
 						data.values.Get("token"),
+						nil,
+						0,
+						nil,
 					))
 
 			mock.ExpectExec("^UPDATE.+users.+SET").WillReturnResult(sqlmock.NewResult(1, 0))
@@ -788,23 +1000,7 @@ func Test_Authentication(t *testing.T) {
 	for _, data := range users {
 		if data.code == 200 {
 			mock.ExpectQuery("^SELECT.+users").WillReturnRows(
-				sqlmock.NewRows([]string{
-					"id",
-					"name",
-					"login",
-					"domid",
-					"passwd",
-					"uid",
-					"gid",
-					"smtp",
-					"imap",
-					"pop3",
-					"sieve",
-					"manager",
-					"domainname",
-					"secert",
-					"token",
-				}).
+				sqlmock.NewRows(userMockColumns).
 					AddRow(
 						data.values.Get("id"),
 						data.values.Get("name"),
@@ -821,7 +1017,12 @@ func Test_Authentication(t *testing.T) {
 						data.values.Get("domainname"),
 						"",
 						data.values.Get("token"),
+						nil,
+						0,
+						nil,
 					))
+
+			mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(sqlmock.NewRows([]string{}))
 		}
 
 		w := httptest.NewRecorder()
@@ -842,23 +1043,7 @@ func Test_Authentication(t *testing.T) {
 
 		if data.code == 200 {
 			mock.ExpectQuery("^SELECT.+users").WillReturnRows(
-				sqlmock.NewRows([]string{
-					"id",
-					"name",
-					"login",
-					"domid",
-					"passwd",
-					"uid",
-					"gid",
-					"smtp",
-					"imap",
-					"pop3",
-					"sieve",
-					"manager",
-					"domainname",
-					"secert",
-					"token",
-				}).
+				sqlmock.NewRows(userMockColumns).
 					AddRow(
 						data.values.Get("id"),
 						data.values.Get("name"),
@@ -875,6 +1060,9 @@ func Test_Authentication(t *testing.T) {
 						data.values.Get("domainname"),
 						"",
 						data.values.Get("token"),
+						nil,
+						0,
+						nil,
 					))
 		}
 
@@ -1192,7 +1380,7 @@ func Test_InsertUpdateDeleteBccItem(t *testing.T) {
 				).WillReturnResult(sqlmock.NewResult(0, 1))
 			} else if data.method == "DELETE" {
 				id, _ := strconv.ParseInt(data.values.Get("id"), 10, 64)
-				mock.ExpectExec("^DELETE.+FROM.+bcc.+WHERE").WithArgs(
+				mock.ExpectExec("^UPDATE.+bcc.+SET.+deleted_at.+WHERE").WithArgs(
 					id,
 				).WillReturnResult(sqlmock.NewResult(0, 1))
 			}
@@ -1252,49 +1440,17 @@ func Test_DeleteUser(t *testing.T) {
 
 		switch uidx {
 		case 0:
-			urows := sqlmock.NewRows([]string{
-				"id",
-				"name",
-				"login",
-				"domid",
-				"passwd",
-				"uid",
-				"gid",
-				"smtp",
-				"imap",
-				"pop3",
-				"sieve",
-				"manager",
-				"domainname",
-				"secret",
-				"token",
-			}).
-				AddRow(1, "Alert User Name", "alert", 1, "anypass", 8, 8, 1, 1, 0, 1, 1, "doamin.com", "", "")
+			urows := sqlmock.NewRows(userMockColumns).
+				AddRow(1, "Alert User Name", "alert", 1, "anypass", 8, 8, 1, 1, 0, 1, 1, "doamin.com", "", "", nil, 0, nil)
 
 			mock.ExpectQuery("^SELECT.+FROM.+users").WillReturnRows(urows)
 			mock.ExpectQuery("^SELECT.+FROM.+bcc").WillReturnRows(sqlmock.NewRows([]string{}))
 			mock.ExpectQuery("^SELECT.+FROM.+aliases").WillReturnRows(sqlmock.NewRows([]string{}))
-			mock.ExpectExec("^DELETE FROM.+users.+WHERE").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("^UPDATE.+users.+SET.+deleted_at.+WHERE").WillReturnResult(sqlmock.NewResult(0, 1))
 
 		case 1:
-			urows := sqlmock.NewRows([]string{
-				"id",
-				"name",
-				"login",
-				"domid",
-				"passwd",
-				"uid",
-				"gid",
-				"smtp",
-				"imap",
-				"pop3",
-				"sieve",
-				"manager",
-				"domainname",
-				"secret",
-				"token",
-			}).
-				AddRow(1, "Alert User Name", "alert", 1, "anypass", 8, 8, 1, 1, 0, 1, 1, "doamin.com", "", "")
+			urows := sqlmock.NewRows(userMockColumns).
+				AddRow(1, "Alert User Name", "alert", 1, "anypass", 8, 8, 1, 1, 0, 1, 1, "doamin.com", "", "", nil, 0, nil)
 
 			mock.ExpectQuery("^SELECT.+FROM.+users").WillReturnRows(urows)
 
@@ -1313,24 +1469,8 @@ func Test_DeleteUser(t *testing.T) {
 			mock.ExpectQuery("^SELECT.+COUNT.+FROM.+bcc").WillReturnRows(bcount)
 
 		case 2:
-			urows := sqlmock.NewRows([]string{
-				"id",
-				"name",
-				"login",
-				"domid",
-				"passwd",
-				"uid",
-				"gid",
-				"smtp",
-				"imap",
-				"pop3",
-				"sieve",
-				"manager",
-				"domainname",
-				"secret",
-				"token",
-			}).
-				AddRow(1, "Alert User Name", "alert", 1, "anypass", 8, 8, 1, 1, 0, 1, 1, "doamin.com", "", "")
+			urows := sqlmock.NewRows(userMockColumns).
+				AddRow(1, "Alert User Name", "alert", 1, "anypass", 8, 8, 1, 1, 0, 1, 1, "doamin.com", "", "", nil, 0, nil)
 
 			mock.ExpectQuery("^SELECT.+FROM.+users").WillReturnRows(urows)
 			mock.ExpectQuery("^SELECT.+FROM.+bcc").WillReturnRows(sqlmock.NewRows([]string{}))
@@ -1340,8 +1480,10 @@ func Test_DeleteUser(t *testing.T) {
 				"alias",
 				"recipient",
 				"comment",
+				"valid_from",
+				"valid_until",
 			}).
-				AddRow(5, "foo@localhost", "alert@doamin.com", "")
+				AddRow(5, "foo@localhost", "alert@doamin.com", "", nil, nil)
 
 			acount := sqlmock.NewRows([]string{"count"}).AddRow(1)
 
@@ -1360,3 +1502,952 @@ func Test_DeleteUser(t *testing.T) {
 		}
 	}
 }
+
+func Test_ExpiredPasswordAllowsPasswordChangeOnly(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("POST", "/login", NewHandler(secretWrap(Login, "anysecret"), env))
+	router.Handle("GET", "/user/:uid", NewHandler(Protect(User), env))
+	router.Handle("PUT", "/user/:uid/password", NewHandler(ProtectWith(SetPassword, subjectAuthentication, subjectPassword), env))
+
+	mw := Middlewares(
+		router,
+		JWT("anysecret", env),
+	)
+
+	userRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(userMockColumns).
+			AddRow(1, "Any User", "some", 1, "123", 8, 8, 1, 1, 0, 0, 1, "user.net", "", "", nil, int64(1), nil)
+	}
+
+	mock.ExpectQuery("^SELECT.+users").WillReturnRows(userRows())
+	mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(sqlmock.NewRows([]string{}))
+
+	w := httptest.NewRecorder()
+	req, _ := request("POST", "/login", strings.NewReader("email=some@user.net&password=123"))
+	mw.ServeHTTP(w, req)
+
+	resp := &Response{
+		Data: &Token{},
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+
+	jwt := resp.Data.(*Token).JWT
+
+	// Manager routes are closed
+	w = httptest.NewRecorder()
+	req, _ = request("GET", "/user/me", nil)
+	req.Header.Add("Authorization", "Bearer "+jwt)
+	mw.ServeHTTP(w, req)
+
+	if w.Code != 401 {
+		t.Errorf("Expecting 401 for manager route, but got %d", w.Code)
+	}
+
+	// Password change is allowed
+	mock.ExpectQuery("^SELECT.+users").WillReturnRows(userRows())
+	mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(sqlmock.NewRows([]string{}))
+	mock.ExpectExec("^UPDATE.+users.+SET.+passwd.+must_change.+WHERE").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w = httptest.NewRecorder()
+	req, _ = request("PUT", "/user/me/password", strings.NewReader("password=NewSecret42"))
+	req.Header.Add("Authorization", "Bearer "+jwt)
+	mw.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Errorf("Unexpected code was returned code=%d, body=%s", w.Code, w.Body)
+	}
+
+	// Other user password is closed
+	w = httptest.NewRecorder()
+	req, _ = request("PUT", "/user/2/password", strings.NewReader("password=NewSecret42"))
+	req.Header.Add("Authorization", "Bearer "+jwt)
+	mw.ServeHTTP(w, req)
+
+	if w.Code != 403 {
+		t.Errorf("Expecting 403 for other user, but got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_SelfTokenAllowsSelfRoutesOnly(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("POST", "/self/login", NewHandler(secretWrap(SelfLogin, "anysecret"), env))
+	router.Handle("GET", "/users", NewHandler(Protect(Users), env))
+	router.Handle("GET", "/self", NewHandler(ProtectWith(SelfAccount, subjectSelf), env))
+
+	mw := Middlewares(
+		router,
+		JWT("anysecret", env),
+	)
+
+	userRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(userMockColumns).
+			AddRow(userRow(1, "some", "user.net")...)
+	}
+
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain.+passwd").WillReturnRows(userRows())
+	mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(sqlmock.NewRows([]string{}))
+
+	w := httptest.NewRecorder()
+	req, _ := request("POST", "/self/login", strings.NewReader("email=some@user.net&password=123"))
+	mw.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("Unexpected code was returned code=%d, body=%s", w.Code, w.Body)
+	}
+
+	resp := &Response{
+		Data: &Token{},
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+
+	jwt := resp.Data.(*Token).JWT
+
+	// Manager routes are closed
+	w = httptest.NewRecorder()
+	req, _ = request("GET", "/users", nil)
+	req.Header.Add("Authorization", "Bearer "+jwt)
+	mw.ServeHTTP(w, req)
+
+	if w.Code != 401 {
+		t.Errorf("Expecting 401 for manager route, but got %d", w.Code)
+	}
+
+	// Own account is available without credentials
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+id").WillReturnRows(userRows())
+
+	w = httptest.NewRecorder()
+	req, _ = request("GET", "/self", nil)
+	req.Header.Add("Authorization", "Bearer "+jwt)
+	mw.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Errorf("Unexpected code was returned code=%d, body=%s", w.Code, w.Body)
+	}
+
+	if strings.Contains(w.Body.String(), "\"123\"") {
+		t.Errorf("Password must not be returned: %s", w.Body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_SetSelfForwardLoop(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	tk := NewToken([]byte("anysecret")).Sign(NewClaims(1, subjectSelf))
+	if err := tk.Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := []string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}
+
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(userMockColumns).AddRow(userRow(1, "some", "user.net")...))
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+domain").WithArgs("user.net").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "domain", "transport", "rootdir", "uid", "gid"}).
+			AddRow(1, "user.net", "virtual", "/var/mail", 8, 8))
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("b", "user.net").WillReturnRows(
+		sqlmock.NewRows(userMockColumns).AddRow(userRow(2, "b", "user.net")...))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("b@user.net").WillReturnRows(
+		sqlmock.NewRows(aliases).AddRow(2, "b@user.net", "some@user.net", "", nil, nil))
+
+	req, _ := request("POST", "/self/forward", strings.NewReader("recipient=b@user.net"))
+	req = req.WithContext(context.WithValue(req.Context(), tokenKey, tk))
+
+	resp := SetSelfForward(req, env).(*Response)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if resp.Error == nil || !strings.HasPrefix(resp.Error.Message, "Alias loop") {
+		t.Errorf("Expected alias loop error, got %#v", resp.Error)
+	}
+}
+
+func Test_SetSieveRules(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("PUT", "/user/:uid/sieve", NewHandler(SetSieveRules, env))
+
+	userRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(userMockColumns).
+			AddRow(1, "Any User", "some", 1, "123", 8, 8, 1, 1, 0, 1, 0, "user.net", "", "", nil, 0, nil)
+	}
+
+	// Invalid rule
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+id").WithArgs(1).WillReturnRows(userRows())
+
+	w := httptest.NewRecorder()
+	req, _ := request("PUT", "/user/1/sieve", strings.NewReader(`[{"field":"from","value":"x","action":"fileinto"}]`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	resp := &Response{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Success || resp.Error == nil || resp.Error.Fields["0.target"] != "required" {
+		t.Fatalf("Required target error, but got %s", w.Body)
+	}
+
+	// Valid rules replace stored ones
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+id").WithArgs(1).WillReturnRows(userRows())
+	mock.ExpectBegin()
+	mock.ExpectExec("^DELETE FROM `sieve_rule`").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("^INSERT INTO `sieve_rule`").WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("^SELECT.+transport").WillReturnRows(sqlmock.NewRows([]string{
+		"id", "domain", "transport", "rootdir", "uid", "gid",
+	}))
+
+	w = httptest.NewRecorder()
+	req, _ = request("PUT", "/user/1/sieve", strings.NewReader(`[{"field":"from","value":"x","action":"fileinto","target":"X"}]`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Errorf("Unexpected code was returned code=%d, body=%s", w.Code, w.Body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_SuspendUser(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("POST", "/user/:uid/suspend", NewHandler(Suspend, env))
+
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(userMockColumns).
+			AddRow(userRow(1, "some", "user.net")...))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT `applied` FROM `suspension`").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"applied"}))
+	mock.ExpectQuery("^SELECT `smtp`, `imap`, `pop3` FROM `users`").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"smtp", "imap", "pop3"}).AddRow(int64(1), int64(1), int64(0)))
+	mock.ExpectExec("^UPDATE `users` SET `smtp` = 0, `imap` = 0, `pop3` = 0").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^REPLACE INTO `suspension`").
+		WithArgs(1, "unpaid", nil, nil, true, true, true, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	req, _ := request("POST", "/user/1/suspend", strings.NewReader("reason=unpaid"))
+	router.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Errorf("Unexpected code was returned code=%d, body=%s", w.Code, w.Body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_ImportUsers(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	hook := &recordHook{}
+	env.hooksOnce.Do(func() { env.hooks = hook })
+
+	router := NewRouter()
+	router.Handle("POST", "/import/users", NewHandler(ImportUsers, env))
+
+	transportRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{
+			"id", "domain", "transport", "rootdir", "uid", "gid",
+		}).
+			AddRow(1, "user.net", "virtual", "/mail", 8, 8)
+	}
+
+	policyRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{})
+	}
+
+	data := "name,email,password,imap\n" +
+		"First User,first@user.net,Secret123x,1\n" +
+		"Second User,second@user.net,short,1\n"
+
+	// Second row is rejected, nothing is saved
+	mock.ExpectQuery("^SELECT.+transport").WillReturnRows(transportRows())
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT.+transport").WithArgs(1).WillReturnRows(transportRows())
+	mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(policyRows())
+	mock.ExpectExec("^INSERT INTO `users`").WillReturnResult(sqlmock.NewResult(10, 1))
+	mock.ExpectExec("^UPDATE.+users.+SET.+passwd").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("^SELECT.+transport").WithArgs(1).WillReturnRows(transportRows())
+	mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(policyRows())
+	mock.ExpectRollback()
+
+	w := httptest.NewRecorder()
+	req, _ := request("POST", "/import/users", strings.NewReader(data))
+	req.Header.Set("Content-Type", "text/csv")
+	router.ServeHTTP(w, req)
+
+	resp := &Response{Data: &ImportReport{}}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+
+	report := resp.Data.(*ImportReport)
+
+	if resp.Success || report.Committed || report.Failed != 1 || len(report.Rows) != 2 {
+		t.Fatalf("Expecting failed import, but got %s", w.Body)
+	}
+
+	if row := report.Rows[1]; row.Status != importInvalid || row.Fields["password"] == "" {
+		t.Errorf("Expecting password error in the second row, but got %#v", row)
+	}
+
+	// Dry run of the valid data is rolled back
+	mock.ExpectQuery("^SELECT.+transport").WillReturnRows(transportRows())
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT.+transport").WithArgs(1).WillReturnRows(transportRows())
+	mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(policyRows())
+	mock.ExpectExec("^INSERT INTO `users`").WillReturnResult(sqlmock.NewResult(10, 1))
+	mock.ExpectExec("^UPDATE.+users.+SET.+passwd").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	req, _ = request("POST", "/import/users?dry_run=1", strings.NewReader(`[{"name":"First User","email":"first@user.net","password":"Secret123x","imap":true}]`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	resp = &Response{Data: &ImportReport{}}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+
+	report = resp.Data.(*ImportReport)
+
+	if !resp.Success || report.Committed || !report.DryRun || report.Rows[0].Status != importValid {
+		t.Errorf("Expecting valid dry run, but got %s", w.Body)
+	}
+
+	if len(hook.created) != 0 {
+		t.Errorf("Hook is called for the not committed rows: %v", hook.created)
+	}
+
+	// Hook is called after commit
+	mock.ExpectQuery("^SELECT.+transport").WillReturnRows(transportRows())
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT.+transport").WithArgs(1).WillReturnRows(transportRows())
+	mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(policyRows())
+	mock.ExpectExec("^INSERT INTO `users`").WillReturnResult(sqlmock.NewResult(10, 1))
+	mock.ExpectExec("^UPDATE.+users.+SET.+passwd").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+id").WithArgs(1).WillReturnRows(transportRows())
+
+	w = httptest.NewRecorder()
+	req, _ = request("POST", "/import/users", strings.NewReader(`[{"name":"First User","email":"first@user.net","password":"Secret123x","imap":true}]`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if len(hook.created) != 1 || hook.created[0] != "first@user.net" {
+		t.Errorf("Expecting hook of first@user.net, but got %v: %s", hook.created, w.Body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_ExportUsers(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("GET", "/export/:entity", NewHandler(Export, env))
+
+	userRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(userMockColumns).
+			AddRow(1, "Any User", "some", 1, "{PLAIN}hash", 8, 8, 1, 1, 0, 0, 0, "user.net", "s3cret", "t0ken", nil, 0, nil).
+			AddRow(2, "Other, User", "other", 1, "{PLAIN}hash", 8, 8, 1, 0, 0, 0, 0, "user.net", "", "", nil, 0, nil)
+	}
+
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+CONCAT").WithArgs("some%").WillReturnRows(userRows())
+
+	w := httptest.NewRecorder()
+	req, _ := request("GET", "/export/users?email=some", nil)
+	router.ServeHTTP(w, req)
+
+	body := w.Body.String()
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Unexpected content type %s", ct)
+	}
+
+	if !strings.HasPrefix(body, "id,name,login,domain,") || !strings.Contains(body, `2,"Other, User",other,1,user.net,other@user.net`) {
+		t.Errorf("Unexpected csv:\n%s", body)
+	}
+
+	mock.ExpectQuery("^SELECT.+users").WillReturnRows(userRows())
+
+	w = httptest.NewRecorder()
+	req, _ = request("GET", "/export/users?format=ndjson", nil)
+	router.ServeHTTP(w, req)
+
+	body = w.Body.String()
+
+	if lines := strings.Split(strings.TrimSpace(body), "\n"); len(lines) != 2 {
+		t.Errorf("Expecting 2 lines, but got:\n%s", body)
+	}
+
+	for _, secret := range []string{"hash", "s3cret", "t0ken", "passwd", "password\""} {
+		if strings.Contains(body, secret) {
+			t.Errorf("Export contains %s:\n%s", secret, body)
+		}
+	}
+
+	mock.ExpectQuery("^SELECT.+users").WillReturnError(errors.New("connection lost"))
+
+	w = httptest.NewRecorder()
+	req, _ = request("GET", "/export/users?format=json", nil)
+	router.ServeHTTP(w, req)
+
+	if ct := w.Header().Get("Content-Type"); w.Code != 500 || !strings.HasPrefix(ct, "application/json") || w.Header().Get("Content-Disposition") != "" {
+		t.Errorf("Unexpected error response %d %s: %s", w.Code, ct, w.Body)
+	}
+
+	resp := &Response{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil || resp.Success {
+		t.Errorf("Unexpected error response %s", w.Body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_RenameUser(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("POST", "/user/:uid/rename", NewHandler(RenameUser, env))
+
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(userMockColumns).
+			AddRow(userRow(1, "old", "user.net")...))
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("new", "user.net").WillReturnRows(sqlmock.NewRows(userMockColumns))
+	mock.ExpectQuery("^SELECT.+aliases.+WHERE.+alias").WithArgs("new@user.net").WillReturnRows(sqlmock.NewRows([]string{
+		"id", "alias", "recipient", "comment", "valid_from", "valid_until",
+	}))
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+id").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{
+		"id", "domain", "transport", "rootdir", "uid", "gid",
+	}).AddRow(1, "user.net", "virtual:", "/nonexistent", 8, 8))
+	mock.ExpectBegin()
+	mock.ExpectExec("^UPDATE `users` SET `login`").WithArgs("new", 1, 8, 8, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^UPDATE `aliases` SET `recipient`").WithArgs("new@user.net", "old@user.net").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("^UPDATE `aliases` SET `alias`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^UPDATE `bcc` SET `sender`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^UPDATE `bcc` SET `recipient`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^UPDATE `bcc` SET `copy`").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("^SELECT `id`, `senders` FROM `alias_groups` WHERE `senders` LIKE").WithArgs("%old@user.net%").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "senders"}).
+			AddRow(1, "a@user.net\nold@user.net").
+			AddRow(2, "bold@user.net"))
+	mock.ExpectExec("^UPDATE `alias_groups` SET `senders`").WithArgs("a@user.net\nnew@user.net", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^INSERT INTO `aliases`").WithArgs("old@user.net", "new@user.net", "Renamed to new@user.net", nil, nil).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	req, _ := request("POST", "/user/1/rename", strings.NewReader("login=new&keep_alias=1"))
+	router.ServeHTTP(w, req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	resp := &Response{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := resp.Data.(map[string]interface{})
+
+	if !resp.Success || data["alias_recipients"] != 3.0 || data["bcc_copies"] != 2.0 || data["group_senders"] != 1.0 || data["old_alias"] != 7.0 {
+		t.Errorf("Unexpected rename summary %s", w.Body)
+	}
+}
+
+func Test_MoveUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbmi-move")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err = os.MkdirAll(filepath.Join(dir, "user.net", "old", "cur"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("POST", "/user/:uid/move", NewHandler(MoveUser, env))
+
+	transport := []string{
+		"id", "domain", "transport", "rootdir", "uid", "gid",
+	}
+
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(userMockColumns).
+			AddRow(userRow(1, "old", "user.net")...))
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(transport).AddRow(1, "user.net", "virtual:", dir, 8, 8))
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+id").WithArgs(2).WillReturnRows(
+		sqlmock.NewRows(transport).AddRow(2, "other.net", "virtual:", dir, 9, 9))
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("old", "other.net").WillReturnRows(sqlmock.NewRows(userMockColumns))
+	mock.ExpectQuery("^SELECT.+aliases.+WHERE.+alias").WithArgs("old@other.net").WillReturnRows(sqlmock.NewRows([]string{
+		"id", "alias", "recipient", "comment", "valid_from", "valid_until",
+	}))
+	mock.ExpectBegin()
+	mock.ExpectExec("^UPDATE `users` SET `login`").WithArgs("old", 2, 9, 9, 1).WillReturnError(errors.New("failed"))
+	mock.ExpectRollback()
+
+	w := httptest.NewRecorder()
+	req, _ := request("POST", "/user/1/move", strings.NewReader("domain=2"))
+	router.ServeHTTP(w, req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if w.Code != 500 {
+		t.Errorf("Required failure, but got %d", w.Code)
+	}
+
+	// Failed update returns the maildir back
+	if _, err = os.Stat(filepath.Join(dir, "user.net", "old", "cur")); err != nil {
+		t.Errorf("Mailbox is not returned back: %s", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "other.net", "old")); !os.IsNotExist(err) {
+		t.Errorf("Mailbox is left at the target: %v", err)
+	}
+}
+
+func Test_RestoreTrash(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("POST", "/trash/:kind/:id/restore", NewHandler(RestoreTrash, env))
+
+	for _, used := range []bool{true, false} {
+		rows := sqlmock.NewRows(userMockColumns)
+		if used {
+			rows.AddRow(userRow(2, "old", "user.net")...)
+		}
+
+		mock.ExpectQuery("^SELECT.+users.+WHERE.+deleted_at.+IS NOT NULL.+id").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(userMockColumns).
+				AddRow(userRow(1, "old", "user.net")...))
+		mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain.+deleted_at.+IS NULL").WithArgs("old", "user.net").WillReturnRows(rows)
+
+		if !used {
+			mock.ExpectExec("^UPDATE `users` SET `deleted_at` = NULL").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := request("POST", "/trash/user/1/restore", nil)
+		router.ServeHTTP(w, req)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+
+		resp := &Response{}
+		if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Success == used {
+			t.Errorf("Unexpected restore result with the used address=%v: %s", used, w.Body)
+		}
+	}
+}
+
+func Test_PurgeTrash(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("DELETE", "/trash/:kind/:id", NewHandler(PurgeTrash, env))
+
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+deleted_at.+IS NOT NULL.+id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(userMockColumns).AddRow(userRow(1, "old", "user.net")...))
+	mock.ExpectBegin()
+	mock.ExpectExec("^DELETE FROM `users` WHERE `id`").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^DELETE FROM `suspension` WHERE `uid`").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^DELETE FROM `vacation` WHERE `uid`").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^DELETE FROM `sieve_rule` WHERE `uid`").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("^DELETE FROM `password_history` WHERE `uid`").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("^DELETE FROM `statistics` WHERE `uid`").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectCommit()
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("old", "user.net").WillReturnRows(
+		sqlmock.NewRows(userMockColumns).AddRow(userRow(2, "old", "user.net")...))
+
+	w := httptest.NewRecorder()
+	req, _ := request("DELETE", "/trash/user/1", nil)
+	router.ServeHTTP(w, req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if w.Code != 200 {
+		t.Errorf("Unexpected purge result: %s", w.Body)
+	}
+}
+
+func Test_UsersFilterAndSort(t *testing.T) {
+	db, mock := initDBMock(t)
+	req, _ := request("GET", "/users?domain=2&imap=1&manager=0&login_after=2020-01-02&sort=last_login&dir=desc", nil)
+	env := initTestBus(t, false)
+
+	login := time.Date(2020, 3, 4, 5, 6, 7, 0, time.Local)
+	after := time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local)
+
+	rows := sqlmock.NewRows(userMockColumns).
+		AddRow(1, "Alert User Name", "alert", 2, "anypass", 8, 8, 1, 1, 0, 1, 0, "doamin.com", "", "", nil, 0, login)
+
+	mock.ExpectQuery("SELECT.+statistics.+WHERE.+domid.+imap.+manager.+ls.+updated.+>=.+ORDER BY.+ls.+updated.+DESC").
+		WithArgs(2, true, false, after, 0, 10).
+		WillReturnRows(rows)
+	mock.ExpectQuery("SELECT COUNT.+statistics").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("SELECT.+transport").WillReturnRows(sqlmock.NewRows([]string{
+		"id", "domain", "transport", "rootdir", "uid", "gid",
+	}))
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	resp := Users(req, env)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if !resp.Ok() {
+		t.Fatalf("Required success response, but got %d", resp.Status())
+	}
+
+	data, _ := json.Marshal(resp)
+	if !strings.Contains(string(data), `"last_login":"2020-03-04T05:06:07`) {
+		t.Errorf("Last login is not returned: %s", data)
+	}
+}
+
+func Test_PassdbAuth(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("POST", "/passdb", NewHandler(PassdbAuth, env))
+
+	for _, data := range []struct {
+		form   string
+		result string
+	}{
+		{"user=some@user.net&password=secret&service=imap&remote_ip=10.0.0.1", "PASSDB_RESULT_OK"},
+		{"user=some@user.net&password=wrong&service=imap", "PASSDB_RESULT_PASSWORD_MISMATCH"},
+		{"user=some@user.net&password=secret&service=pop3", "PASSDB_RESULT_USER_DISABLED"},
+	} {
+		mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("some", "user.net").WillReturnRows(
+			sqlmock.NewRows(userMockColumns).
+				AddRow(userRow(1, "some", "user.net")...))
+
+		if data.result == "PASSDB_RESULT_OK" {
+			mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(sqlmock.NewRows([]string{}))
+			mock.ExpectExec("^INSERT INTO `statistics`").WithArgs(1, "imap", "10.0.0.1").WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := request("POST", "/passdb", strings.NewReader(data.form))
+		router.ServeHTTP(w, req)
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+
+		resp := &Response{}
+		if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+
+		result, _ := resp.Data.(map[string]interface{})
+		if result["result"] != data.result {
+			t.Errorf("%s: expected %s, got %s", data.form, data.result, w.Body)
+		}
+	}
+}
+
+func Test_GroupMembers(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("PUT", "/group/:gid/members", NewHandler(GroupMembers, env))
+
+	var (
+		aliases = []string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}
+		groups  = []string{"id", "address", "description", "send_policy", "senders", "members"}
+	)
+
+	mock.ExpectQuery("^SELECT.+alias_groups.+WHERE.+id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(groups).AddRow(1, "team@user.net", "Team", "members", "", 2))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("team@user.net").WillReturnRows(
+		sqlmock.NewRows(aliases).
+			AddRow(1, "team@user.net", "old@user.net", "", nil, nil).
+			AddRow(2, "team@user.net", "a@user.net", "", nil, nil))
+
+	// Members are validated as aliases
+	for _, login := range []string{"a", "b"} {
+		mock.ExpectQuery("^SELECT.+transport.+WHERE.+domain").WithArgs("user.net").WillReturnRows(
+			sqlmock.NewRows([]string{"id", "domain", "transport", "rootdir", "uid", "gid"}).
+				AddRow(1, "user.net", "virtual", "/var/mail", 8, 8))
+		mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs(login, "user.net").WillReturnRows(
+			sqlmock.NewRows(userMockColumns).
+				AddRow(1, "Any User", login, 1, "secret", 8, 8, true, true, true, false, false, "user.net", "", "", nil, false, nil))
+		mock.ExpectQuery("^SELECT.+aliases").WithArgs(login + "@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+		mock.ExpectQuery("^SELECT.+aliases").WithArgs("@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT `address` FROM `alias_groups`").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"address"}).AddRow("team@user.net"))
+	mock.ExpectQuery("^SELECT `id`, `recipient` FROM `aliases`.+FOR UPDATE").WithArgs("team@user.net").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "recipient"}).
+			AddRow(1, "old@user.net").
+			AddRow(2, "A@user.net"))
+	mock.ExpectExec("^UPDATE `aliases` SET `deleted_at`").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^INSERT INTO `aliases`").WithArgs("team@user.net", "b@user.net", "", nil, nil).WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectQuery("^SELECT.+alias_groups.+WHERE.+id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(groups).AddRow(1, "team@user.net", "Team", "members", "", 2))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("team@user.net").WillReturnRows(
+		sqlmock.NewRows(aliases).
+			AddRow(2, "team@user.net", "A@user.net", "", nil, nil).
+			AddRow(3, "team@user.net", "b@user.net", "", nil, nil))
+
+	w := httptest.NewRecorder()
+	req, _ := request("PUT", "/group/1/members", strings.NewReader("member=a@user.net&member=b@user.net"))
+	router.ServeHTTP(w, req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if w.Code != 200 {
+		t.Fatalf("Unexpected code was returned code=%d, body=%s", w.Code, w.Body)
+	}
+
+	resp := &Response{Data: &models.Group{}}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if g := resp.Data.(*models.Group); len(g.Members) != 2 || g.Members[1] != "b@user.net" {
+		t.Errorf("Unexpected group members %v", g.Members)
+	}
+}
+
+func Test_SetGroupAddress(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	router := NewRouter()
+	router.Handle("PUT", "/group/:gid", NewHandler(SetGroup, env))
+	router.Handle("POST", "/group", NewHandler(SetGroup, env))
+
+	var (
+		aliases = []string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}
+		groups  = []string{"id", "address", "description", "send_policy", "senders", "members"}
+	)
+
+	expectGroup := func() {
+		mock.ExpectQuery("^SELECT.+alias_groups.+WHERE.+id").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(groups).AddRow(1, "team@user.net", "Team", "anyone", "", 1))
+		mock.ExpectQuery("^SELECT.+aliases").WithArgs("team@user.net").WillReturnRows(
+			sqlmock.NewRows(aliases).AddRow(1, "team@user.net", "m@user.net", "", nil, nil))
+	}
+
+	// Address of the user
+	expectGroup()
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("used", "user.net").WillReturnRows(
+		sqlmock.NewRows(userMockColumns).
+			AddRow(userRow(2, "used", "user.net")...))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("used@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+alias_groups.+WHERE.+address").WithArgs("used@user.net").WillReturnRows(sqlmock.NewRows(groups))
+
+	// Member m@user.net is the alias to the new address
+	expectGroup()
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("new", "user.net").WillReturnRows(sqlmock.NewRows(userMockColumns))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("new@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+alias_groups.+WHERE.+address").WithArgs("new@user.net").WillReturnRows(sqlmock.NewRows(groups))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("m@user.net").WillReturnRows(
+		sqlmock.NewRows(aliases).AddRow(2, "m@user.net", "new@user.net", "", nil, nil))
+
+	// New group with the address of the empty group
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("empty", "user.net").WillReturnRows(sqlmock.NewRows(userMockColumns))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("empty@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+alias_groups.+WHERE.+address").WithArgs("empty@user.net").WillReturnRows(
+		sqlmock.NewRows(groups).AddRow(2, "empty@user.net", "", "anyone", "", 0))
+
+	for _, data := range [][3]string{
+		{"PUT", "used@user.net", "Address is already used"},
+		{"PUT", "new@user.net", "Alias loop: new@user.net -> m@user.net -> new@user.net"},
+		{"POST", "empty@user.net", "Address is already used"},
+	} {
+		path := "/group"
+		if data[0] == "PUT" {
+			path = "/group/1"
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := request(data[0], path, strings.NewReader("address="+data[1]))
+		router.ServeHTTP(w, req)
+
+		resp := &Response{}
+		if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Success || resp.Error.Message != data[2] {
+			t.Errorf("Expected %q for %s %s, got %s", data[2], data[0], data[1], w.Body)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_GroupsUnknownSort(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	req, _ := request("GET", "/groups?sort=senders", nil)
+
+	if resp := Groups(req, env).(*Response); resp.Error == nil || resp.Error.Code != 400 {
+		t.Errorf("Expected 400 for the unknown sort key, got %#v", resp.Error)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_ExpireAliases(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Error(err)
+	}
+
+	mock.ExpectExec("^UPDATE `aliases` SET `deleted_at` = NOW\\(\\) WHERE `valid_until` <= NOW\\(\\)").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := expireAliases(env); err != nil {
+		t.Error(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"mbmi-go/models"
+	"net/http"
+	"strconv"
+)
+
+// Trash returns soft deleted users, aliases and bcc rules, the list
+// can be limited to one kind
+func Trash(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		count uint64
+		err   error
+		resp  *Response
+		t     []*models.Trash
+
+		flt = models.NewFilter()
+		id  = r.Context().Value("Id")
+	)
+
+	if err = r.ParseForm(); err != nil {
+		env.Error("%s, %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if v := r.Form.Get("kind"); v != "" {
+		flt.Where("kind", v)
+	}
+
+	// Apply page limitation
+	helperLimit(r, flt)
+
+	if t, count, err = env.Trash(flt, true); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: http.StatusText(500),
+			Title:   http.StatusText(500),
+		})
+	}
+
+	resp = NewResponse(t)
+	resp.Count = count
+
+	return resp
+}
+
+// RestoreTrash returns soft deleted record back. User is not restored
+// if its address is taken by another one meanwhile
+func RestoreTrash(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err    error
+		kind   string
+		itemId int64
+		resp   ResponseIface
+
+		id = r.Context().Value("Id")
+	)
+
+	if kind, itemId, resp = paramTrash(r, env); resp != nil {
+		return resp
+	}
+
+	if kind == models.TrashUser {
+		if resp = restoreUserCheck(env, id, itemId); resp != nil {
+			return resp
+		}
+	}
+
+	if err = env.RestoreTrash(kind, itemId); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		if err == sql.ErrNoRows {
+			return NewResponse(&Error{
+				Code:    404,
+				Message: http.StatusText(404),
+				Title:   http.StatusText(404),
+			})
+		}
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot restore " + kind,
+			Title:   http.StatusText(500),
+		})
+	}
+
+	env.Notice("%s: Restored %s id=(%d)", id, kind, itemId)
+
+	return NewResponse(nil)
+}
+
+// PurgeTrash removes soft deleted record without waiting for the
+// retention period
+func PurgeTrash(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err    error
+		kind   string
+		itemId int64
+		resp   ResponseIface
+
+		id = r.Context().Value("Id")
+	)
+
+	if kind, itemId, resp = paramTrash(r, env); resp != nil {
+		return resp
+	}
+
+	if err = purgeTrashItem(env, id, kind, itemId); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		if err == sql.ErrNoRows {
+			return NewResponse(&Error{
+				Code:    404,
+				Message: http.StatusText(404),
+				Title:   http.StatusText(404),
+			})
+		}
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot purge " + kind,
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return NewResponse(nil)
+}
+
+// paramTrash returns kind and id of the trash record from the route
+func paramTrash(r *http.Request, env Enviroment) (kind string, itemId int64, resp ResponseIface) {
+	var (
+		err error
+
+		id     = r.Context().Value("Id")
+		params = r.Context().Value("Params").(routerParams)
+	)
+
+	kind = params.ByName("kind")
+
+	switch kind {
+	case models.TrashUser, models.TrashAlias, models.TrashBcc:
+	default:
+		env.Error("%s: Unknown trash kind %s", id, kind)
+
+		return "", 0, NewResponse(&Error{
+			Code:    404,
+			Message: http.StatusText(404),
+			Title:   http.StatusText(404),
+		})
+	}
+
+	if itemId, err = strconv.ParseInt(params.ByName("id"), 10, 64); err != nil || itemId < 1 {
+		if err == nil {
+			err = errors.New("Invalid record id")
+		}
+
+		env.Error("%s: %s (id=%d)", id, err.Error(), itemId)
+
+		return "", 0, NewResponse(&Error{
+			Code:    500,
+			Message: err.Error(),
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return
+}
+
+// restoreUserCheck refuses to restore the user if its address is used
+// by the live mailbox
+func restoreUserCheck(env Enviroment, id interface{}, uid int64) ResponseIface {
+	var (
+		err   error
+		users []*models.User
+	)
+
+	if users, _, err = env.Users(models.NewFilter().Where("deleted", nil).Where("id", uid), false); err == nil && len(users) == 1 {
+		flt := models.NewFilter().
+			Where("login", users[0].Login).
+			Where("domain", users[0].DomainName)
+
+		users, _, err = env.Users(flt, false)
+	} else if err == nil {
+		// Not in the trash, RestoreTrash reports it
+		return nil
+	}
+
+	if err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch user from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if len(users) > 0 {
+		env.Error("%s: Address %s is used by user id=(%d)", id, users[0].Email, users[0].Id)
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Address is already used",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	return nil
+}
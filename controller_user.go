@@ -4,6 +4,7 @@ import (
 	"errors"
 	"mbmi-go/models"
 	"net/http"
+	"sort"
 	"strconv"
 )
 
@@ -14,9 +15,9 @@ func Users(r *http.Request, env Enviroment) ResponseIface {
 		err   error
 		resp  *Response
 		u     []*models.User
+		flt   models.FilterIface
 
-		flt = models.NewFilter()
-		id  = r.Context().Value("Id")
+		id = r.Context().Value("Id")
 	)
 
 	if err = r.ParseForm(); err != nil {
@@ -29,6 +30,49 @@ func Users(r *http.Request, env Enviroment) ResponseIface {
 		})
 	}
 
+	flt = usersFilter(r)
+
+	// Usage is not stored in the database, so the page
+	// is cut after filtering
+	byUsage := r.Form.Get("sort") == "usage" ||
+		r.Form.Get("usage_min") != "" ||
+		r.Form.Get("usage_max") != ""
+
+	if !byUsage {
+		// Apply page limitation
+		helperLimit(r, flt)
+	}
+
+	if u, count, err = env.Users(flt, true); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch users from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if t, _, err := env.Transports(nil, false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+	} else {
+		env.Usage().Fill(u, t, env)
+	}
+
+	if byUsage {
+		u, count = usageFilter(r, u)
+	}
+
+	resp = NewResponse(u)
+	resp.Count = count
+
+	return resp
+}
+
+// usersFilter returns users list filter from the parsed query
+func usersFilter(r *http.Request) models.FilterIface {
+	var flt = models.NewFilter()
+
 	if _, ok := r.Form["email"]; ok {
 		if v := r.Form.Get("email"); v != "" {
 			flt.Where("emlike", v+"%")
@@ -48,26 +92,115 @@ func Users(r *http.Request, env Enviroment) ResponseIface {
 
 		case "off":
 			flt.Where("mode_off", nil)
+
+		case "suspended":
+			flt.Where("suspended", nil)
+
+		case "scheduled":
+			flt.Where("suspend_scheduled", nil)
 		}
 	}
 
-	// Apply page limitation
-	helperLimit(r, flt)
+	if r.Form.Get("password") == "expired" {
+		flt.Where("passwd_expired", POLICYMAXAGE)
+	}
 
-	if u, count, err = env.Users(flt, true); err != nil {
-		env.Error("%s: %s", id, err.Error())
+	if v, err := strconv.ParseUint(r.Form.Get("domain"), 10, 32); err == nil {
+		flt.Where("domid", v)
+	}
 
-		return NewResponse(&Error{
-			Code:    500,
-			Message: "Cannot fetch users from database",
-			Title:   http.StatusText(500),
+	// Flags are filtered only if passed
+	for _, name := range []string{"smtp", "imap", "pop3", "sieve", "manager"} {
+		if _, ok := r.Form[name]; ok {
+			flt.Where(name, formBool(r, name))
+		}
+	}
+
+	if v := r.Form.Get("login_after"); v != "" {
+		if t := parseTimeValue(v); t.IsValid() {
+			flt.Where("login_after", t.Interface())
+		}
+	}
+
+	if v := r.Form.Get("login_before"); v != "" {
+		if v == "never" {
+			flt.Where("login_never", nil)
+		} else if t := parseTimeValue(v); t.IsValid() {
+			flt.Where("login_before", t.Interface())
+		}
+	}
+
+	// Usage sorting is applied after the query
+	switch srt := r.Form.Get("sort"); srt {
+	case "id", "name", "email", "domain", "last_login":
+		flt.Order(srt, r.Form.Get("dir") != "desc")
+	}
+
+	return flt
+}
+
+// usageFilter applies usage_min, usage_max (percent) filters and
+// usage sorting to the users list and cuts requested page
+func usageFilter(r *http.Request, u []*models.User) ([]*models.User, uint64) {
+	var (
+		min, max float64 = -1, -1
+		list             = make([]*models.User, 0, len(u))
+	)
+
+	if v, err := strconv.ParseFloat(r.Form.Get("usage_min"), 64); err == nil {
+		min = v
+	}
+
+	if v, err := strconv.ParseFloat(r.Form.Get("usage_max"), 64); err == nil {
+		max = v
+	}
+
+	for _, i := range u {
+		if min >= 0 || max >= 0 {
+			if i.Usage == nil ||
+				(min >= 0 && i.Usage.Percent < min) ||
+				(max >= 0 && i.Usage.Percent > max) {
+				continue
+			}
+		}
+
+		list = append(list, i)
+	}
+
+	if r.Form.Get("sort") == "usage" {
+		asc := r.Form.Get("dir") != "desc"
+
+		sort.SliceStable(list, func(a, b int) bool {
+			var pa, pb float64 = -1, -1
+
+			if list[a].Usage != nil {
+				pa = list[a].Usage.Percent
+			}
+
+			if list[b].Usage != nil {
+				pb = list[b].Usage.Percent
+			}
+
+			if asc {
+				return pa < pb
+			}
+
+			return pa > pb
 		})
 	}
 
-	resp = NewResponse(u)
-	resp.Count = count
+	count := uint64(len(list))
+	limit, offset := helperPage(r)
 
-	return resp
+	if offset >= count {
+		return list[:0], count
+	}
+
+	if end := offset + limit; end < count {
+		return list[offset:end], count
+	}
+
+	return list[offset:], count
 }
 
 // Get user by id
@@ -115,6 +248,14 @@ func User(r *http.Request, env Enviroment) ResponseIface {
 	}
 
 	if l := len(u); l == 1 {
+		flt = models.NewFilter().Where("id", u[0].Domain)
+
+		if t, _, err := env.Transports(flt, false); err != nil {
+			env.Error("%s: %s", id, err.Error())
+		} else {
+			env.Usage().Fill(u, t, env)
+		}
+
 		return NewResponse(u[0])
 	}
 
@@ -129,13 +270,12 @@ func User(r *http.Request, env Enviroment) ResponseIface {
 
 func SetUser(r *http.Request, env Enviroment) ResponseIface {
 	var (
-		uid       int64
-		err       error
-		transport []*models.Transport
-		user      []*models.User
+		uid    int64
+		err    error
+		verr   *Error
+		policy *models.PasswordPolicy
 
 		form   = models.User{}
-		flt    = models.NewFilter()
 		id     = r.Context().Value("Id")
 		params = r.Context().Value("Params").(routerParams)
 	)
@@ -171,17 +311,64 @@ func SetUser(r *http.Request, env Enviroment) ResponseIface {
 		form.Id = 0
 	}
 
+	if policy, verr = validateUser(env, id, &form); verr != nil {
+		return NewResponse(verr)
+	}
+
+	env.Debug("%s: User data is valid", id)
+
+	if err = env.SetUser(&form); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot save user data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if policy != nil && policy.History > 0 {
+		var hash string
+
+		if hash, err = passwordHash(form.Password); err == nil {
+			err = env.SetPasswordHistory(form.Id, hash)
+		}
+
+		if err != nil {
+			env.Error("%s: %s", id, err.Error())
+		}
+	}
+
+	if r.Method == "POST" {
+		runMailboxHook(env, id, hookCreated, &form)
+	}
+
+	return NewResponse(nil)
+}
+
+// validateUser checks user data the same way for the single record
+// and the import: transport, email, existing record on update and
+// password policy. Empty uid and gid are taken from the transport
+func validateUser(env Enviroment, id interface{}, form *models.User) (policy *models.PasswordPolicy, e *Error) {
+	var (
+		err       error
+		transport []*models.Transport
+		user      []*models.User
+
+		flt = models.NewFilter()
+	)
+
 	// Validate domain
 	// Identify domain by id from request
 	flt.Where("id", form.Domain)
 	if transport, _, err = env.Transports(flt, false); err != nil {
 		env.Error("%s: %s", id, err.Error())
 
-		return NewResponse(&Error{
+		return nil, &Error{
 			Code:    500,
 			Message: "Cannot fetch transports from database",
 			Title:   http.StatusText(500),
-		})
+		}
 	}
 
 	if l := len(transport); l != 1 {
@@ -193,11 +380,11 @@ func SetUser(r *http.Request, env Enviroment) ResponseIface {
 
 		env.Error("%s: %s", id, err.Error())
 
-		return NewResponse(&Error{
+		return nil, &Error{
 			Code:    500,
 			Message: "Cannot fetch transports from database",
 			Title:   http.StatusText(500),
-		})
+		}
 	}
 
 	// Put domain name to the form object
@@ -205,15 +392,19 @@ func SetUser(r *http.Request, env Enviroment) ResponseIface {
 
 	// Concat login with domain before validation
 	form.Email = models.Email(form.Login + "@" + form.DomainName)
-	// Validate full email
-	if _, _, err = form.Email.Split(); err != nil {
+	// Validate full email, login is the maildir name
+	if _, _, err = form.Email.Split(); err == nil {
+		err = checkMailboxLogin(form.Login)
+	}
+
+	if err != nil {
 		env.Error("%s: %s", id, err.Error())
 
-		return NewResponse(&Error{
+		return nil, &Error{
 			Code:    500,
 			Message: err.Error(),
 			Title:   http.StatusText(500),
-		})
+		}
 	}
 
 	if form.Id > 0 {
@@ -221,11 +412,11 @@ func SetUser(r *http.Request, env Enviroment) ResponseIface {
 		if user, _, err = env.Users(flt, false); err != nil {
 			env.Error("%s: %s", id, err.Error())
 
-			return NewResponse(&Error{
+			return nil, &Error{
 				Code:    500,
 				Message: err.Error(),
 				Title:   http.StatusText(500),
-			})
+			}
 		}
 
 		if l := len(user); l != 1 {
@@ -237,21 +428,97 @@ func SetUser(r *http.Request, env Enviroment) ResponseIface {
 
 			env.Error("%s: %s", id, err.Error())
 
-			return NewResponse(&Error{
+			return nil, &Error{
 				Code:    500,
 				Message: err.Error(),
 				Title:   http.StatusText(500),
-			})
+			}
+		}
+
+		// Rename moves the maildir and rewrites the references
+		if form.Login != user[0].Login {
+			env.Error("%s: Login change %s -> %s on update", id, user[0].Login, form.Login)
+
+			return nil, &Error{
+				Code:    500,
+				Message: "Login can not be changed on update, use POST /user/:uid/rename",
+				Title:   http.StatusText(500),
+				Fields:  map[string]string{"login": form.Login},
+			}
+		}
+
+		// Move relocates the maildir to the domain transport
+		if form.Domain != user[0].Domain {
+			env.Error("%s: Domain change %d -> %d on update", id, user[0].Domain, form.Domain)
+
+			return nil, &Error{
+				Code:    500,
+				Message: "Domain can not be changed on update, use POST /user/:uid/move",
+				Title:   http.StatusText(500),
+				Fields:  map[string]string{"domain": strconv.FormatUint(uint64(form.Domain), 10)},
+			}
+		}
+
+		// Suspension restores the saved flags on resume
+		if form.Smtp != user[0].Smtp || form.Imap != user[0].Imap || form.Pop3 != user[0].Pop3 {
+			var list []*models.Suspension
+
+			flt = models.NewFilter().Where("uid", form.Id).Where("applied", true)
+			if list, _, err = env.Suspensions(flt, false); err != nil {
+				env.Error("%s: %s", id, err.Error())
+
+				return nil, &Error{
+					Code:    500,
+					Message: "Cannot fetch suspension from database",
+					Title:   http.StatusText(500),
+				}
+			}
+
+			if len(list) > 0 {
+				env.Error("%s: Service flags change of the suspended user", id)
+
+				return nil, &Error{
+					Code:    500,
+					Message: "Services of the suspended user can not be changed, resume the user first",
+					Title:   http.StatusText(500),
+					Fields:  map[string]string{"services": "suspended"},
+				}
+			}
 		}
 	} else {
 		if form.Password == "" {
 			err = errors.New("Password required")
 
-			return NewResponse(&Error{
+			return nil, &Error{
 				Code:    500,
 				Message: err.Error(),
 				Title:   http.StatusText(500),
-			})
+			}
+		}
+	}
+
+	if form.Password != "" {
+		var fields map[string]string
+
+		if fields, policy, err = validatePassword(env, form); err != nil {
+			env.Error("%s: %s", id, err.Error())
+
+			return nil, &Error{
+				Code:    500,
+				Message: "Cannot fetch password policy from database",
+				Title:   http.StatusText(500),
+			}
+		}
+
+		if fields != nil {
+			env.Error("%s: Password rejected by policy: %v", id, fields)
+
+			return nil, &Error{
+				Code:    500,
+				Message: "Password does not satisfy the policy",
+				Title:   http.StatusText(500),
+				Fields:  fields,
+			}
 		}
 	}
 
@@ -263,19 +530,7 @@ func SetUser(r *http.Request, env Enviroment) ResponseIface {
 		form.Uid = transport[0].Uid
 	}
 
-	env.Debug("%s: User data is valid", id)
-
-	if err = env.SetUser(&form); err != nil {
-		env.Error("%s: %s", id, err.Error())
-
-		return NewResponse(&Error{
-			Code:    500,
-			Message: "Cannot save user data",
-			Title:   http.StatusText(500),
-		})
-	}
-
-	return NewResponse(nil)
+	return
 }
 
 func DelUser(r *http.Request, env Enviroment) ResponseIface {
@@ -388,6 +643,140 @@ func DelUser(r *http.Request, env Enviroment) ResponseIface {
 	return NewResponse(nil)
 }
 
+// SetPassword changes user password. Manager can change any password,
+// token issued for the expired password allows to change own one only
+func SetPassword(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		err    error
+		fields map[string]string
+		uid    int64
+		user   []*models.User
+		policy *models.PasswordPolicy
+
+		form   = models.User{}
+		id     = r.Context().Value("Id")
+		params = r.Context().Value("Params").(routerParams)
+		tk     = r.Context().Value(tokenKey).(IdentityIface)
+	)
+
+	// Self routes have no user id
+	if uidStr := params.ByName("uid"); uidStr == "me" || uidStr == "" {
+		uid = tk.Identity()
+	} else {
+		uid, err = strconv.ParseInt(uidStr, 10, 64)
+	}
+
+	if err != nil || uid < 1 {
+		if err != nil {
+			env.Error("%s: %s", id, err.Error())
+		}
+
+		return NewResponse(&Error{
+			Code:    404,
+			Message: "empty user id",
+			Title:   http.StatusText(404),
+		})
+	}
+
+	if tk.Subject() != subjectAuthentication && tk.Identity() != uid {
+		env.Error("%s: Token of user %d can't change password of %d", id, tk.Identity(), uid)
+
+		return NewResponse(&Error{
+			Code:    403,
+			Message: http.StatusText(403),
+			Title:   http.StatusText(403),
+		})
+	}
+
+	if err = parseFormTo(r, &form); err != nil {
+		env.Error("%s, %#v, %s", id, r.PostForm, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if form.Password == "" {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Password required",
+			Title:   http.StatusText(500),
+			Fields: map[string]string{
+				"password": "required",
+			},
+		})
+	}
+
+	if user, _, err = env.Users(models.NewFilter().Where("id", uid), false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch user from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if len(user) != 1 {
+		env.Error("%s: Can't find user with id=(%d)", id, uid)
+
+		return NewResponse(&Error{
+			Code:    404,
+			Message: http.StatusText(404),
+			Title:   http.StatusText(404),
+		})
+	}
+
+	user[0].Password = form.Password
+
+	if fields, policy, err = validatePassword(env, user[0]); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch password policy from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if fields != nil {
+		env.Error("%s: Password rejected by policy: %v", id, fields)
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Password does not satisfy the policy",
+			Title:   http.StatusText(500),
+			Fields:  fields,
+		})
+	}
+
+	if err = env.SetUserPassword(user[0]); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot save user data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if policy.History > 0 {
+		var hash string
+
+		if hash, err = passwordHash(form.Password); err == nil {
+			err = env.SetPasswordHistory(uid, hash)
+		}
+
+		if err != nil {
+			env.Error("%s: %s", id, err.Error())
+		}
+	}
+
+	return NewResponse(nil)
+}
+
 // GetUserJWT returns user JWT and updates secret
 func GetUserJWT(r *http.Request, env Enviroment) ResponseIface {
 	var (
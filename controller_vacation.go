@@ -0,0 +1,190 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"net/http"
+	"strconv"
+)
+
+// Vacation returns auto reply of the user
+func Vacation(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		user *models.User
+		resp ResponseIface
+	)
+
+	if user, resp = paramUser(r, env); resp != nil {
+		return resp
+	}
+
+	return vacationOf(r, env, user)
+}
+
+// SetVacation saves auto reply of the user
+func SetVacation(r *http.Request, env Enviroment) ResponseIface {
+	var (
+		user *models.User
+		resp ResponseIface
+	)
+
+	if user, resp = paramUser(r, env); resp != nil {
+		return resp
+	}
+
+	return saveVacation(r, env, user)
+}
+
+// paramUser returns user from the route uid parameter
+func paramUser(r *http.Request, env Enviroment) (*models.User, ResponseIface) {
+	var (
+		err error
+		uid int64
+		u   []*models.User
+
+		id     = r.Context().Value("Id")
+		params = r.Context().Value("Params").(routerParams)
+	)
+
+	if uidStr := params.ByName("uid"); uidStr == "me" {
+		uid = r.Context().Value(tokenKey).(IdentityIface).Identity()
+	} else {
+		uid, err = strconv.ParseInt(uidStr, 10, 64)
+	}
+
+	if err != nil || uid < 1 {
+		return nil, NewResponse(&Error{
+			Code:    404,
+			Message: "empty user id",
+			Title:   http.StatusText(404),
+		})
+	}
+
+	if u, _, err = env.Users(models.NewFilter().Where("id", uid), false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return nil, NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch user from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if len(u) != 1 {
+		env.Error("%s: Can't find user with id=(%d)", id, uid)
+
+		return nil, NewResponse(&Error{
+			Code:    404,
+			Message: http.StatusText(404),
+			Title:   http.StatusText(404),
+		})
+	}
+
+	return u[0], nil
+}
+
+// vacationOf returns auto reply response of the user, empty inactive
+// reply is returned if it was never set
+func vacationOf(r *http.Request, env Enviroment, user *models.User) ResponseIface {
+	var (
+		err error
+		v   []*models.Vacation
+
+		id = r.Context().Value("Id")
+	)
+
+	if v, _, err = env.Vacations(models.NewFilter().Where("uid", user.Id), false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot fetch vacation from database",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	if len(v) == 1 {
+		return NewResponse(v[0])
+	}
+
+	return NewResponse(&models.Vacation{UID: user.Id})
+}
+
+// saveVacation stores auto reply of the user from the form and applies
+// the sieve script
+func saveVacation(r *http.Request, env Enviroment, user *models.User) ResponseIface {
+	var (
+		err error
+		t   []*models.Transport
+
+		form = models.Vacation{}
+		id   = r.Context().Value("Id")
+	)
+
+	if err = parseFormTo(r, &form); err != nil {
+		env.Error("%s, %#v, %s", id, r.PostForm, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "cannot parse form data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	form.UID = user.Id
+
+	if fields := validateVacation(&form); fields != nil {
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Invalid vacation data",
+			Title:   http.StatusText(500),
+			Fields:  fields,
+		})
+	}
+
+	if err = env.SetVacation(&form); err != nil {
+		env.Error("%s: %s", id, err.Error())
+
+		return NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot save vacation data",
+			Title:   http.StatusText(500),
+		})
+	}
+
+	// Script is written right away, the scheduler will retry on failure
+	if t, _, err = env.Transports(models.NewFilter().Where("id", user.Domain), false); err != nil {
+		env.Error("%s: %s", id, err.Error())
+	} else if len(t) == 1 {
+		if err = applyUserSieve(env, user, t[0]); err != nil {
+			env.Error("%s: Cannot apply sieve scripts of %s: %s", id, user.Email, err.Error())
+		}
+	}
+
+	return NewResponse(nil)
+}
+
+// validateVacation returns field errors of the auto reply
+func validateVacation(v *models.Vacation) map[string]string {
+	var fields = make(map[string]string)
+
+	if v.Active && v.Body == "" {
+		fields["body"] = "required"
+	}
+
+	if v.Start != nil && v.End != nil && v.End.Before(*v.Start) {
+		fields["end"] = "must be after start"
+	}
+
+	for _, a := range v.Addresses {
+		if _, _, err := models.Email(a).Split(); err != nil {
+			fields["addresses"] = "invalid address " + a
+			break
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return fields
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mbmi-go/models"
+	"net"
+	"strings"
+	"time"
+)
+
+var dictEscaper = strings.NewReplacer(
+	"\x01", "\x011",
+	"\t", "\x01t",
+	"\n", "\x01n",
+	"\r", "\x01r",
+)
+
+var dictUnescaper = strings.NewReplacer(
+	"\x011", "\x01",
+	"\x01t", "\t",
+	"\x01n", "\n",
+	"\x01r", "\r",
+)
+
+// DictServer answers Dovecot dict proxy lookups of the passdb and userdb
+// JSON objects:
+//
+//	passdb/<user>[/<service>]
+//	userdb/<user>
+//
+// The service is optional, the login is denied if the service is off
+type DictServer struct {
+	env Enviroment
+}
+
+// NewDictServer returns Dovecot dict server
+func NewDictServer(env Enviroment) *DictServer {
+	return &DictServer{env: env}
+}
+
+// ListenAndServe listens the address and serves connections
+func (s *DictServer) ListenAndServe(address string) error {
+	l, err := listenAddress(address)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections, each one is served in own goroutine
+func (s *DictServer) Serve(l net.Listener) error {
+	return acceptLoop(l, s.serveConn)
+}
+
+// serveConn answers lookups until the client closes connection,
+// the dict is read only, so other commands are ignored
+func (s *DictServer) serveConn(conn net.Conn) {
+	var (
+		r  = bufio.NewReader(conn)
+		id = RandStringId(12)
+	)
+
+	defer conn.Close()
+
+	s.env.Debug("%s: dict connection from %s", id, conn.RemoteAddr())
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(socketmapIdle))
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		if line = strings.TrimRight(line, "\r\n"); line == "" {
+			continue
+		}
+
+		var reply string
+
+		switch line[0] {
+		case 'H':
+			s.env.Debug("%s: dict hello %q", id, line[1:])
+			continue
+
+		case 'L':
+			// Key is followed by the username field in the newer protocol
+			reply = s.lookup(id, dictUnescaper.Replace(strings.SplitN(line[1:], "\t", 2)[0]))
+
+		case 'I':
+			// Iteration is finished by the empty line
+			reply = ""
+
+		default:
+			s.env.Debug("%s: dict command %q is ignored", id, line)
+			continue
+		}
+
+		if _, err = fmt.Fprintf(conn, "%s\n", reply); err != nil {
+			s.env.Debug("%s: %s", id, err.Error())
+			return
+		}
+	}
+}
+
+// lookup returns O with the value, N if the key is not found and
+// F on the failure
+func (s *DictServer) lookup(id interface{}, key string) string {
+	fields, err := dictFields(s.env, key)
+
+	if err != nil {
+		s.env.Error("%s: dict %s: %s", id, key, err.Error())
+
+		return "F" + dictEscaper.Replace(err.Error())
+	}
+
+	if fields == nil {
+		s.env.Debug("%s: dict %s is not found", id, key)
+
+		return "N"
+	}
+
+	data, _ := json.Marshal(fields)
+
+	return "O" + dictEscaper.Replace(string(data))
+}
+
+var errDictKey = errors.New("Unknown dict key")
+
+// dictFields returns passdb or userdb fields of the key, nil if the
+// user is not found
+func dictFields(env Enviroment, key string) (map[string]string, error) {
+	var (
+		err     error
+		service string
+		users   []*models.User
+	)
+
+	// Namespace of the shared or private dict key
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "shared/"), "priv/")
+
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 2 || (parts[0] != "passdb" && parts[0] != "userdb") {
+		return nil, errDictKey
+	}
+
+	if len(parts) == 3 {
+		service = parts[2]
+	}
+
+	login, domain, err := models.Email(parts[1]).Split()
+	if err != nil {
+		return nil, nil
+	}
+
+	flt := models.NewFilter().
+		Where("login", login).
+		Where("domain", domain)
+
+	if users, _, err = env.Users(flt, false); err != nil || len(users) != 1 {
+		return nil, err
+	}
+
+	u := users[0]
+
+	switch parts[0] {
+	case "passdb":
+		p, err := passwordPolicy(env, u.Domain)
+		if err != nil {
+			return nil, err
+		}
+
+		fields := passdbFields(u, p)
+
+		if service != "" && !serviceAllowed(u, service) {
+			fields["nologin"] = "y"
+			fields["reason"] = "Service " + service + " is disabled"
+		}
+
+		return fields, nil
+
+	case "userdb":
+		t, _, err := env.Transports(models.NewFilter().Where("id", u.Domain), false)
+		if err != nil || len(t) != 1 {
+			return nil, err
+		}
+
+		return userdbFields(u, t[0]), nil
+	}
+
+	return nil, errDictKey
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net"
+	"testing"
+)
+
+func Test_Dict(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("some", "user.net").WillReturnRows(
+		sqlmock.NewRows(userMockColumns).
+			AddRow(1, "Any User", "some", 1, "{SHA512-CRYPT}x", 0, 0, true, true, false, false, false, "user.net", "", "", nil, 0, nil))
+	mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(sqlmock.NewRows([]string{}))
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("some", "user.net").WillReturnRows(
+		sqlmock.NewRows(userMockColumns).
+			AddRow(1, "Any User", "some", 1, "{SHA512-CRYPT}x", 0, 0, true, true, false, false, false, "user.net", "", "", nil, 0, nil))
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "domain", "transport", "rootdir", "uid", "gid"}).
+			AddRow(1, "user.net", "virtual:", "/var/mail", 8, 12))
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("none", "user.net").WillReturnRows(
+		sqlmock.NewRows(userMockColumns))
+
+	client, server := net.Pipe()
+	go NewDictServer(env).serveConn(server)
+
+	defer client.Close()
+
+	r := bufio.NewReader(client)
+
+	lookup := func(key string) (string, map[string]string) {
+		if _, err := fmt.Fprintf(client, "L%s\n", key); err != nil {
+			t.Fatal(err)
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fields map[string]string
+
+		if line[0] == 'O' {
+			if err = json.Unmarshal([]byte(line[1:]), &fields); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return line[:1], fields
+	}
+
+	fmt.Fprintf(client, "H2\t1\t0\t\tauth\n")
+
+	if res, f := lookup("shared/passdb/some@user.net/pop3"); res != "O" || f["password"] != "{SHA512-CRYPT}x" || f["nologin"] != "y" {
+		t.Errorf("Unexpected passdb reply %s %v", res, f)
+	}
+
+	if res, f := lookup("shared/userdb/some@user.net\tsome@user.net"); res != "O" ||
+		f["home"] != "/var/mail/user.net/some" || f["uid"] != "8" || f["gid"] != "12" || f["services"] != "smtp,imap" {
+		t.Errorf("Unexpected userdb reply %s %v", res, f)
+	}
+
+	if res, _ := lookup("shared/userdb/none@user.net"); res != "N" {
+		t.Errorf("Expected not found, got %s", res)
+	}
+
+	if res, _ := lookup("shared/other/none@user.net"); res != "F" {
+		t.Errorf("Expected failure, got %s", res)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"strconv"
+	"strings"
+)
+
+// passdbFields returns user password with Dovecot passdb extra fields.
+// Login is denied with nologin and the reason is shown to the client
+func passdbFields(u *models.User, p *models.PasswordPolicy) map[string]string {
+	var fields = map[string]string{
+		"user":     string(u.Email),
+		"password": u.Password,
+	}
+
+	if passwordExpired(u, p) {
+		fields["nologin"] = "y"
+		fields["reason"] = "Password expired, change it in the mail portal"
+	}
+
+	return fields
+}
+
+// userdbFields returns Dovecot userdb fields of the mailbox. Quota rule
+// is the common one
+func userdbFields(u *models.User, t *models.Transport) map[string]string {
+	var (
+		uid, gid = mailboxOwner(u, t)
+		fields   = map[string]string{
+			"user":     string(u.Email),
+			"home":     mailboxPath(t, u.Login, u.DomainName),
+			"uid":      strconv.FormatUint(uint64(uid), 10),
+			"gid":      strconv.FormatUint(uint64(gid), 10),
+			"services": strings.Join(userServices(u), ","),
+		}
+	)
+
+	if QUOTARULE != "" {
+		fields["quota_rule"] = QUOTARULE
+	}
+
+	return fields
+}
+
+// userServices returns names of the services enabled for the user
+func userServices(u *models.User) (list []string) {
+	for _, i := range []struct {
+		name string
+		on   models.Boolean
+	}{
+		{"smtp", u.Smtp},
+		{"imap", u.Imap},
+		{"pop3", u.Pop3},
+		{"sieve", u.Sieve},
+	} {
+		if i.on {
+			list = append(list, i.name)
+		}
+	}
+
+	return
+}
+
+// serviceAllowed checks the user flag of the Dovecot service, services
+// without the flag (lmtp, doveadm) are always allowed
+func serviceAllowed(u *models.User, service string) bool {
+	switch service {
+	case "imap":
+		return bool(u.Imap)
+
+	case "pop3":
+		return bool(u.Pop3)
+
+	case "smtp", "submission":
+		return bool(u.Smtp)
+
+	case "sieve", "managesieve":
+		return bool(u.Sieve)
+	}
+
+	return true
+}
@@ -5,16 +5,25 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/supar/dsncfg"
 	"mbmi-go/models"
+	"sync"
+	"time"
 )
 
 type Enviroment interface {
 	LogIface
 	models.Datastore
+	Usage() *UsageCollector
+	Hooks() MailboxHook
 }
 
 type Bus struct {
 	LogIface
 	models.Datastore
+
+	usage     *UsageCollector
+	usageOnce sync.Once
+	hooks     MailboxHook
+	hooksOnce sync.Once
 }
 
 func (b *Bus) openDB(driver *sql.DB) (err error) {
@@ -37,6 +46,50 @@ func (b *Bus) openDB(driver *sql.DB) (err error) {
 	return
 }
 
+// Usage returns mailbox usage collector
+func (b *Bus) Usage() *UsageCollector {
+	b.usageOnce.Do(func() {
+		b.usage = NewUsageCollector(time.Duration(USAGETTL) * time.Second)
+	})
+
+	return b.usage
+}
+
+// Hooks returns mailbox lifecycle hooks enabled by flags
+func (b *Bus) Hooks() MailboxHook {
+	b.hooksOnce.Do(func() {
+		b.hooks = newMailboxHooks()
+	})
+
+	return b.hooks
+}
+
+// txEnv is the environment with the datastore bound to the transaction
+type txEnv struct {
+	LogIface
+	models.Datastore
+
+	parent Enviroment
+}
+
+// Usage returns mailbox usage collector of the parent environment
+func (e *txEnv) Usage() *UsageCollector {
+	return e.parent.Usage()
+}
+
+// Hooks returns mailbox hooks of the parent environment
+func (e *txEnv) Hooks() MailboxHook {
+	return e.parent.Hooks()
+}
+
+// transaction calls fn with the environment bound to the transaction,
+// changes are committed if fn returns nil
+func transaction(env Enviroment, fn func(Enviroment) error) error {
+	return env.Transaction(func(ds models.Datastore) error {
+		return fn(&txEnv{LogIface: env, Datastore: ds, parent: env})
+	})
+}
+
 func (b *Bus) dsn() *dsncfg.Database {
 	return &dsncfg.Database{
 		Host:     DBADDRESS,
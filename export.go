@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mbmi-go/models"
+	"strconv"
+	"time"
+)
+
+// exportFormats maps export format to the content type
+var exportFormats = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"json":   "application/json; charset=utf-8",
+	"ndjson": "application/x-ndjson; charset=utf-8",
+}
+
+// exportWriter writes rows of the export, values follow the columns order
+type exportWriter interface {
+	Write([]interface{}) error
+	Close() error
+}
+
+// newExportWriter returns writer of the format, format must be one
+// of exportFormats
+func newExportWriter(format string, w io.Writer, columns []string) exportWriter {
+	switch format {
+	case "json":
+		return &jsonExport{w: w, columns: columns, list: true}
+
+	case "ndjson":
+		return &jsonExport{w: w, columns: columns}
+	}
+
+	return &csvExport{w: csv.NewWriter(w), columns: columns}
+}
+
+// csvExport writes header line and rows
+type csvExport struct {
+	w       *csv.Writer
+	columns []string
+	started bool
+}
+
+func (s *csvExport) header() error {
+	if s.started {
+		return nil
+	}
+
+	s.started = true
+
+	return s.w.Write(s.columns)
+}
+
+func (s *csvExport) Write(values []interface{}) (err error) {
+	var rec = make([]string, len(values))
+
+	if err = s.header(); err != nil {
+		return
+	}
+
+	for i, v := range values {
+		rec[i] = exportString(v)
+	}
+
+	return s.w.Write(rec)
+}
+
+func (s *csvExport) Close() (err error) {
+	if err = s.header(); err != nil {
+		return
+	}
+
+	s.w.Flush()
+
+	return s.w.Error()
+}
+
+// jsonExport writes objects as the JSON list or one object per line
+type jsonExport struct {
+	w       io.Writer
+	columns []string
+	list    bool
+	n       int
+}
+
+func (s *jsonExport) Write(values []interface{}) (err error) {
+	var (
+		data []byte
+		sep  = "\n"
+	)
+
+	if data, err = exportObject(s.columns, values); err != nil {
+		return
+	}
+
+	if s.list {
+		if sep = ","; s.n == 0 {
+			sep = "["
+		}
+	} else {
+		data = append(data, '\n')
+		sep = ""
+	}
+
+	s.n++
+
+	if _, err = io.WriteString(s.w, sep); err != nil {
+		return
+	}
+
+	_, err = s.w.Write(data)
+
+	return
+}
+
+func (s *jsonExport) Close() (err error) {
+	if !s.list {
+		return
+	}
+
+	if s.n == 0 {
+		_, err = io.WriteString(s.w, "[]\n")
+		return
+	}
+
+	_, err = io.WriteString(s.w, "]\n")
+
+	return
+}
+
+// exportObject encodes values to the object keeping columns order
+func exportObject(columns []string, values []interface{}) ([]byte, error) {
+	var b bytes.Buffer
+
+	b.WriteByte('{')
+
+	for i, c := range columns {
+		var (
+			err  error
+			key  []byte
+			data []byte
+		)
+
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		if key, err = json.Marshal(c); err != nil {
+			return nil, err
+		}
+
+		if data, err = json.Marshal(values[i]); err != nil {
+			return nil, err
+		}
+
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(data)
+	}
+
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
+
+// exportString formats value for the CSV cell
+func exportString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+
+	case string:
+		return v
+
+	case models.Email:
+		return string(v)
+
+	case models.Boolean:
+		if v {
+			return "1"
+		}
+
+		return "0"
+
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+
+		return v.Format(time.RFC3339)
+
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+
+	return fmt.Sprint(v)
+}
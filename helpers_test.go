@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql/driver"
 	"testing"
 )
 
@@ -50,3 +51,18 @@ func (l *TestingLogWrap) compat(err bool, v ...interface{}) {
 		}
 	}
 }
+
+// userMockColumns are the columns of the users query
+var userMockColumns = []string{
+	"id", "name", "login", "domid", "passwd", "uid", "gid", "smtp", "imap", "pop3",
+	"sieve", "manager", "domainname", "secret", "token", "password_changed_at", "must_change", "last_login",
+}
+
+// userRow returns row of the users query, the mailbox of the domain 1
+// with SMTP and IMAP enabled
+func userRow(id int64, login, domain string) []driver.Value {
+	return []driver.Value{
+		id, "Any User", login, 1, "secret", 8, 8, true, true, false,
+		false, false, domain, "", "", nil, false, nil,
+	}
+}
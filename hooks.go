@@ -0,0 +1,389 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mbmi-go/models"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mailbox lifecycle events passed to the hook script
+const (
+	hookCreated = "created"
+	hookDeleted = "deleted"
+)
+
+// Standard Maildir++ folders created with the mailbox
+var maildirFolders = []string{"Drafts", "Sent", "Trash", "Junk"}
+
+// MailboxHook is notified when the mailbox is created or deleted.
+// The database is already changed, so errors are only logged
+type MailboxHook interface {
+	Created(u *models.User, t *models.Transport) error
+	Deleted(u *models.User, t *models.Transport) error
+}
+
+// mailboxHooks calls every hook in order, all of them are called
+// even if one fails
+type mailboxHooks []MailboxHook
+
+func (h mailboxHooks) Created(u *models.User, t *models.Transport) error {
+	return h.each(func(i MailboxHook) error {
+		return i.Created(u, t)
+	})
+}
+
+func (h mailboxHooks) Deleted(u *models.User, t *models.Transport) error {
+	return h.each(func(i MailboxHook) error {
+		return i.Deleted(u, t)
+	})
+}
+
+func (h mailboxHooks) each(fn func(MailboxHook) error) error {
+	var msg []string
+
+	for _, i := range h {
+		if err := fn(i); err != nil {
+			msg = append(msg, err.Error())
+		}
+	}
+
+	if len(msg) > 0 {
+		return errors.New(strings.Join(msg, "; "))
+	}
+
+	return nil
+}
+
+// newMailboxHooks returns hooks enabled by flags, nil if there are none
+func newMailboxHooks() MailboxHook {
+	var h = mailboxHooks{}
+
+	if HOOKMAILDIR {
+		h = append(h, &fsHook{archive: ARCHIVEDIR})
+	}
+
+	if HOOKSCRIPT != "" {
+		h = append(h, &scriptHook{path: HOOKSCRIPT})
+	}
+
+	if len(h) == 0 {
+		return nil
+	}
+
+	return h
+}
+
+// mailboxOwner returns ids of the mailbox files, zero user ids
+// are replaced with the transport ones
+func mailboxOwner(u *models.User, t *models.Transport) (uint, uint) {
+	if u.Uid == 0 {
+		return t.Uid, t.Gid
+	}
+
+	return u.Uid, u.Gid
+}
+
+// fsHook creates maildir of the new mailbox and archives the removed one.
+// Maildir is removed without archive if the archive directory is empty
+type fsHook struct {
+	archive string
+}
+
+// Created makes maildir with the standard folders, existing maildir
+// is left as is
+func (h *fsHook) Created(u *models.User, t *models.Transport) (err error) {
+	var (
+		mailbox  string
+		uid, gid = mailboxOwner(u, t)
+		dirs     []string
+		parents  []string
+	)
+
+	if mailbox, err = mailboxDir(t, u.Login, u.DomainName); err != nil {
+		return
+	}
+
+	dirs = append(dirs, mailbox)
+
+	if _, err = os.Stat(mailbox); err == nil {
+		return nil
+	}
+
+	// Missing parents, e.g. the domain directory, are created here too
+	for d := filepath.Dir(mailbox); d != filepath.Dir(d); d = filepath.Dir(d) {
+		if _, err := os.Stat(d); !os.IsNotExist(err) {
+			break
+		}
+
+		parents = append(parents, d)
+	}
+
+	for _, f := range maildirFolders {
+		dirs = append(dirs, filepath.Join(mailbox, "."+f))
+	}
+
+	for _, d := range dirs {
+		for _, sub := range []string{"cur", "new", "tmp"} {
+			if err = os.MkdirAll(filepath.Join(d, sub), 0700); err != nil {
+				return
+			}
+		}
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(mailbox, "subscriptions"),
+		[]byte(strings.Join(maildirFolders, "\n")+"\n"), 0600); err != nil {
+		return
+	}
+
+	if os.Geteuid() != 0 {
+		return
+	}
+
+	for _, d := range parents {
+		if err = os.Chown(d, int(uid), int(gid)); err != nil {
+			return
+		}
+	}
+
+	return filepath.Walk(mailbox, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		return os.Lchown(path, int(uid), int(gid))
+	})
+}
+
+// Deleted packs maildir to the archive and removes it
+func (h *fsHook) Deleted(u *models.User, t *models.Transport) (err error) {
+	var mailbox string
+
+	if mailbox, err = mailboxDir(t, u.Login, u.DomainName); err != nil {
+		return
+	}
+
+	if _, err = os.Stat(mailbox); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	if h.archive != "" {
+		name := filepath.Join(h.archive, u.DomainName,
+			u.Login+"-"+time.Now().Format("20060102150405")+".tar.gz")
+
+		if err = archiveMaildir(mailbox, name); err != nil {
+			return fmt.Errorf("Cannot archive %s: %s", mailbox, err.Error())
+		}
+	}
+
+	return os.RemoveAll(mailbox)
+}
+
+// archiveMaildir writes directory to the gzipped tarball, the file
+// appears under the name only when it is complete
+func archiveMaildir(dir, name string) (err error) {
+	var (
+		tmp *os.File
+		gz  *gzip.Writer
+		tw  *tar.Writer
+
+		base = filepath.Base(dir)
+	)
+
+	if err = os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return
+	}
+
+	if tmp, err = ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)); err != nil {
+		return
+	}
+
+	defer os.Remove(tmp.Name())
+
+	gz = gzip.NewWriter(tmp)
+	tw = tar.NewWriter(gz)
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		var (
+			hdr  *tar.Header
+			rel  string
+			link string
+			f    *os.File
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if rel, err = filepath.Rel(dir, path); err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		if hdr, err = tar.FileInfoHeader(info, link); err != nil {
+			return err
+		}
+
+		hdr.Name = filepath.ToSlash(filepath.Join(base, rel))
+
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if f, err = os.Open(path); err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+
+		return err
+	})
+
+	if err == nil {
+		err = tw.Close()
+	}
+
+	if err == nil {
+		err = gz.Close()
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// purgeArchive removes archives older than the retention period
+func purgeArchive(log LogIface, dir string, days int) error {
+	if dir == "" || days <= 0 {
+		return nil
+	}
+
+	before := time.Now().AddDate(0, 0, -days)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !strings.HasSuffix(path, ".tar.gz") || !info.ModTime().Before(before) {
+			return nil
+		}
+
+		if err = os.Remove(path); err != nil {
+			return err
+		}
+
+		log.Info("Archive %s is purged", path)
+
+		return nil
+	})
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// scriptHook runs external program with the event name, email and
+// maildir path as arguments. The same values and the owner ids are
+// passed in the MBMI_ environment variables
+type scriptHook struct {
+	path string
+}
+
+func (h *scriptHook) Created(u *models.User, t *models.Transport) error {
+	return h.run(hookCreated, u, t)
+}
+
+func (h *scriptHook) Deleted(u *models.User, t *models.Transport) error {
+	return h.run(hookDeleted, u, t)
+}
+
+func (h *scriptHook) run(event string, u *models.User, t *models.Transport) error {
+	var (
+		out      bytes.Buffer
+		uid, gid = mailboxOwner(u, t)
+	)
+
+	mailbox, err := mailboxDir(t, u.Login, u.DomainName)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(h.path, event, string(u.Email), mailbox)
+
+	cmd.Env = append(os.Environ(),
+		"MBMI_EVENT="+event,
+		"MBMI_EMAIL="+string(u.Email),
+		"MBMI_MAILDIR="+mailbox,
+		"MBMI_UID="+strconv.FormatUint(uint64(uid), 10),
+		"MBMI_GID="+strconv.FormatUint(uint64(gid), 10),
+	)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Hook %s %s failed: %s: %s", h.path, event, err.Error(),
+			strings.TrimSpace(out.String()))
+	}
+
+	return nil
+}
+
+// runMailboxHook calls the hook for the user with its transport
+func runMailboxHook(env Enviroment, id interface{}, event string, u *models.User) {
+	var err error
+
+	if env.Hooks() == nil {
+		return
+	}
+
+	t, resp := userTransport(env, id, u.Domain)
+	if resp != nil {
+		return
+	}
+
+	if event == hookCreated {
+		err = env.Hooks().Created(u, t)
+	} else {
+		err = env.Hooks().Deleted(u, t)
+	}
+
+	if err != nil {
+		env.Error("%s: Mailbox %s hook of %s: %s", id, event, u.Email, err.Error())
+	}
+}
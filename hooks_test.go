@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"mbmi-go/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_FsHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbmi-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var (
+		user    = &models.User{Login: "some", DomainName: "user.net", Email: "some@user.net"}
+		tr      = &models.Transport{Root: filepath.Join(dir, "mail")}
+		hook    = &fsHook{archive: filepath.Join(dir, "archive")}
+		mailbox = filepath.Join(dir, "mail", "user.net", "some")
+	)
+
+	if err = hook.Created(user, tr); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"cur", "new", "tmp", ".Sent/cur", ".Trash/tmp", "subscriptions"} {
+		if _, err = os.Stat(filepath.Join(mailbox, p)); err != nil {
+			t.Errorf("Maildir is not complete: %s", err)
+		}
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(mailbox, "new", "1.msg"), []byte("Subject: test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = hook.Deleted(user, tr); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(mailbox); !os.IsNotExist(err) {
+		t.Errorf("Maildir is not removed: %v", err)
+	}
+
+	archives, _ := filepath.Glob(filepath.Join(dir, "archive", "user.net", "some-*.tar.gz"))
+	if len(archives) != 1 {
+		t.Fatalf("Expected one archive, got %v", archives)
+	}
+
+	f, err := os.Open(archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		found   bool
+		tarball = tar.NewReader(gz)
+	)
+
+	for {
+		hdr, err := tarball.Next()
+		if err != nil {
+			break
+		}
+
+		if hdr.Name == "some/new/1.msg" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Error("Message is not archived")
+	}
+
+	old := time.Now().AddDate(0, 0, -31)
+	if err = os.Chtimes(archives[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = purgeArchive(initTestBus(t, true), filepath.Join(dir, "archive"), 30); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = os.Stat(archives[0]); !os.IsNotExist(err) {
+		t.Errorf("Archive is not purged: %v", err)
+	}
+}
+
+func Test_ScriptHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbmi-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var (
+		out    = filepath.Join(dir, "out")
+		script = filepath.Join(dir, "hook.sh")
+		user   = &models.User{Login: "some", DomainName: "user.net", Email: "some@user.net"}
+		tr     = &models.Transport{Root: dir, Uid: 8, Gid: 12}
+	)
+
+	if err = ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$1 $2 $MBMI_UID\" > "+out+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = (&scriptHook{path: script}).Created(user, tr); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(out)
+	if strings.TrimSpace(string(data)) != "created some@user.net 8" {
+		t.Errorf("Unexpected script arguments %q", data)
+	}
+
+	if err = (&scriptHook{path: filepath.Join(dir, "none")}).Deleted(user, tr); err == nil {
+		t.Error("Missing script should fail")
+	}
+}
+
+// recordHook keeps emails of the hooked mailboxes
+type recordHook struct {
+	created []string
+	deleted []string
+}
+
+func (h *recordHook) Created(u *models.User, t *models.Transport) error {
+	h.created = append(h.created, string(u.Email))
+	return nil
+}
+
+func (h *recordHook) Deleted(u *models.User, t *models.Transport) error {
+	h.deleted = append(h.deleted, string(u.Email))
+	return nil
+}
+
+func Test_MailboxDir(t *testing.T) {
+	var tr = &models.Transport{Root: "/var/mail"}
+
+	if path, err := mailboxDir(tr, "user", "example.com"); err != nil || path != "/var/mail/example.com/user" {
+		t.Errorf("Unexpected mailbox %q: %v", path, err)
+	}
+
+	for _, login := range []string{"../..", "../../etc", "../../../x"} {
+		if path, err := mailboxDir(tr, login, "example.com"); err == nil {
+			t.Errorf("Mailbox %q outside of root accepted: %s", login, path)
+		}
+	}
+
+	for _, login := range []string{"", "a/b", "..x", "../example.com", "a\x00b"} {
+		if checkMailboxLogin(login) == nil {
+			t.Errorf("Login %q accepted", login)
+		}
+	}
+
+	if err := checkMailboxLogin("john.doe"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	// errImportFailed rolls back import with invalid rows
+	errImportFailed = errors.New("Import failed, nothing was saved")
+	// errDryRun rolls back checked import
+	errDryRun = errors.New("Dry run")
+)
+
+// ImportResult is the report line of the imported row
+type ImportResult struct {
+	Row     int               `json:"row"`
+	Key     string            `json:"key"`
+	Id      int64             `json:"id,omitempty"`
+	Status  string            `json:"status"`
+	Message string            `json:"message,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// ImportReport is the import response
+type ImportReport struct {
+	DryRun    bool            `json:"dry_run"`
+	Committed bool            `json:"committed"`
+	Total     int             `json:"total"`
+	Failed    int             `json:"failed"`
+	Rows      []*ImportResult `json:"rows"`
+}
+
+// Import row statuses
+const (
+	importCreated = "created"
+	importValid   = "valid"
+	importInvalid = "invalid"
+)
+
+// importRows reads the request body to the form values, so rows are
+// decoded the same way as the single record forms. JSON list of objects
+// or CSV with the header line are accepted
+func importRows(r *http.Request) ([]url.Values, error) {
+	defer r.Body.Close()
+
+	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		return importJSON(r.Body)
+	}
+
+	return importCSV(r.Body)
+}
+
+func importCSV(src io.Reader) (rows []url.Values, err error) {
+	var (
+		header []string
+		rec    []string
+		rd     = csv.NewReader(src)
+	)
+
+	rd.TrimLeadingSpace = true
+
+	if header, err = rd.Read(); err != nil {
+		if err == io.EOF {
+			err = errors.New("Empty import data")
+		}
+
+		return
+	}
+
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	for {
+		if rec, err = rd.Read(); err == io.EOF {
+			return rows, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var v = url.Values{}
+
+		for i, str := range rec {
+			if str = strings.TrimSpace(str); str != "" {
+				v.Add(header[i], str)
+			}
+		}
+
+		rows = append(rows, v)
+	}
+}
+
+func importJSON(src io.Reader) (rows []url.Values, err error) {
+	var list []map[string]interface{}
+
+	if err = json.NewDecoder(src).Decode(&list); err != nil {
+		return
+	}
+
+	for i, item := range list {
+		var v = url.Values{}
+
+		for name, value := range item {
+			var values = []interface{}{value}
+
+			if l, ok := value.([]interface{}); ok {
+				values = l
+			}
+
+			for _, value = range values {
+				switch value := value.(type) {
+				case nil:
+
+				case string:
+					v.Add(name, value)
+
+				case float64:
+					v.Add(name, strconv.FormatFloat(value, 'f', -1, 64))
+
+				case bool:
+					v.Add(name, strconv.FormatBool(value))
+
+				default:
+					return nil, fmt.Errorf("row %d: unsupported value of %s", i+1, name)
+				}
+			}
+		}
+
+		rows = append(rows, v)
+	}
+
+	return
+}
+
+// importDryRun checks dry_run query parameter
+func importDryRun(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	return v
+}
+
+// finish counts failed rows and returns error to roll back transaction
+func (s *ImportReport) finish() error {
+	s.Total = len(s.Rows)
+
+	for _, i := range s.Rows {
+		if i.Status == importInvalid {
+			s.Failed++
+		}
+	}
+
+	switch {
+	case s.Failed > 0:
+		return errImportFailed
+
+	case s.DryRun:
+		return errDryRun
+	}
+
+	return nil
+}
+
+// invalid marks the row failed with the response error
+func (s *ImportResult) invalid(e *Error) {
+	s.Status = importInvalid
+	s.Message = e.Message
+	s.Fields = e.Fields
+}
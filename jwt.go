@@ -6,6 +6,16 @@ import (
 	"mbmi-go/models"
 )
 
+// Token subjects
+const (
+	// Manager access to the all routes
+	subjectAuthentication = "authentication"
+	// Access to change own password only, issued if the password is expired
+	subjectPassword = "password"
+	// Mailbox owner access to the own account
+	subjectSelf = "self"
+)
+
 // Token represent user authorization data
 type Token struct {
 	JWT      string `json:"jwt"`
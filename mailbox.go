@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mbmi-go/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// mailboxPath returns maildir location of the user under the transport root.
+// The MAILBOXPATH template accepts %d - domain, %n - login and %u - full email
+func mailboxPath(t *models.Transport, login, domain string) string {
+	var (
+		path = strings.NewReplacer(
+			"%d", domain,
+			"%n", login,
+			"%u", login+"@"+domain,
+		).Replace(MAILBOXPATH)
+	)
+
+	if filepath.IsAbs(path) || t == nil {
+		return filepath.Clean(path)
+	}
+
+	return filepath.Join(t.Root, path)
+}
+
+// mailboxRoot returns directory which contains all maildirs of the
+// transport: the root or the static part of the absolute template
+func mailboxRoot(t *models.Transport) string {
+	if !filepath.IsAbs(MAILBOXPATH) && t != nil {
+		return filepath.Clean(t.Root)
+	}
+
+	if i := strings.IndexByte(MAILBOXPATH, '%'); i >= 0 {
+		return filepath.Dir(MAILBOXPATH[:i])
+	}
+
+	return filepath.Dir(filepath.Clean(MAILBOXPATH))
+}
+
+// mailboxDir returns maildir of the user the same way mailboxPath does,
+// the path is refused if it is not under the mailbox root
+func mailboxDir(t *models.Transport, login, domain string) (string, error) {
+	var (
+		path = mailboxPath(t, login, domain)
+		root = mailboxRoot(t)
+	)
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Mailbox %s is outside of %s", path, root)
+	}
+
+	return path, nil
+}
+
+// checkMailboxLogin returns error if the login can not be used as the
+// maildir name
+func checkMailboxLogin(login string) error {
+	if login == "" {
+		return errors.New("Login required")
+	}
+
+	if strings.ContainsAny(login, "/\x00") || strings.Contains(login, "..") {
+		return errors.New("Login must not contain /, .. or NUL")
+	}
+
+	return nil
+}
+
+// mailboxMove is the maildir relocation which can be reverted until
+// it is finished
+type mailboxMove struct {
+	from   string
+	to     string
+	copied bool
+}
+
+// moveMailbox relocates maildir, it is copied if the target is on another
+// device. Nil is returned if there is nothing to move
+func moveMailbox(from, to string) (m *mailboxMove, err error) {
+	if from == to {
+		return nil, nil
+	}
+
+	if _, err = os.Stat(from); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+
+	if _, err = os.Lstat(to); err == nil {
+		return nil, fmt.Errorf("Mailbox %s already exists", to)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return
+	}
+
+	m = &mailboxMove{from: from, to: to}
+
+	if err = os.Rename(from, to); err == nil {
+		return
+	}
+
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EXDEV {
+		return nil, err
+	}
+
+	m.copied = true
+
+	if err = copyTree(from, to); err != nil {
+		os.RemoveAll(to)
+		return nil, err
+	}
+
+	return
+}
+
+// revert returns maildir back to the source location
+func (m *mailboxMove) revert() error {
+	if m == nil {
+		return nil
+	}
+
+	if m.copied {
+		return os.RemoveAll(m.to)
+	}
+
+	return os.Rename(m.to, m.from)
+}
+
+// finish changes owner of the moved maildir and removes the copied source
+func (m *mailboxMove) finish(uid, gid uint) (err error) {
+	if m == nil {
+		return
+	}
+
+	if os.Geteuid() == 0 && uid > 0 {
+		err = filepath.Walk(m.to, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			return os.Lchown(path, int(uid), int(gid))
+		})
+
+		if err != nil {
+			return
+		}
+	}
+
+	if m.copied {
+		return os.RemoveAll(m.from)
+	}
+
+	return
+}
+
+// copyTree copies directory with files and links keeping permissions
+func copyTree(from, to string) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		var (
+			rel    string
+			target string
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if rel, err = filepath.Rel(from, path); err != nil {
+			return err
+		}
+
+		target = filepath.Join(to, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(link, target)
+		}
+
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(from, to string, mode os.FileMode) (err error) {
+	var src, dst *os.File
+
+	if src, err = os.Open(from); err != nil {
+		return
+	}
+
+	defer src.Close()
+
+	if dst, err = os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode); err != nil {
+		return
+	}
+
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return
+	}
+
+	return dst.Close()
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 var (
@@ -14,6 +16,18 @@ var (
 	programVersion,
 	// Listen
 	SERVERADDRESS,
+	// Postfix socketmap listen
+	SOCKETMAPADDRESS,
+	// Dovecot dict listen
+	DICTADDRESS,
+	// Dovecot userdb quota rule
+	QUOTARULE,
+	// Static maps directory of the export-maps command
+	MAPSDIR,
+	// Command called when the static maps are changed
+	MAPSCOMMAND,
+	// Postfix tcp_table listen, map=address list
+	TCPTABLEADDRESS,
 	// Assets
 	ASSETSPATH,
 	// JWT secret
@@ -27,7 +41,39 @@ var (
 	// Database name
 	DBNAME,
 	// Database address
-	DBADDRESS string
+	DBADDRESS,
+	// Mailbox path template relative to the transport root
+	MAILBOXPATH,
+	// Password character classes required by default policy
+	POLICYCLASSES,
+	// Password banned words file
+	POLICYBANNED,
+	// Breached passwords SHA-1 corpus file
+	POLICYBREACH,
+	// Sieve scripts directory relative to the mailbox
+	SIEVEDIR,
+	// Active sieve script link relative to the mailbox
+	SIEVEACTIVE,
+	// Directory of the removed mailboxes archives
+	ARCHIVEDIR,
+	// Program called on the mailbox creation and removal
+	HOOKSCRIPT string
+	// Mailbox usage cache lifetime in seconds
+	USAGETTL,
+	// Password minimal length
+	POLICYLENGTH,
+	// Number of previous passwords that can't be reused
+	POLICYHISTORY,
+	// Maximum password age in days
+	POLICYMAXAGE,
+	// Scheduled jobs period in seconds
+	SCHEDULEINTERVAL,
+	// Removed mailboxes archive lifetime in days
+	ARCHIVEDAYS,
+	// Soft deleted records lifetime in days
+	TRASHDAYS int
+	// Create maildir of the new mailbox and archive the removed one
+	HOOKMAILDIR bool
 	// PrintVersion respresents flag to print program version and exit
 	PrintVersion bool
 	// ConsoleLogFlag respresents log level messages to the console stdout
@@ -43,10 +89,32 @@ func init() {
 	flag.StringVar(&ASSETSPATH, "A", "/usr/share/mbmi/assets", "Frontend")
 	flag.StringVar(&SECRETPHRASE, "S", "", "Use static secret, othervise create it random on start")
 	flag.StringVar(&SERVERADDRESS, "L", "127.0.0.1:8080", "Address listen on")
+	flag.StringVar(&TCPTABLEADDRESS, "Lt", "", "Postfix tcp_table addresses listen on, comma separated map=host:port list, empty - disabled")
+	flag.StringVar(&DICTADDRESS, "Ld", "", "Dovecot dict address listen on, unix:/path or host:port, empty - disabled")
+	flag.StringVar(&QUOTARULE, "Mq", "", "Dovecot userdb quota rule, e.g. *:storage=1G, empty - not set")
+	flag.StringVar(&MAPSDIR, "Xd", "/etc/mbmi/maps", "export-maps: directory of the Postfix maps and Dovecot passwd-file")
+	flag.StringVar(&MAPSCOMMAND, "Xc", "", "export-maps: shell command run on changes, the changed hash maps are appended as arguments and all changed files are in MBMI_CHANGED")
+	flag.StringVar(&SOCKETMAPADDRESS, "Ls", "", "Postfix socketmap address listen on, unix:/path or host:port, empty - disabled")
 	flag.StringVar(&DBUSER, "Du", "nobody", "Database user")
 	flag.StringVar(&DBPASS, "Dp", "", "Database user password")
 	flag.StringVar(&DBNAME, "Db", "mail", "Database name")
 	flag.StringVar(&DBADDRESS, "Dh", "localhost", "Database address")
+	flag.StringVar(&MAILBOXPATH, "Mp", "%d/%n", "Mailbox path template under transport root, %d - domain, %n - login, %u - email")
+	flag.IntVar(&USAGETTL, "Mt", 300, "Mailbox usage cache lifetime in seconds")
+	flag.IntVar(&POLICYLENGTH, "Pl", 8, "Password policy: minimal length")
+	flag.StringVar(&POLICYCLASSES, "Pc", "lud", "Password policy: required classes, l - lower, u - upper, d - digits, s - symbols")
+	flag.StringVar(&POLICYBANNED, "Pb", "", "Password policy: banned words file, one word per line")
+	flag.StringVar(&POLICYBREACH, "Pz", "", "Password policy: breached passwords file, SHA-1 sorted HIBP format")
+	flag.IntVar(&POLICYHISTORY, "Ph", 0, "Password policy: number of previous passwords that can't be reused")
+	flag.IntVar(&POLICYMAXAGE, "Pa", 0, "Password policy: maximum password age in days, 0 - never expires")
+	flag.StringVar(&SIEVEDIR, "Sd", "sieve", "Sieve scripts directory relative to the mailbox")
+	flag.StringVar(&SIEVEACTIVE, "Sa", ".dovecot.sieve", "Active sieve script link relative to the mailbox")
+	flag.IntVar(&SCHEDULEINTERVAL, "Si", 60, "Scheduled jobs period in seconds")
+	flag.BoolVar(&HOOKMAILDIR, "Hm", false, "Create maildir of the new mailbox and archive the removed one")
+	flag.StringVar(&ARCHIVEDIR, "Ha", "/var/lib/mbmi/archive", "Removed mailboxes archive directory, empty - remove without archive")
+	flag.IntVar(&ARCHIVEDAYS, "Hr", 30, "Removed mailboxes archive lifetime in days, 0 - keep forever")
+	flag.IntVar(&TRASHDAYS, "Td", 30, "Deleted users, aliases and bcc rules are purged after days, 0 - keep forever")
+	flag.StringVar(&HOOKSCRIPT, "Hs", "", "Program called with event (created, deleted), email and maildir arguments")
 	flag.IntVar(&ConsoleLogFlag, "v", 0, "Console verbose output, default 0 - off, 7 - debug")
 	flag.BoolVar(&PrintVersion, "V", false, "Print version")
 }
@@ -75,10 +143,40 @@ func main() {
 		}
 	}
 
+	if POLICYBANNED != "" {
+		if list, err := loadBannedWords(POLICYBANNED); err != nil {
+			env.Fatal(err)
+		} else {
+			bannedWords = list
+		}
+	}
+
+	if POLICYBREACH != "" {
+		if b, err := NewBreachChecker(POLICYBREACH); err != nil {
+			env.Fatal(err)
+		} else {
+			breaches = b
+		}
+	}
+
 	if err := env.openDB(nil); err != nil {
 		env.Fatal(err)
 	}
 
+	// Commands
+	switch flag.Arg(0) {
+	case "":
+	case "export-maps":
+		if _, err := exportMaps(env, MAPSDIR, MAPSCOMMAND); err != nil {
+			env.Fatal(err)
+		}
+
+		return
+
+	default:
+		env.Fatal(fmt.Errorf("Unknown command %s", flag.Arg(0)))
+	}
+
 	// Create router
 	router = NewRouter()
 
@@ -88,6 +186,124 @@ func main() {
 		env,
 	))
 
+	// Auto reply
+	router.Handle("GET", "/user/:uid/vacation", NewHandler(
+		Protect(Vacation),
+		env,
+	))
+	router.Handle("PUT", "/user/:uid/vacation", NewHandler(
+		Protect(SetVacation),
+		env,
+	))
+
+	// Export
+	router.Handle("GET", "/export/:entity", NewHandler(
+		Protect(Export),
+		env,
+	))
+
+	// Bulk import
+	router.Handle("POST", "/import/users", NewHandler(
+		Protect(ImportUsers),
+		env,
+	))
+	router.Handle("POST", "/import/aliases", NewHandler(
+		Protect(ImportAliases),
+		env,
+	))
+
+	// Change mailbox address
+	router.Handle("POST", "/user/:uid/rename", NewHandler(
+		Protect(RenameUser),
+		env,
+	))
+
+	// Move mailbox to another domain
+	router.Handle("POST", "/user/:uid/move", NewHandler(
+		Protect(MoveUser),
+		env,
+	))
+
+	// Account suspension
+	router.Handle("GET", "/user/:uid/suspension", NewHandler(
+		Protect(Suspension),
+		env,
+	))
+	router.Handle("POST", "/user/:uid/suspend", NewHandler(
+		Protect(Suspend),
+		env,
+	))
+	router.Handle("POST", "/user/:uid/resume", NewHandler(
+		Protect(Resume),
+		env,
+	))
+
+	// Sieve filter rules
+	router.Handle("GET", "/user/:uid/sieve", NewHandler(
+		Protect(SieveRules),
+		env,
+	))
+	router.Handle("PUT", "/user/:uid/sieve", NewHandler(
+		Protect(SetSieveRules),
+		env,
+	))
+	router.Handle("POST", "/sieve/validate", NewHandler(
+		Protect(ValidateSieve),
+		env,
+	))
+	router.Handle("POST", "/sieve/parse", NewHandler(
+		Protect(ParseSieve),
+		env,
+	))
+
+	// Mailbox owner login
+	router.Handle("POST", "/self/login", NewHandler(
+		secretWrap(SelfLogin, SECRETPHRASE),
+		env,
+	))
+
+	// Mailbox owner self service, manager routes are closed for the self token
+	router.Handle("GET", "/self", NewHandler(
+		ProtectWith(SelfAccount, subjectSelf),
+		env,
+	))
+	router.Handle("PUT", "/self/password", NewHandler(
+		ProtectWith(SetPassword, subjectSelf, subjectPassword),
+		env,
+	))
+	router.Handle("GET", "/self/forwards", NewHandler(
+		ProtectWith(SelfForwards, subjectSelf),
+		env,
+	))
+	router.Handle("POST", "/self/forward", NewHandler(
+		ProtectWith(SetSelfForward, subjectSelf),
+		env,
+	))
+	router.Handle("DELETE", "/self/forward/:aid", NewHandler(
+		ProtectWith(DelSelfForward, subjectSelf),
+		env,
+	))
+	router.Handle("GET", "/self/vacation", NewHandler(
+		ProtectWith(SelfVacation, subjectSelf),
+		env,
+	))
+	router.Handle("PUT", "/self/vacation", NewHandler(
+		ProtectWith(SetSelfVacation, subjectSelf),
+		env,
+	))
+	router.Handle("GET", "/self/sieve", NewHandler(
+		ProtectWith(SelfSieveRules, subjectSelf),
+		env,
+	))
+	router.Handle("PUT", "/self/sieve", NewHandler(
+		ProtectWith(SetSelfSieveRules, subjectSelf),
+		env,
+	))
+	router.Handle("GET", "/self/stats", NewHandler(
+		ProtectWith(SelfStat, subjectSelf),
+		env,
+	))
+
 	// Authentication tokens
 	router.Handle("GET", "/application/jwt/:uid", NewHandler(
 		Protect(GetUserJWT),
@@ -145,11 +361,29 @@ func main() {
 		Protect(DelUser),
 		env,
 	))
+	router.Handle("PUT", "/user/:uid/password", NewHandler(
+		ProtectWith(SetPassword, subjectAuthentication, subjectPassword),
+		env,
+	))
 	router.Handle("GET", "/password", NewHandler(
 		Protect(Password),
 		env,
 	))
 
+	// Password policy
+	router.Handle("GET", "/password/policy", NewHandler(
+		Protect(PasswordPolicy),
+		env,
+	))
+	router.Handle("PUT", "/password/policy/:did", NewHandler(
+		Protect(SetPasswordPolicy),
+		env,
+	))
+	router.Handle("DELETE", "/password/policy/:did", NewHandler(
+		Protect(DelPasswordPolicy),
+		env,
+	))
+
 	// Accesses
 	router.Handle("GET", "/accesses", NewHandler(
 		Protect(Accesses),
@@ -172,8 +406,26 @@ func main() {
 		env,
 	))
 
+	// Dovecot passdb fields
+	router.Handle("GET", "/passdb/:email", NewHandler(
+		Protect(Passdb),
+		env,
+	))
+
+	// Dovecot Lua passdb authentication
+	router.Handle("POST", "/passdb", NewHandler(
+		Protect(PassdbAuth),
+		env,
+	))
+
+	// Delivery simulation of the address
+	router.Handle("GET", "/resolve", NewHandler(
+		Protect(Resolve),
+		env,
+	))
+
 	// Web hooks
-	// Update imap logins
+	// Update imap logins, replaced by the /passdb authentication
 	router.Handle("POST", "/stat/imap/:uid", NewHandler(
 		Protect(StatImapLogin),
 		env,
@@ -215,6 +467,60 @@ func main() {
 		env,
 	))
 
+	// Distribution groups
+	router.Handle("GET", "/groups", NewHandler(
+		Protect(Groups),
+		env,
+	))
+	router.Handle("GET", "/group/:gid", NewHandler(
+		Protect(Group),
+		env,
+	))
+	router.Handle("POST", "/group", NewHandler(
+		Protect(SetGroup),
+		env,
+	))
+	router.Handle("PUT", "/group/:gid", NewHandler(
+		Protect(SetGroup),
+		env,
+	))
+	router.Handle("DELETE", "/group/:gid", NewHandler(
+		Protect(DelGroup),
+		env,
+	))
+
+	// Add, remove or replace group members
+	router.Handle("POST", "/group/:gid/members", NewHandler(
+		Protect(GroupMembers),
+		env,
+	))
+	router.Handle("DELETE", "/group/:gid/members", NewHandler(
+		Protect(GroupMembers),
+		env,
+	))
+	router.Handle("PUT", "/group/:gid/members", NewHandler(
+		Protect(GroupMembers),
+		env,
+	))
+
+	// Soft deleted records
+	router.Handle("GET", "/trash", NewHandler(
+		Protect(Trash),
+		env,
+	))
+
+	// Restore soft deleted record
+	router.Handle("POST", "/trash/:kind/:id/restore", NewHandler(
+		Protect(RestoreTrash),
+		env,
+	))
+
+	// Purge soft deleted record
+	router.Handle("DELETE", "/trash/:kind/:id", NewHandler(
+		Protect(PurgeTrash),
+		env,
+	))
+
 	// Handle NotFound
 	if ASSETSPATH != "" {
 		router.NotFound = http.FileServer(http.Dir(ASSETSPATH))
@@ -222,6 +528,69 @@ func main() {
 		env.Notice("Using file server with public=%s for unknown routes", ASSETSPATH)
 	}
 
+	// Background jobs
+	scheduler := NewScheduler(env)
+	scheduler.Every("vacation", time.Duration(SCHEDULEINTERVAL)*time.Second, func() error {
+		return syncVacations(env)
+	})
+	scheduler.Every("suspension", time.Duration(SCHEDULEINTERVAL)*time.Second, func() error {
+		return syncSuspensions(env)
+	})
+	scheduler.Every("alias-expiry", time.Duration(SCHEDULEINTERVAL)*time.Second, func() error {
+		return expireAliases(env)
+	})
+	scheduler.Every("trash", time.Hour, func() error {
+		return purgeTrash(env, TRASHDAYS)
+	})
+	scheduler.Every("archive", time.Hour, func() error {
+		return purgeArchive(env, ARCHIVEDIR, ARCHIVEDAYS)
+	})
+	scheduler.Start()
+
+	if SOCKETMAPADDRESS != "" {
+		go func() {
+			if err := NewSocketmapServer(env).ListenAndServe(SOCKETMAPADDRESS); err != nil {
+				env.Fatal(err)
+			}
+		}()
+
+		env.Notice("Serving Postfix socketmap on %s", SOCKETMAPADDRESS)
+	}
+
+	if DICTADDRESS != "" {
+		go func() {
+			if err := NewDictServer(env).ListenAndServe(DICTADDRESS); err != nil {
+				env.Fatal(err)
+			}
+		}()
+
+		env.Notice("Serving Dovecot dict on %s", DICTADDRESS)
+	}
+
+	for _, item := range strings.Split(TCPTABLEADDRESS, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		i := strings.IndexByte(item, '=')
+		if i < 1 {
+			env.Fatal(fmt.Errorf("Invalid tcp_table address %s, map=address expected", item))
+		}
+
+		srv, err := NewTCPTableServer(env, item[:i])
+		if err != nil {
+			env.Fatal(err)
+		}
+
+		go func(address string) {
+			if err := srv.ListenAndServe(address); err != nil {
+				env.Fatal(err)
+			}
+		}(item[i+1:])
+
+		env.Notice("Serving Postfix tcp_table %s on %s", item[:i], item[i+1:])
+	}
+
 	http.ListenAndServe(SERVERADDRESS, Middlewares(
 		router,
 		JWT(SECRETPHRASE, env),
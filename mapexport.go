@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mbmi-go/models"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Map files written by export-maps
+const (
+	mapVirtualMailbox = "virtual_mailbox_maps"
+	mapVirtualAlias   = "virtual_alias_maps"
+	mapTransport      = "transport_maps"
+	mapSenderBcc      = "sender_bcc_maps"
+	mapRecipientBcc   = "recipient_bcc_maps"
+	mapClientAccess   = "client_access"
+	mapPasswdFile     = "passwd-file"
+	mapGroupAccess    = "group_access"
+	// Lines for postconf, restricted groups are the smtpd restriction
+	// classes checked by check_recipient_access of the group_access
+	mapRestrictionClasses = "restriction_classes.cf"
+)
+
+// groupSendersMap returns name of the group allowed senders map
+func groupSendersMap(class string) string {
+	return class + "_senders"
+}
+
+// staticMap collects key value pairs, values of the repeated key
+// are joined with comma. Pairs breaking the map line are skipped
+type staticMap struct {
+	keys    []string
+	values  map[string][]string
+	skipped []string
+}
+
+func newStaticMap() *staticMap {
+	return &staticMap{values: make(map[string][]string)}
+}
+
+func (m *staticMap) add(key, value string) {
+	if mapUnsafe(key, true, "") || mapUnsafe(value, false, "") {
+		m.skipped = append(m.skipped, key)
+		return
+	}
+
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+
+	m.values[key] = append(m.values[key], value)
+}
+
+// first keeps the first value of the key only, Postfix bcc maps
+// accept one address
+func (m *staticMap) first(key, value string) {
+	if _, ok := m.values[key]; !ok {
+		m.add(key, value)
+	}
+}
+
+// mapUnsafe returns true if the string has control characters, which
+// would start a new line, whitespace if space is set or one of the chars
+func mapUnsafe(s string, space bool, chars string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsControl(r) || (space && unicode.IsSpace(r)) || strings.ContainsRune(chars, r)
+	}) >= 0
+}
+
+// bytes returns Postfix map source sorted by key
+func (m *staticMap) bytes() []byte {
+	var b bytes.Buffer
+
+	sort.Strings(m.keys)
+
+	for _, k := range m.keys {
+		fmt.Fprintf(&b, "%s\t%s\n", k, strings.Join(m.values[k], ","))
+	}
+
+	return b.Bytes()
+}
+
+// exportMaps writes static Postfix maps and Dovecot passwd-file to dir.
+// The command is called if any file is changed, the changed hash maps are
+// appended as the arguments and all changed files are in MBMI_CHANGED
+func exportMaps(env Enviroment, dir, command string) (changed []string, err error) {
+	var (
+		files  = make(map[string][]byte)
+		names  []string
+		hashed []string
+	)
+
+	if files, err = buildMaps(env, dir); err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	// Maps of the removed or unrestricted groups
+	stale, _ := filepath.Glob(filepath.Join(dir, groupSendersMap("group_*")+"*"))
+
+	for _, path := range stale {
+		name := strings.TrimSuffix(filepath.Base(path), ".db")
+
+		if _, ok := files[name]; !ok {
+			env.Info("Map %s is removed", path)
+			os.Remove(path)
+		}
+	}
+
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		var (
+			ok   bool
+			mode os.FileMode = 0644
+			path             = filepath.Join(dir, name)
+		)
+
+		// Passwords may be stored in plain text, the owner reads them only
+		if name == mapPasswdFile {
+			mode = 0600
+		}
+
+		if ok, err = writeFileAtomic(path, files[name], mode); err != nil {
+			return
+		}
+
+		if !ok {
+			continue
+		}
+
+		env.Info("Map %s is changed", path)
+		changed = append(changed, path)
+
+		// passwd-file and postconf lines are not Postfix hash maps
+		if name != mapPasswdFile && name != mapRestrictionClasses {
+			hashed = append(hashed, path)
+		}
+	}
+
+	if len(changed) == 0 || command == "" {
+		return
+	}
+
+	cmd := exec.Command("/bin/sh", append([]string{"-c", command + ` "$@"`, "sh"}, hashed...)...)
+	cmd.Env = append(os.Environ(), "MBMI_CHANGED="+strings.Join(changed, " "))
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return changed, fmt.Errorf("Command %s failed: %s: %s", command, err.Error(), strings.TrimSpace(string(out)))
+	}
+
+	return
+}
+
+// buildMaps returns contents of the map files, dir is the maps location
+// referenced by the restriction classes
+func buildMaps(env Enviroment, dir string) (map[string][]byte, error) {
+	var (
+		err        error
+		groups     []*models.Group
+		classes    []string
+		transports = make(map[uint]*models.Transport)
+		policies   = make(map[uint]*models.PasswordPolicy)
+		files      = make(map[string][]byte)
+		rules      bytes.Buffer
+
+		mailbox   = newStaticMap()
+		alias     = newStaticMap()
+		transport = newStaticMap()
+		sender    = newStaticMap()
+		recipient = newStaticMap()
+		access    = newStaticMap()
+		passwd    = newStaticMap()
+		group     = newStaticMap()
+	)
+
+	if err = env.TransportsEach(nil, func(t *models.Transport) error {
+		transports[uint(t.Id)] = t
+		transport.add(t.Domain, t.Transport)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// Policies are read before the users, so queries are not nested
+	for id := range transports {
+		if policies[id], err = passwordPolicy(env, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = env.UsersEach(nil, func(u *models.User) error {
+		t, ok := transports[u.Domain]
+		if !ok {
+			return nil
+		}
+
+		p := policies[u.Domain]
+
+		mailbox.add(string(u.Email), mailboxPath(nil, u.Login, u.DomainName)+"/")
+
+		if line, err := passwdLine(u, t, p); err != nil {
+			env.Error("Map %s: %s is skipped: %s", mapPasswdFile, u.Email, err.Error())
+		} else {
+			passwd.add(string(u.Email), line)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// Temporary aliases are exported within the validity period
+	if err = env.AliasesEach(models.NewFilter().Where("active", nil), func(a *models.Alias) error {
+		alias.add(string(a.Alias), string(a.Recipient))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err = env.BccsEach(nil, func(b *models.BccItem) error {
+		if b.Sender != "" {
+			sender.first(string(b.Sender), string(b.Copy))
+		}
+
+		if b.Recipient != "" {
+			recipient.first(string(b.Recipient), string(b.Copy))
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err = env.AccessesEach(nil, func(a *models.Access) error {
+		access.first(a.Client, a.Access)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if groups, _, err = env.Groups(models.NewFilter().Where("restricted", nil).Order("id", true), false); err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		var (
+			senders = newStaticMap()
+			class   = fmt.Sprintf("group_%d", g.Id)
+			list    = g.Senders
+		)
+
+		// Members are the recipients of the group alias
+		if g.SendPolicy == models.GroupSendMembers {
+			list = alias.values[string(g.Address)]
+		}
+
+		for _, s := range list {
+			senders.first(s, "OK")
+		}
+
+		group.add(string(g.Address), class)
+		classes = append(classes, class)
+		files[groupSendersMap(class)] = senders.bytes()
+		logSkipped(env, groupSendersMap(class), senders)
+
+		fmt.Fprintf(&rules, "%s = check_sender_access hash:%s, reject\n",
+			class, filepath.Join(dir, groupSendersMap(class)))
+	}
+
+	// passwd-file lines are complete, the key is for the sorting only
+	var lines bytes.Buffer
+
+	sort.Strings(passwd.keys)
+
+	for _, k := range passwd.keys {
+		lines.WriteString(passwd.values[k][0] + "\n")
+	}
+
+	for name, m := range map[string]*staticMap{
+		mapVirtualMailbox: mailbox,
+		mapVirtualAlias:   alias,
+		mapTransport:      transport,
+		mapSenderBcc:      sender,
+		mapRecipientBcc:   recipient,
+		mapClientAccess:   access,
+		mapGroupAccess:    group,
+	} {
+		files[name] = m.bytes()
+		logSkipped(env, name, m)
+	}
+
+	logSkipped(env, mapPasswdFile, passwd)
+
+	files[mapPasswdFile] = lines.Bytes()
+	files[mapRestrictionClasses] = append(
+		[]byte("smtpd_restriction_classes = "+strings.Join(classes, ", ")+"\n"),
+		rules.Bytes()...)
+
+	return files, nil
+}
+
+// logSkipped reports keys of the map which are not written
+func logSkipped(env Enviroment, name string, m *staticMap) {
+	for _, k := range m.skipped {
+		env.Error("Map %s: %q is skipped, it has whitespace or control characters", name, k)
+	}
+}
+
+// passwdLine returns Dovecot passwd-file line with the passdb and userdb
+// fields. Login is denied if the password is expired or both IMAP and
+// POP3 are disabled. Fields with the separator are not allowed
+func passwdLine(u *models.User, t *models.Transport, p *models.PasswordPolicy) (string, error) {
+	var (
+		user  = userdbFields(u, t)
+		pass  = passdbFields(u, p)
+		extra []string
+	)
+
+	if pass["nologin"] != "" || (!u.Imap && !u.Pop3) {
+		extra = append(extra, "nologin=y")
+	}
+
+	if v := user["quota_rule"]; v != "" {
+		extra = append(extra, "userdb_quota_rule="+v)
+	}
+
+	fields := []string{
+		string(u.Email),
+		u.Password,
+		user["uid"],
+		user["gid"],
+		"",
+		user["home"],
+		"",
+	}
+
+	for _, f := range fields {
+		if mapUnsafe(f, true, ":") {
+			return "", errors.New("field has whitespace, colon or control characters")
+		}
+	}
+
+	// Extra fields are the rest of the line, quota rule has colon
+	if mapUnsafe(strings.Join(extra, " "), false, "") {
+		return "", errors.New("extra field has control characters")
+	}
+
+	return strings.Join(append(fields, strings.Join(extra, " ")), ":"), nil
+}
+
+// writeFileAtomic replaces the file with the temporary one if the
+// content differs, true is returned if the file is changed
+func writeFileAtomic(path string, data []byte, mode os.FileMode) (bool, error) {
+	var (
+		err error
+		tmp *os.File
+		dir = filepath.Dir(path)
+	)
+
+	if old, err := ioutil.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return false, nil
+	}
+
+	if tmp, err = ioutil.TempFile(dir, "."+filepath.Base(path)); err != nil {
+		return false, err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return false, err
+	}
+
+	if err = os.Chmod(tmp.Name(), mode); err != nil {
+		return false, err
+	}
+
+	return true, os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_ExportMaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbmi-maps")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(passwd string) {
+		mock.ExpectQuery("^SELECT.+transport").WillReturnRows(
+			sqlmock.NewRows([]string{"id", "domain", "transport", "rootdir", "uid", "gid"}).
+				AddRow(1, "user.net", "lmtp:unix:private/dovecot-lmtp", "/var/mail", 8, 12))
+		mock.ExpectQuery("^SELECT.+password_policy").WillReturnRows(sqlmock.NewRows([]string{}))
+		mock.ExpectQuery("^SELECT.+users").WillReturnRows(
+			sqlmock.NewRows(userMockColumns).
+				AddRow(1, "Any User", "some", 1, passwd, 0, 0, true, true, false, false, false, "user.net", "", "", nil, false, nil).
+				AddRow(2, "Off User", "off", 1, "{PLAIN}y", 0, 0, false, false, false, false, false, "user.net", "", "", nil, false, nil).
+				AddRow(3, "Bad User", "bad", 1, "{PLAIN}Secret1x\nboss@user.net:pw:8:12::/var/mail/user.net/boss::", 0, 0, true, true, false, false, false, "user.net", "", "", nil, false, nil))
+		mock.ExpectQuery("^SELECT.+aliases").WillReturnRows(
+			sqlmock.NewRows([]string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}).
+				AddRow(1, "info@user.net", "some@user.net", "", nil, nil).
+				AddRow(2, "info@user.net", "off@user.net", "", nil, nil).
+				AddRow(3, "info@user.net", "x@user.net\nroot@user.net\tevil@other.net", "", nil, nil))
+		mock.ExpectQuery("^SELECT.+bcc").WillReturnRows(
+			sqlmock.NewRows([]string{"id", "sender", "recipient", "copy", "comment"}).
+				AddRow(1, "some@user.net", "", "archive@user.net", ""))
+		mock.ExpectQuery("^SELECT.+access").WillReturnRows(
+			sqlmock.NewRows([]string{"client", "access"}).
+				AddRow("10.0.0.1", "REJECT"))
+		mock.ExpectQuery("^SELECT.+alias_groups.+WHERE.+send_policy").WillReturnRows(
+			sqlmock.NewRows([]string{"id", "address", "description", "send_policy", "senders", "members"}).
+				AddRow(1, "info@user.net", "", "members", "", 2))
+	}
+
+	expect("{PLAIN}x")
+
+	command := "echo >> " + filepath.Join(dir, "command.log")
+
+	changed, err := exportMaps(env, filepath.Join(dir, "maps"), command)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changed) != 10 {
+		t.Errorf("Expected 10 changed maps, got %v", changed)
+	}
+
+	for name, want := range map[string]string{
+		mapVirtualMailbox: "bad@user.net\tuser.net/bad/\noff@user.net\tuser.net/off/\nsome@user.net\tuser.net/some/\n",
+		mapVirtualAlias:   "info@user.net\tsome@user.net,off@user.net\n",
+		mapTransport:      "user.net\tlmtp:unix:private/dovecot-lmtp\n",
+		mapSenderBcc:      "some@user.net\tarchive@user.net\n",
+		mapRecipientBcc:   "",
+		mapClientAccess:   "10.0.0.1\tREJECT\n",
+		mapPasswdFile: "off@user.net:{PLAIN}y:8:12::/var/mail/user.net/off::nologin=y\n" +
+			"some@user.net:{PLAIN}x:8:12::/var/mail/user.net/some::\n",
+		mapGroupAccess:    "info@user.net\tgroup_1\n",
+		"group_1_senders": "off@user.net\tOK\nsome@user.net\tOK\n",
+		mapRestrictionClasses: "smtpd_restriction_classes = group_1\n" +
+			"group_1 = check_sender_access hash:" + filepath.Join(dir, "maps", "group_1_senders") + ", reject\n",
+	} {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "maps", name))
+		if string(data) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, data)
+		}
+	}
+
+	// The same content is not written and the command is not called
+	expect("{PLAIN}x")
+
+	if changed, err = exportMaps(env, filepath.Join(dir, "maps"), command); err != nil || len(changed) != 0 {
+		t.Errorf("Expected no changes, got %v (%v)", changed, err)
+	}
+
+	// Password change touches passwd-file only, the command is still called
+	expect("{PLAIN}z")
+
+	if changed, err = exportMaps(env, filepath.Join(dir, "maps"), command); err != nil || len(changed) != 1 {
+		t.Errorf("Expected passwd-file change, got %v (%v)", changed, err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(dir, "maps", mapPasswdFile)); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Unexpected passwd-file mode: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "command.log"))
+	if lines := strings.Split(string(data), "\n"); len(lines) != 3 || lines[1] != "" ||
+		len(strings.Fields(lines[0])) != 8 || strings.Contains(lines[0], mapPasswdFile) || strings.Contains(lines[0], mapRestrictionClasses) {
+		t.Errorf("Command is expected twice with the changed hash maps, got %q", data)
+	}
+}
@@ -13,6 +13,49 @@ type Access struct {
 
 // Accesses returns the the list of the rejected or granted addresses or IPs
 func (s *DB) Accesses(flt FilterIface, cnt bool) (m []*Access, count uint64, err error) {
+	var (
+		query    *Query
+		queryStr string
+		args     []interface{}
+	)
+
+	if flt == nil {
+		flt = NewFilter()
+	}
+
+	query = flt.(*Query)
+	// Create empty slice
+	m = make([]*Access, 0)
+
+	if err = s.AccessesEach(flt, func(i *Access) error {
+		m = append(m, i)
+		return nil
+	}); err != nil {
+		return nil, 0, err
+	}
+
+	if cnt {
+		query.raw = "SELECT COUNT(*) `client_access`"
+
+		query.Un("LIMIT")
+
+		if queryStr, args, err = query.Compile(); err != nil {
+			return
+		}
+
+		err = s.QueryRow(queryStr, args...).Scan(&count)
+
+		if err != nil && err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// AccessesEach calls fn for each access item of the filtered list without
+// buffering all rows, iteration is stopped by the fn error
+func (s *DB) AccessesEach(flt FilterIface, fn func(*Access) error) (err error) {
 	var (
 		query    *Query
 		queryStr string
@@ -46,8 +89,6 @@ func (s *DB) Accesses(flt FilterIface, cnt bool) (m []*Access, count uint64, err
 	}
 
 	defer rows.Close()
-	// Create empty slice
-	m = make([]*Access, 0)
 
 	for rows.Next() {
 		var i = &Access{}
@@ -58,33 +99,15 @@ func (s *DB) Accesses(flt FilterIface, cnt bool) (m []*Access, count uint64, err
 		)
 
 		if err != nil {
-			return nil, 0, err
-		}
-
-		m = append(m, i)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, 0, err
-	}
-
-	if cnt {
-		query.raw = "SELECT COUNT(*) `client_access`"
-
-		query.Un("LIMIT")
-
-		if queryStr, args, err = query.Compile(); err != nil {
 			return
 		}
 
-		err = s.QueryRow(queryStr, args...).Scan(&count)
-
-		if err != nil && err == sql.ErrNoRows {
-			err = nil
+		if err = fn(i); err != nil {
+			return
 		}
 	}
 
-	return
+	return rows.Err()
 }
 
 func accessWhere(arg *NamedArg) (string, error) {
@@ -92,6 +115,9 @@ func accessWhere(arg *NamedArg) (string, error) {
 	case "search":
 		return "`client` LIKE ?", nil
 
+	case "client":
+		return "`client` = ?", nil
+
 	case "access":
 		return "`access` = ?", nil
 	}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"time"
 )
 
 type Alias struct {
@@ -9,9 +10,56 @@ type Alias struct {
 	Alias     Email  `json:"alias" schema: "alias"`
 	Recipient Email  `json:"recipient" schema:"recipient"`
 	Comment   string `json:"comment" schema:"comment"`
+	// Optional validity period of the temporary alias
+	ValidFrom  *time.Time `json:"valid_from" schema:"valid_from"`
+	ValidUntil *time.Time `json:"valid_until" schema:"valid_until"`
 }
 
 func (s *DB) Aliases(flt FilterIface, cnt bool) (m []*Alias, count uint64, err error) {
+	var (
+		query     *Query
+		query_str string
+		args      []interface{}
+	)
+
+	if flt == nil {
+		flt = NewFilter()
+	}
+
+	query = flt.(*Query)
+	// Create empty slice
+	m = make([]*Alias, 0)
+
+	if err = s.AliasesEach(flt, func(i *Alias) error {
+		m = append(m, i)
+		return nil
+	}); err != nil {
+		return nil, 0, err
+	}
+
+	if cnt && len(m) > 0 {
+		query.raw = "SELECT COUNT(*) " +
+			"FROM `aliases` AS `a` "
+
+		query.Un("LIMIT")
+
+		if query_str, args, err = query.Compile(); err != nil {
+			return
+		}
+
+		err = s.QueryRow(query_str, args...).Scan(&count)
+
+		if err != nil && err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// AliasesEach calls fn for each alias of the filtered list without
+// buffering all rows, iteration is stopped by the fn error
+func (s *DB) AliasesEach(flt FilterIface, fn func(*Alias) error) (err error) {
 	var (
 		query     *Query
 		query_str string
@@ -24,6 +72,7 @@ func (s *DB) Aliases(flt FilterIface, cnt bool) (m []*Alias, count uint64, err e
 	}
 
 	query = flt.(*Query)
+	query.alive()
 
 	for _, expr := range query.expressions {
 		switch expr.name {
@@ -41,6 +90,8 @@ func (s *DB) Aliases(flt FilterIface, cnt bool) (m []*Alias, count uint64, err e
 		", `a`.`alias` `alias`" +
 		", `a`.`recipient` `recipient`" +
 		", `a`.`comment` `comment`" +
+		", `a`.`valid_from` `valid_from`" +
+		", `a`.`valid_until` `valid_until`" +
 		" " +
 		"FROM `aliases` AS `a` "
 
@@ -50,12 +101,10 @@ func (s *DB) Aliases(flt FilterIface, cnt bool) (m []*Alias, count uint64, err e
 	}
 
 	if rows, err = s.Query(query_str, args...); err != nil {
-		return nil, 0, err
+		return
 	}
 
 	defer rows.Close()
-	// Create empty slice
-	m = make([]*Alias, 0)
 
 	for rows.Next() {
 		var i = &Alias{}
@@ -65,68 +114,84 @@ func (s *DB) Aliases(flt FilterIface, cnt bool) (m []*Alias, count uint64, err e
 			&i.Alias,
 			&i.Recipient,
 			&i.Comment,
+			&i.ValidFrom,
+			&i.ValidUntil,
 		)
 
 		if err != nil {
-			return nil, 0, err
-		}
-
-		m = append(m, i)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, 0, err
-	}
-
-	if cnt && len(m) > 0 {
-		query.raw = "SELECT COUNT(*) " +
-			"FROM `aliases` AS `a` "
-
-		query.Un("LIMIT")
-
-		if query_str, args, err = query.Compile(); err != nil {
 			return
 		}
 
-		err = s.QueryRow(query_str, args...).Scan(&count)
-
-		if err != nil && err == sql.ErrNoRows {
-			err = nil
+		if err = fn(i); err != nil {
+			return
 		}
 	}
 
-	return
+	return rows.Err()
 }
 
 func (s *DB) SetAlias(alias *Alias) (err error) {
 	if alias.Id > 0 {
 		_, err = s.Exec("UPDATE `aliases` SET "+
-			"`alias` = ?, `recipient` = ?, `comment` = ? "+
+			"`alias` = ?, `recipient` = ?, `comment` = ?, `valid_from` = ?, `valid_until` = ? "+
 			"WHERE id = ?",
 			alias.Alias,
 			alias.Recipient,
 			alias.Comment,
+			alias.ValidFrom,
+			alias.ValidUntil,
 			alias.Id)
 	} else {
-		_, err = s.Exec("INSERT INTO `aliases` ("+
-			"`alias`, `recipient`, `comment`"+
-			") VALUES (?, ?, ?)",
+		var result sql.Result
+
+		result, err = s.Exec("INSERT INTO `aliases` ("+
+			"`alias`, `recipient`, `comment`, `valid_from`, `valid_until`"+
+			") VALUES (?, ?, ?, ?, ?)",
 			alias.Alias,
 			alias.Recipient,
-			alias.Comment)
+			alias.Comment,
+			alias.ValidFrom,
+			alias.ValidUntil)
+
+		if err != nil {
+			return
+		}
+
+		alias.Id, err = result.LastInsertId()
 	}
 
 	return
 }
 
+// DelAlias moves the alias to the trash, it is hidden from the lists
+// until restored or purged
 func (s *DB) DelAlias(id int64) (err error) {
-	_, err = s.Exec("DELETE FROM `aliases` WHERE `id` = ?", id)
+	_, err = s.Exec("UPDATE `aliases` SET `deleted_at` = NOW() WHERE `id` = ? AND `deleted_at` IS NULL", id)
 
 	return
 }
 
+// ExpireAliases moves aliases with the passed valid_until to the trash,
+// number of the expired aliases is returned
+func (s *DB) ExpireAliases() (n int64, err error) {
+	var res sql.Result
+
+	if res, err = s.Exec("UPDATE `aliases` SET `deleted_at` = NOW() " +
+		"WHERE `valid_until` <= NOW() AND `deleted_at` IS NULL"); err != nil {
+		return
+	}
+
+	return res.RowsAffected()
+}
+
 func aliasWhere(arg *NamedArg) (string, error) {
 	switch arg.Name {
+	case "alive":
+		return "`a`.`deleted_at` IS NULL", nil
+
+	case "deleted":
+		return "`a`.`deleted_at` IS NOT NULL", nil
+
 	case "id":
 		return "`a`.`id` = ?", nil
 
@@ -135,6 +200,18 @@ func aliasWhere(arg *NamedArg) (string, error) {
 
 	case "recipient":
 		return "`a`.`recipient` LIKE ?", nil
+
+	// Alias is delivered now
+	case "active":
+		return "(`a`.`valid_from` IS NULL OR `a`.`valid_from` <= NOW()) " +
+			"AND (`a`.`valid_until` IS NULL OR `a`.`valid_until` > NOW())", nil
+
+	case "unexpired":
+		return "(`a`.`valid_until` IS NULL OR `a`.`valid_until` > NOW())", nil
+
+	// Alias expires before the given time
+	case "expiring":
+		return "`a`.`valid_until` > NOW() AND `a`.`valid_until` <= ?", nil
 	}
 
 	return "", ErrFilterArgument
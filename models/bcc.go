@@ -13,6 +13,51 @@ type BccItem struct {
 }
 
 func (s *DB) Bccs(flt FilterIface, cnt bool) (m []*BccItem, count uint64, err error) {
+	var (
+		query     *Query
+		query_str string
+		args      []interface{}
+	)
+
+	if flt == nil {
+		flt = NewFilter()
+	}
+
+	query = flt.(*Query)
+	// Create empty slice
+	m = make([]*BccItem, 0)
+
+	if err = s.BccsEach(flt, func(i *BccItem) error {
+		m = append(m, i)
+		return nil
+	}); err != nil {
+		return nil, 0, err
+	}
+
+	if cnt && len(m) > 0 {
+		query.raw = "SELECT COUNT(*) " +
+			"FROM `bcc` AS `b` "
+
+		query.Un("ORDER BY")
+		query.Un("LIMIT")
+
+		if query_str, args, err = query.Compile(); err != nil {
+			return
+		}
+
+		err = s.QueryRow(query_str, args...).Scan(&count)
+
+		if err != nil && err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// BccsEach calls fn for each bcc item of the filtered list without
+// buffering all rows, iteration is stopped by the fn error
+func (s *DB) BccsEach(flt FilterIface, fn func(*BccItem) error) (err error) {
 	var (
 		query     *Query
 		query_str string
@@ -25,6 +70,7 @@ func (s *DB) Bccs(flt FilterIface, cnt bool) (m []*BccItem, count uint64, err er
 	}
 
 	query = flt.(*Query)
+	query.alive()
 
 	for _, expr := range query.expressions {
 		switch expr.name {
@@ -52,12 +98,10 @@ func (s *DB) Bccs(flt FilterIface, cnt bool) (m []*BccItem, count uint64, err er
 	}
 
 	if rows, err = s.Query(query_str, args...); err != nil {
-		return nil, 0, err
+		return
 	}
 
 	defer rows.Close()
-	// Create empty slice
-	m = make([]*BccItem, 0)
 
 	for rows.Next() {
 		var i = &BccItem{}
@@ -71,35 +115,15 @@ func (s *DB) Bccs(flt FilterIface, cnt bool) (m []*BccItem, count uint64, err er
 		)
 
 		if err != nil {
-			return nil, 0, err
-		}
-
-		m = append(m, i)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, 0, err
-	}
-
-	if cnt && len(m) > 0 {
-		query.raw = "SELECT COUNT(*) " +
-			"FROM `bcc` AS `b` "
-
-		query.Un("ORDER BY")
-		query.Un("LIMIT")
-
-		if query_str, args, err = query.Compile(); err != nil {
 			return
 		}
 
-		err = s.QueryRow(query_str, args...).Scan(&count)
-
-		if err != nil && err == sql.ErrNoRows {
-			err = nil
+		if err = fn(i); err != nil {
+			return
 		}
 	}
 
-	return
+	return rows.Err()
 }
 
 func (s *DB) SetBcc(b *BccItem) (err error) {
@@ -125,14 +149,22 @@ func (s *DB) SetBcc(b *BccItem) (err error) {
 	return
 }
 
+// DelBcc moves the bcc rule to the trash, it is hidden from the lists
+// until restored or purged
 func (s *DB) DelBcc(id int64) (err error) {
-	_, err = s.Exec("DELETE FROM `bcc` WHERE `id` = ?", id)
+	_, err = s.Exec("UPDATE `bcc` SET `deleted_at` = NOW() WHERE `id` = ? AND `deleted_at` IS NULL", id)
 
 	return
 }
 
 func bccWhere(arg *NamedArg) (string, error) {
 	switch arg.Name {
+	case "alive":
+		return "`b`.`deleted_at` IS NULL", nil
+
+	case "deleted":
+		return "`b`.`deleted_at` IS NOT NULL", nil
+
 	case "id":
 		return "`b`.`id` = ?", nil
 
@@ -145,6 +177,12 @@ func bccWhere(arg *NamedArg) (string, error) {
 	case "copy":
 		return "`b`.`copy` LIKE ?", nil
 
+	case "sender_is":
+		return "`b`.`sender` = ?", nil
+
+	case "recipient_is":
+		return "`b`.`recipient` = ?", nil
+
 	case "search":
 		if arg.Value != nil && len(arg.Value) == 1 {
 			arg.Fill(arg.Value[0], 3)
@@ -13,21 +13,50 @@ var (
 
 type Datastore interface {
 	Aliases(FilterIface, bool) ([]*Alias, uint64, error)
+	AliasesEach(FilterIface, func(*Alias) error) error
 	SetAlias(*Alias) error
 	DelAlias(int64) error
+	ExpireAliases() (int64, error)
 	Users(FilterIface, bool) ([]*User, uint64, error)
+	UsersEach(FilterIface, func(*User) error) error
 	Spam(FilterIface, bool) ([]*Spam, uint64, error)
 	Transports(FilterIface, bool) ([]*Transport, uint64, error)
+	TransportsEach(FilterIface, func(*Transport) error) error
 	MailSearch(FilterIface, bool) ([]string, uint64, error)
 	SetUser(*User) error
 	DelUser(int64) error
 	SetUserSecret(*User) error
+	SetUserPassword(*User) error
+	RenameUser(*Rename) error
 	SetStatImapLogin(*Stat) error
 	ServicesStat(FilterIface, bool) ([]*Stat, uint64, error)
 	Accesses(FilterIface, bool) ([]*Access, uint64, error)
+	AccessesEach(FilterIface, func(*Access) error) error
 	Bccs(FilterIface, bool) ([]*BccItem, uint64, error)
+	BccsEach(FilterIface, func(*BccItem) error) error
 	SetBcc(*BccItem) error
 	DelBcc(int64) error
+	PasswordPolicies(FilterIface, bool) ([]*PasswordPolicy, uint64, error)
+	SetPasswordPolicy(*PasswordPolicy) error
+	DelPasswordPolicy(uint) error
+	PasswordHistory(int64, int) ([]string, error)
+	SetPasswordHistory(int64, string) error
+	Vacations(FilterIface, bool) ([]*Vacation, uint64, error)
+	SetVacation(*Vacation) error
+	SieveRules(int64) ([]*SieveRule, error)
+	SieveRuleUsers() ([]int64, error)
+	SetSieveRules(int64, []*SieveRule) error
+	Transaction(func(Datastore) error) error
+	Suspensions(FilterIface, bool) ([]*Suspension, uint64, error)
+	SuspendUser(*Suspension, bool) error
+	ResumeUser(int64) error
+	Trash(FilterIface, bool) ([]*Trash, uint64, error)
+	RestoreTrash(string, int64) error
+	PurgeTrash(string, int64) error
+	Groups(FilterIface, bool) ([]*Group, uint64, error)
+	SetGroup(*Group) error
+	DelGroup(int64) error
+	SetGroupMembers(int64, string, []Email) error
 }
 
 type Debug func(v ...interface{})
@@ -35,6 +64,9 @@ type Debug func(v ...interface{})
 type DB struct {
 	*sql.DB
 	Debug
+
+	// Queries are executed in the transaction if it is set
+	tx *sql.Tx
 }
 
 func Init(driver *sql.DB, fn Debug) Datastore {
@@ -57,6 +89,10 @@ func (s *DB) Query(q string, args ...interface{}) (*sql.Rows, error) {
 	s.Debug("%v", args)
 
 	// Execute query
+	if s.tx != nil {
+		return s.tx.Query(q, args...)
+	}
+
 	return s.DB.Query(q, args...)
 }
 
@@ -68,6 +104,10 @@ func (s *DB) QueryRow(q string, args ...interface{}) *sql.Row {
 	s.Debug("%v", args)
 
 	// Execute query
+	if s.tx != nil {
+		return s.tx.QueryRow(q, args...)
+	}
+
 	return s.DB.QueryRow(q, args...)
 }
 
@@ -79,5 +119,47 @@ func (s *DB) Exec(q string, args ...interface{}) (sql.Result, error) {
 	s.Debug("%v", args)
 
 	// Execute query
+	if s.tx != nil {
+		return s.tx.Exec(q, args...)
+	}
+
 	return s.DB.Exec(q, args...)
 }
+
+// Transaction calls fn with the datastore bound to the new transaction,
+// it is committed if fn returns nil and rolled back otherwise. Nested
+// call joins the current transaction
+func (s *DB) Transaction(fn func(Datastore) error) error {
+	return s.transaction(func(d *DB) error {
+		return fn(d)
+	})
+}
+
+func (s *DB) transaction(fn func(*DB) error) (err error) {
+	var tx *sql.Tx
+
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	s.Debug("BEGIN")
+
+	if tx, err = s.DB.Begin(); err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			s.Debug("ROLLBACK")
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(&DB{DB: s.DB, Debug: s.Debug, tx: tx}); err != nil {
+		return
+	}
+
+	s.Debug("COMMIT")
+
+	return tx.Commit()
+}
@@ -199,6 +199,26 @@ func (s *Query) Where(name string, v interface{}) FilterIface {
 	return s
 }
 
+// has returns true if the expression contains the named argument
+func (s *Query) has(expr, name string) bool {
+	if _, e := s.Expression(expr); e != nil {
+		for _, i := range e.args {
+			if i.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// alive hides soft deleted rows unless the filter asks for the deleted ones
+func (s *Query) alive() {
+	if !s.has("WHERE", "alive") && !s.has("WHERE", "deleted") {
+		s.Where("alive", nil)
+	}
+}
+
 func (s *expression) set(name string, v ...interface{}) {
 	if name != "" {
 		var arg = NamedArg{
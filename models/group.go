@@ -0,0 +1,321 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Group send policies
+const (
+	GroupSendAnyone  = "anyone"
+	GroupSendMembers = "members"
+	GroupSendSenders = "senders"
+)
+
+// Group member operations
+const (
+	GroupMembersAdd     = "add"
+	GroupMembersRemove  = "remove"
+	GroupMembersReplace = "replace"
+)
+
+// Group is the distribution list. Members are the aliases rows of the
+// group address, so Postfix expands the group as any other alias. Send
+// policy restricts who may send to the group
+type Group struct {
+	Id          int64    `json:"id" schema:"id"`
+	Address     Email    `json:"address" schema:"address"`
+	Description string   `json:"description" schema:"description"`
+	SendPolicy  string   `json:"send_policy" schema:"send_policy"`
+	Senders     []string `json:"senders" schema:"senders"`
+	MemberCount uint64   `json:"member_count" schema:"-"`
+	Members     []Email  `json:"members,omitempty" schema:"-"`
+}
+
+// Groups returns list of the groups with the member count
+func (s *DB) Groups(flt FilterIface, cnt bool) (m []*Group, count uint64, err error) {
+	var (
+		query    *Query
+		queryStr string
+		args     []interface{}
+		rows     *sql.Rows
+	)
+
+	if flt == nil {
+		flt = NewFilter()
+	}
+
+	query = flt.(*Query)
+
+	for _, expr := range query.expressions {
+		switch expr.name {
+		case "WHERE":
+			expr.CbFunc(groupWhere)
+		case "ORDER BY":
+			expr.CbFunc(groupOrder)
+		}
+	}
+
+	// Base query
+	query.raw = "SELECT `g`.`id` `id`" +
+		", `g`.`address` `address`" +
+		", `g`.`description` `description`" +
+		", `g`.`send_policy` `send_policy`" +
+		", `g`.`senders` `senders`" +
+		", (SELECT COUNT(*) FROM `aliases` AS `m` " +
+		"WHERE `m`.`alias` = `g`.`address` AND `m`.`deleted_at` IS NULL) `members`" +
+		" " +
+		"FROM `alias_groups` AS `g` "
+
+	if queryStr, args, err = query.Compile(); err != nil {
+		return
+	}
+
+	if rows, err = s.Query(queryStr, args...); err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+	// Create empty slice
+	m = make([]*Group, 0)
+
+	for rows.Next() {
+		var (
+			senders string
+			i       = &Group{}
+		)
+
+		err = rows.Scan(
+			&i.Id,
+			&i.Address,
+			&i.Description,
+			&i.SendPolicy,
+			&senders,
+			&i.MemberCount,
+		)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		i.Senders = splitLines(senders)
+
+		m = append(m, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if cnt {
+		query.raw = "SELECT COUNT(*) " +
+			"FROM `alias_groups` AS `g` "
+
+		query.Un("LIMIT")
+		query.Un("ORDER BY")
+
+		if queryStr, args, err = query.Compile(); err != nil {
+			return
+		}
+
+		err = s.QueryRow(queryStr, args...).Scan(&count)
+
+		if err != nil && err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// SetGroup creates or updates the group, members follow the changed
+// group address
+func (s *DB) SetGroup(g *Group) error {
+	return s.transaction(func(d *DB) (err error) {
+		var (
+			old    Email
+			result sql.Result
+		)
+
+		if g.Id == 0 {
+			if result, err = d.Exec("INSERT INTO `alias_groups` ("+
+				"`address`, `description`, `send_policy`, `senders`"+
+				") VALUES (?, ?, ?, ?)",
+				g.Address,
+				g.Description,
+				g.SendPolicy,
+				strings.Join(g.Senders, "\n")); err != nil {
+				return
+			}
+
+			g.Id, err = result.LastInsertId()
+
+			return
+		}
+
+		if old, err = d.groupAddress(g.Id); err != nil {
+			return
+		}
+
+		if _, err = d.Exec("UPDATE `alias_groups` SET "+
+			"`address` = ?, `description` = ?, `send_policy` = ?, `senders` = ? "+
+			"WHERE `id` = ?",
+			g.Address,
+			g.Description,
+			g.SendPolicy,
+			strings.Join(g.Senders, "\n"),
+			g.Id); err != nil {
+			return
+		}
+
+		if old != g.Address {
+			_, err = d.Exec("UPDATE `aliases` SET `alias` = ? "+
+				"WHERE `alias` = ? AND `deleted_at` IS NULL", g.Address, old)
+		}
+
+		return
+	})
+}
+
+// DelGroup removes the group and moves its members to the trash
+func (s *DB) DelGroup(id int64) error {
+	return s.transaction(func(d *DB) (err error) {
+		var address Email
+
+		if address, err = d.groupAddress(id); err != nil {
+			return
+		}
+
+		if _, err = d.Exec("UPDATE `aliases` SET `deleted_at` = NOW() "+
+			"WHERE `alias` = ? AND `deleted_at` IS NULL", address); err != nil {
+			return
+		}
+
+		_, err = d.Exec("DELETE FROM `alias_groups` WHERE `id` = ?", id)
+
+		return
+	})
+}
+
+// SetGroupMembers adds, removes or replaces the group members in one
+// transaction. Addresses are compared case insensitive, existing
+// members are not duplicated
+func (s *DB) SetGroupMembers(id int64, op string, list []Email) error {
+	return s.transaction(func(d *DB) (err error) {
+		var (
+			address Email
+			rows    *sql.Rows
+			order   []string
+			want    = make(map[string]bool)
+			current = make(map[string]int64)
+		)
+
+		if address, err = d.groupAddress(id); err != nil {
+			return
+		}
+
+		for _, m := range list {
+			want[strings.ToLower(string(m))] = true
+		}
+
+		if rows, err = d.Query("SELECT `id`, `recipient` FROM `aliases` "+
+			"WHERE `alias` = ? AND `deleted_at` IS NULL FOR UPDATE", address); err != nil {
+			return
+		}
+
+		for rows.Next() {
+			var (
+				aid       int64
+				recipient string
+			)
+
+			if err = rows.Scan(&aid, &recipient); err != nil {
+				rows.Close()
+				return
+			}
+
+			current[strings.ToLower(recipient)] = aid
+			order = append(order, strings.ToLower(recipient))
+		}
+
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return
+		}
+
+		// Removed members
+		for _, m := range order {
+			if (op == GroupMembersRemove && want[m]) || (op == GroupMembersReplace && !want[m]) {
+				if err = d.DelAlias(current[m]); err != nil {
+					return
+				}
+			}
+		}
+
+		if op == GroupMembersRemove {
+			return
+		}
+
+		// Added members in the given order
+		for _, m := range list {
+			if _, ok := current[strings.ToLower(string(m))]; ok {
+				continue
+			}
+
+			current[strings.ToLower(string(m))] = 0
+
+			if err = d.SetAlias(&Alias{Alias: address, Recipient: m}); err != nil {
+				return
+			}
+		}
+
+		return
+	})
+}
+
+// groupAddress returns address of the group, sql.ErrNoRows is returned
+// if the group does not exist
+func (s *DB) groupAddress(id int64) (address Email, err error) {
+	err = s.QueryRow("SELECT `address` FROM `alias_groups` WHERE `id` = ?", id).Scan(&address)
+
+	return
+}
+
+func groupWhere(arg *NamedArg) (string, error) {
+	switch arg.Name {
+	case "id":
+		return "`g`.`id` = ?", nil
+
+	case "address":
+		return "`g`.`address` = ?", nil
+
+	case "search":
+		if arg.Value != nil && len(arg.Value) == 1 {
+			arg.Fill(arg.Value[0], 2)
+		}
+		return "(`g`.`address` LIKE ? OR `g`.`description` LIKE ?)", nil
+
+	case "restricted":
+		return "`g`.`send_policy` != '" + GroupSendAnyone + "'", nil
+	}
+
+	return "", ErrFilterArgument
+}
+
+func groupOrder(arg *NamedArg) (string, error) {
+	var dir = arg.First().(string)
+
+	switch arg.Name {
+	case "id":
+		return "`g`.`id` " + dir, nil
+
+	case "address":
+		return "`g`.`address` " + dir, nil
+
+	case "members":
+		return "`members` " + dir, nil
+	}
+
+	return "", ErrFilterArgument
+}
@@ -31,10 +31,11 @@ func (s *DB) MailSearch(flt FilterIface, cnt bool) (m []string, count uint64, er
 	query.raw = "SELECT `mail` FROM (" +
 		"SELECT CONCAT(`u`.`login`, '@', `t`.`domain`) AS `mail` " +
 		"FROM `users` AS `u` LEFT JOIN `transport` AS `t` ON (`u`.`domid` = `t`.`id`) " +
+		"WHERE `u`.`deleted_at` IS NULL " +
 		"UNION " +
-		"SELECT `alias` AS `mail` FROM `aliases` " +
+		"SELECT `alias` AS `mail` FROM `aliases` WHERE `deleted_at` IS NULL " +
 		"UNION " +
-		"SELECT `recipient` AS `mail` FROM aliases " +
+		"SELECT `recipient` AS `mail` FROM aliases WHERE `deleted_at` IS NULL " +
 		") as `maillist` "
 
 	// Add where
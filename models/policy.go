@@ -0,0 +1,212 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// PasswordPolicy represents password requirements. Common policy is set
+// by the program flags, rows override it for the domain
+type PasswordPolicy struct {
+	Domain    uint     `json:"domain" schema:"domain"`
+	MinLength int      `json:"min_length" schema:"min_length"`
+	Lower     Boolean  `json:"lower" schema:"lower"`
+	Upper     Boolean  `json:"upper" schema:"upper"`
+	Digits    Boolean  `json:"digits" schema:"digits"`
+	Symbols   Boolean  `json:"symbols" schema:"symbols"`
+	Banned    []string `json:"banned" schema:"banned"`
+	History   int      `json:"history" schema:"history"`
+	MaxAge    int      `json:"max_age" schema:"max_age"`
+}
+
+// PasswordPolicies returns list of the domain password policies
+func (s *DB) PasswordPolicies(flt FilterIface, cnt bool) (m []*PasswordPolicy, count uint64, err error) {
+	var (
+		query    *Query
+		queryStr string
+		args     []interface{}
+		rows     *sql.Rows
+	)
+
+	if flt == nil {
+		flt = NewFilter()
+	}
+
+	query = flt.(*Query)
+
+	for _, expr := range query.expressions {
+		switch expr.name {
+		case "WHERE":
+			expr.CbFunc(policyWhere)
+		case "ORDER BY":
+			expr.CbFunc(policyOrder)
+		}
+	}
+
+	// Base query
+	query.raw = "SELECT `p`.`domid` `domid`" +
+		", `p`.`min_length` `min_length`" +
+		", `p`.`lower` `lower`" +
+		", `p`.`upper` `upper`" +
+		", `p`.`digits` `digits`" +
+		", `p`.`symbols` `symbols`" +
+		", `p`.`banned` `banned`" +
+		", `p`.`history` `history`" +
+		", `p`.`max_age` `max_age`" +
+		" " +
+		"FROM `password_policy` AS `p` "
+
+	if queryStr, args, err = query.Compile(); err != nil {
+		return
+	}
+
+	if rows, err = s.Query(queryStr, args...); err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+	// Create empty slice
+	m = make([]*PasswordPolicy, 0)
+
+	for rows.Next() {
+		var (
+			banned string
+			i      = &PasswordPolicy{}
+		)
+
+		err = rows.Scan(
+			&i.Domain,
+			&i.MinLength,
+			&i.Lower,
+			&i.Upper,
+			&i.Digits,
+			&i.Symbols,
+			&banned,
+			&i.History,
+			&i.MaxAge,
+		)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		i.Banned = splitLines(banned)
+
+		m = append(m, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if cnt {
+		query.raw = "SELECT COUNT(*) " +
+			"FROM `password_policy` AS `p` "
+
+		query.Un("LIMIT")
+		query.Un("ORDER BY")
+
+		if queryStr, args, err = query.Compile(); err != nil {
+			return
+		}
+
+		err = s.QueryRow(queryStr, args...).Scan(&count)
+
+		if err != nil && err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// SetPasswordPolicy creates or replaces domain policy
+func (s *DB) SetPasswordPolicy(p *PasswordPolicy) (err error) {
+	_, err = s.Exec("REPLACE INTO `password_policy` ("+
+		"`domid`, `min_length`, `lower`, `upper`, `digits`, `symbols`, `banned`, `history`, `max_age`"+
+		") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		p.Domain,
+		p.MinLength,
+		p.Lower,
+		p.Upper,
+		p.Digits,
+		p.Symbols,
+		strings.Join(p.Banned, "\n"),
+		p.History,
+		p.MaxAge)
+
+	return
+}
+
+// DelPasswordPolicy removes domain policy
+func (s *DB) DelPasswordPolicy(domain uint) (err error) {
+	_, err = s.Exec("DELETE FROM `password_policy` WHERE `domid` = ?", domain)
+
+	return
+}
+
+// PasswordHistory returns last n password hashes of the user
+func (s *DB) PasswordHistory(uid int64, n int) (m []string, err error) {
+	var rows *sql.Rows
+
+	if rows, err = s.Query("SELECT `hash` FROM `password_history` "+
+		"WHERE `uid` = ? ORDER BY `created` DESC LIMIT ?", uid, n); err != nil {
+		return
+	}
+
+	defer rows.Close()
+	// Create empty slice
+	m = make([]string, 0)
+
+	for rows.Next() {
+		var i string
+
+		if err = rows.Scan(&i); err != nil {
+			return nil, err
+		}
+
+		m = append(m, i)
+	}
+
+	return m, rows.Err()
+}
+
+// SetPasswordHistory saves password hash to the user history
+func (s *DB) SetPasswordHistory(uid int64, hash string) (err error) {
+	_, err = s.Exec("INSERT INTO `password_history` (`uid`, `hash`, `created`) "+
+		"VALUES (?, ?, NOW())", uid, hash)
+
+	return
+}
+
+func splitLines(str string) (list []string) {
+	list = make([]string, 0)
+
+	for _, i := range strings.Split(str, "\n") {
+		if i = strings.TrimSpace(i); i != "" {
+			list = append(list, i)
+		}
+	}
+
+	return
+}
+
+func policyWhere(arg *NamedArg) (string, error) {
+	switch arg.Name {
+	case "domain":
+		return "`p`.`domid` = ?", nil
+	}
+
+	return "", ErrFilterArgument
+}
+
+func policyOrder(arg *NamedArg) (string, error) {
+	var dir = arg.First().(string)
+
+	switch arg.Name {
+	case "domain":
+		return "`p`.`domid` " + dir, nil
+	}
+
+	return "", ErrFilterArgument
+}
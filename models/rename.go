@@ -0,0 +1,146 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Rename describes change of the user address and the summary
+// of the rewritten references
+type Rename struct {
+	UID    int64  `json:"uid"`
+	From   Email  `json:"from"`
+	To     Email  `json:"to"`
+	Login  string `json:"-"`
+	Domain uint   `json:"-"`
+	Uid    uint   `json:"-"`
+	Gid    uint   `json:"-"`
+	// Leave the old address as alias to the new one
+	KeepAlias Boolean `json:"keep_alias"`
+
+	AliasRecipients int64 `json:"alias_recipients"`
+	AliasSources    int64 `json:"alias_sources"`
+	BccSenders      int64 `json:"bcc_senders"`
+	BccRecipients   int64 `json:"bcc_recipients"`
+	BccCopies       int64 `json:"bcc_copies"`
+	GroupSenders    int64 `json:"group_senders"`
+	// Id of the alias left for the old address
+	OldAlias int64 `json:"old_alias,omitempty"`
+	// Maildir locations if it was moved
+	MailboxFrom string `json:"mailbox_from,omitempty"`
+	MailboxTo   string `json:"mailbox_to,omitempty"`
+}
+
+// RenameUser changes user login, domain and ids and rewrites aliases, bcc
+// rows and group senders referencing the old address in one transaction.
+// Group members are aliases to the user. Sender login maps are built from
+// users and aliases, so they follow
+func (s *DB) RenameUser(r *Rename) error {
+	return s.transaction(func(d *DB) (err error) {
+		var res sql.Result
+
+		if _, err = d.Exec("UPDATE `users` SET `login` = ?, `domid` = ?, `uid` = ?, `gid` = ? "+
+			"WHERE `id` = ?", r.Login, r.Domain, r.Uid, r.Gid, r.UID); err != nil {
+			return
+		}
+
+		for _, i := range []struct {
+			query string
+			count *int64
+		}{
+			{"UPDATE `aliases` SET `recipient` = ? WHERE `recipient` = ?", &r.AliasRecipients},
+			{"UPDATE `aliases` SET `alias` = ? WHERE `alias` = ?", &r.AliasSources},
+			{"UPDATE `bcc` SET `sender` = ? WHERE `sender` = ?", &r.BccSenders},
+			{"UPDATE `bcc` SET `recipient` = ? WHERE `recipient` = ?", &r.BccRecipients},
+			{"UPDATE `bcc` SET `copy` = ? WHERE `copy` = ?", &r.BccCopies},
+		} {
+			if res, err = d.Exec(i.query, r.To, r.From); err != nil {
+				return
+			}
+
+			if *i.count, err = res.RowsAffected(); err != nil {
+				return
+			}
+		}
+
+		if r.GroupSenders, err = d.renameGroupSenders(r.From, r.To); err != nil {
+			return
+		}
+
+		if !r.KeepAlias {
+			return
+		}
+
+		var alias = &Alias{
+			Alias:     r.From,
+			Recipient: r.To,
+			Comment:   "Renamed to " + string(r.To),
+		}
+
+		if err = d.SetAlias(alias); err != nil {
+			return
+		}
+
+		r.OldAlias = alias.Id
+
+		return
+	})
+}
+
+// renameGroupSenders replaces the address in the allowed senders lists
+// of the groups, returns the number of the changed groups
+func (s *DB) renameGroupSenders(from, to Email) (count int64, err error) {
+	var (
+		rows    *sql.Rows
+		senders = make(map[int64][]string)
+	)
+
+	if rows, err = s.Query("SELECT `id`, `senders` FROM `alias_groups` "+
+		"WHERE `senders` LIKE ?", "%"+string(from)+"%"); err != nil {
+		return
+	}
+
+	for rows.Next() {
+		var (
+			id   int64
+			list string
+		)
+
+		if err = rows.Scan(&id, &list); err != nil {
+			rows.Close()
+			return
+		}
+
+		senders[id] = splitLines(list)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	for id, list := range senders {
+		var found bool
+
+		for i := range list {
+			if strings.EqualFold(list[i], string(from)) {
+				list[i] = string(to)
+				found = true
+			}
+		}
+
+		if !found {
+			continue
+		}
+
+		if _, err = s.Exec("UPDATE `alias_groups` SET `senders` = ? WHERE `id` = ?",
+			strings.Join(list, "\n"), id); err != nil {
+			return
+		}
+
+		count++
+	}
+
+	return
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// SieveRule represents server side filter rule of the user. Rules are
+// applied in the list order
+type SieveRule struct {
+	Id   int64  `json:"id"`
+	UID  int64  `json:"uid"`
+	Name string `json:"name"`
+	// Condition: from, to, cc, subject, header, envelope_from,
+	// envelope_to or spam
+	Field string `json:"field"`
+	// Header name for the header field
+	Header string `json:"header,omitempty"`
+	// Match type: is, contains or matches
+	Match string `json:"match"`
+	// Value to compare with, spam score for the spam field
+	Value string `json:"value"`
+	// Action: fileinto, redirect, reject, discard or keep
+	Action string `json:"action"`
+	// Folder, address or reject reason of the action
+	Target string `json:"target,omitempty"`
+	// Keep local copy on redirect
+	Copy Boolean `json:"copy"`
+	// Stop processing of the next rules
+	Stop Boolean `json:"stop"`
+}
+
+// SieveRules returns user rules in the order of application
+func (s *DB) SieveRules(uid int64) (m []*SieveRule, err error) {
+	var rows *sql.Rows
+
+	if rows, err = s.Query("SELECT `id`, `uid`, `name`, `field`, `header`, `match`, "+
+		"`value`, `action`, `target`, `copy`, `stop` "+
+		"FROM `sieve_rule` WHERE `uid` = ? ORDER BY `position`", uid); err != nil {
+		return
+	}
+
+	defer rows.Close()
+	// Create empty slice
+	m = make([]*SieveRule, 0)
+
+	for rows.Next() {
+		var i = &SieveRule{}
+
+		err = rows.Scan(
+			&i.Id,
+			&i.UID,
+			&i.Name,
+			&i.Field,
+			&i.Header,
+			&i.Match,
+			&i.Value,
+			&i.Action,
+			&i.Target,
+			&i.Copy,
+			&i.Stop,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		m = append(m, i)
+	}
+
+	return m, rows.Err()
+}
+
+// SieveRuleUsers returns ids of the users with rules
+func (s *DB) SieveRuleUsers() (m []int64, err error) {
+	var rows *sql.Rows
+
+	if rows, err = s.Query("SELECT DISTINCT `uid` FROM `sieve_rule` ORDER BY `uid`"); err != nil {
+		return
+	}
+
+	defer rows.Close()
+	// Create empty slice
+	m = make([]int64, 0)
+
+	for rows.Next() {
+		var uid int64
+
+		if err = rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+
+		m = append(m, uid)
+	}
+
+	return m, rows.Err()
+}
+
+// SetSieveRules replaces all user rules
+func (s *DB) SetSieveRules(uid int64, rules []*SieveRule) error {
+	return s.transaction(func(d *DB) (err error) {
+		if _, err = d.Exec("DELETE FROM `sieve_rule` WHERE `uid` = ?", uid); err != nil {
+			return
+		}
+
+		for pos, i := range rules {
+			var res sql.Result
+
+			i.UID = uid
+
+			if res, err = d.Exec("INSERT INTO `sieve_rule` ("+
+				"`uid`, `position`, `name`, `field`, `header`, `match`, `value`, `action`, `target`, `copy`, `stop`"+
+				") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				i.UID,
+				pos,
+				i.Name,
+				i.Field,
+				i.Header,
+				i.Match,
+				i.Value,
+				i.Action,
+				i.Target,
+				i.Copy,
+				i.Stop); err != nil {
+				return
+			}
+
+			if i.Id, err = res.LastInsertId(); err != nil {
+				return
+			}
+		}
+
+		return
+	})
+}
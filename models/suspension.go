@@ -0,0 +1,225 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Suspension represents disabled account, service flags of the user
+// are saved when the suspension is applied and restored on resume
+type Suspension struct {
+	UID    int64      `json:"uid" schema:"-"`
+	Reason string     `json:"reason" schema:"reason"`
+	Start  *time.Time `json:"start" schema:"start"`
+	End    *time.Time `json:"end" schema:"end"`
+	// Suspension is in force
+	Applied Boolean `json:"applied" schema:"-"`
+	// Saved service flags
+	Smtp Boolean `json:"smtp" schema:"-"`
+	Imap Boolean `json:"imap" schema:"-"`
+	Pop3 Boolean `json:"pop3" schema:"-"`
+}
+
+// Suspensions returns list of the suspended and scheduled accounts
+func (s *DB) Suspensions(flt FilterIface, cnt bool) (m []*Suspension, count uint64, err error) {
+	var (
+		query    *Query
+		queryStr string
+		args     []interface{}
+		rows     *sql.Rows
+	)
+
+	if flt == nil {
+		flt = NewFilter()
+	}
+
+	query = flt.(*Query)
+
+	for _, expr := range query.expressions {
+		switch expr.name {
+		case "WHERE":
+			expr.CbFunc(suspensionWhere)
+		case "ORDER BY":
+			expr.CbFunc(suspensionOrder)
+		}
+	}
+
+	// Base query
+	query.raw = "SELECT `s`.`uid` `uid`" +
+		", `s`.`reason` `reason`" +
+		", `s`.`start` `start`" +
+		", `s`.`end` `end`" +
+		", `s`.`applied` `applied`" +
+		", `s`.`smtp` `smtp`" +
+		", `s`.`imap` `imap`" +
+		", `s`.`pop3` `pop3`" +
+		" " +
+		"FROM `suspension` AS `s` "
+
+	if queryStr, args, err = query.Compile(); err != nil {
+		return
+	}
+
+	if rows, err = s.Query(queryStr, args...); err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+	// Create empty slice
+	m = make([]*Suspension, 0)
+
+	for rows.Next() {
+		var i = &Suspension{}
+
+		err = rows.Scan(
+			&i.UID,
+			&i.Reason,
+			&i.Start,
+			&i.End,
+			&i.Applied,
+			&i.Smtp,
+			&i.Imap,
+			&i.Pop3,
+		)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		m = append(m, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if cnt {
+		query.raw = "SELECT COUNT(*) " +
+			"FROM `suspension` AS `s` "
+
+		query.Un("LIMIT")
+		query.Un("ORDER BY")
+
+		if queryStr, args, err = query.Compile(); err != nil {
+			return
+		}
+
+		err = s.QueryRow(queryStr, args...).Scan(&count)
+
+		if err != nil && err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// SuspendUser saves suspension. If apply is set service flags of the user
+// are saved and cleared. Suspension in force only changes its reason
+// and period
+func (s *DB) SuspendUser(v *Suspension, apply bool) error {
+	return s.transaction(func(d *DB) (err error) {
+		var applied Boolean
+
+		err = d.QueryRow("SELECT `applied` FROM `suspension` WHERE `uid` = ? FOR UPDATE", v.UID).
+			Scan(&applied)
+
+		if err != nil && err != sql.ErrNoRows {
+			return
+		}
+
+		if applied {
+			v.Applied = true
+
+			_, err = d.Exec("UPDATE `suspension` SET `reason` = ?, `start` = ?, `end` = ? "+
+				"WHERE `uid` = ?", v.Reason, v.Start, v.End, v.UID)
+
+			return
+		}
+
+		v.Applied = Boolean(apply)
+
+		if apply {
+			if err = d.QueryRow("SELECT `smtp`, `imap`, `pop3` FROM `users` WHERE `id` = ? FOR UPDATE", v.UID).
+				Scan(&v.Smtp, &v.Imap, &v.Pop3); err != nil {
+				return
+			}
+
+			if _, err = d.Exec("UPDATE `users` SET `smtp` = 0, `imap` = 0, `pop3` = 0 "+
+				"WHERE `id` = ?", v.UID); err != nil {
+				return
+			}
+		}
+
+		_, err = d.Exec("REPLACE INTO `suspension` ("+
+			"`uid`, `reason`, `start`, `end`, `applied`, `smtp`, `imap`, `pop3`"+
+			") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			v.UID,
+			v.Reason,
+			v.Start,
+			v.End,
+			v.Applied,
+			v.Smtp,
+			v.Imap,
+			v.Pop3)
+
+		return
+	})
+}
+
+// ResumeUser restores saved service flags of the user and removes
+// suspension
+func (s *DB) ResumeUser(uid int64) error {
+	return s.transaction(func(d *DB) (err error) {
+		var v = &Suspension{}
+
+		err = d.QueryRow("SELECT `applied`, `smtp`, `imap`, `pop3` FROM `suspension` "+
+			"WHERE `uid` = ? FOR UPDATE", uid).
+			Scan(&v.Applied, &v.Smtp, &v.Imap, &v.Pop3)
+
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+		if err != nil {
+			return
+		}
+
+		if v.Applied {
+			if _, err = d.Exec("UPDATE `users` SET `smtp` = ?, `imap` = ?, `pop3` = ? "+
+				"WHERE `id` = ?", v.Smtp, v.Imap, v.Pop3, uid); err != nil {
+				return
+			}
+		}
+
+		_, err = d.Exec("DELETE FROM `suspension` WHERE `uid` = ?", uid)
+
+		return
+	})
+}
+
+func suspensionWhere(arg *NamedArg) (string, error) {
+	switch arg.Name {
+	case "uid":
+		return "`s`.`uid` = ?", nil
+
+	case "applied":
+		return "`s`.`applied` = ?", nil
+	}
+
+	return "", ErrFilterArgument
+}
+
+func suspensionOrder(arg *NamedArg) (string, error) {
+	var dir = arg.First().(string)
+
+	switch arg.Name {
+	case "uid":
+		return "`s`.`uid` " + dir, nil
+
+	case "start":
+		return "`s`.`start` " + dir, nil
+	}
+
+	return "", ErrFilterArgument
+}
@@ -14,6 +14,50 @@ type Transport struct {
 }
 
 func (s *DB) Transports(flt FilterIface, cnt bool) (m []*Transport, count uint64, err error) {
+	var (
+		query     *Query
+		query_str string
+		args      []interface{}
+	)
+
+	if flt == nil {
+		flt = NewFilter()
+	}
+
+	query = flt.(*Query)
+	// Create empty slice
+	m = make([]*Transport, 0)
+
+	if err = s.TransportsEach(flt, func(i *Transport) error {
+		m = append(m, i)
+		return nil
+	}); err != nil {
+		return nil, 0, err
+	}
+
+	if cnt {
+		query.raw = "SELECT COUNT(*) " +
+			"FROM `transport` AS `t` "
+
+		query.Un("LIMIT")
+
+		if query_str, args, err = query.Compile(); err != nil {
+			return
+		}
+
+		err = s.QueryRow(query_str, args...).Scan(&count)
+
+		if err != nil && err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// TransportsEach calls fn for each transport of the filtered list without
+// buffering all rows, iteration is stopped by the fn error
+func (s *DB) TransportsEach(flt FilterIface, fn func(*Transport) error) (err error) {
 	var (
 		query     *Query
 		query_str string
@@ -52,12 +96,10 @@ func (s *DB) Transports(flt FilterIface, cnt bool) (m []*Transport, count uint64
 	}
 
 	if rows, err = s.Query(query_str, args...); err != nil {
-		return nil, 0, err
+		return
 	}
 
 	defer rows.Close()
-	// Create empty slice
-	m = make([]*Transport, 0)
 
 	for rows.Next() {
 		var i = &Transport{}
@@ -72,34 +114,15 @@ func (s *DB) Transports(flt FilterIface, cnt bool) (m []*Transport, count uint64
 		)
 
 		if err != nil {
-			return nil, 0, err
-		}
-
-		m = append(m, i)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, 0, err
-	}
-
-	if cnt {
-		query.raw = "SELECT COUNT(*) " +
-			"FROM `transport` AS `t` "
-
-		query.Un("LIMIT")
-
-		if query_str, args, err = query.Compile(); err != nil {
 			return
 		}
 
-		err = s.QueryRow(query_str, args...).Scan(&count)
-
-		if err != nil && err == sql.ErrNoRows {
-			err = nil
+		if err = fn(i); err != nil {
+			return
 		}
 	}
 
-	return
+	return rows.Err()
 }
 
 func transportWhere(arg *NamedArg) (string, error) {
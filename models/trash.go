@@ -0,0 +1,190 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Kinds of the soft deleted records
+const (
+	TrashUser  = "user"
+	TrashAlias = "alias"
+	TrashBcc   = "bcc"
+)
+
+var trashTables = map[string]string{
+	TrashUser:  "users",
+	TrashAlias: "aliases",
+	TrashBcc:   "bcc",
+}
+
+// Trash is the soft deleted user, alias or bcc rule
+type Trash struct {
+	Kind    string    `json:"kind"`
+	Id      int64     `json:"id"`
+	Name    string    `json:"name"`
+	Deleted time.Time `json:"deleted_at"`
+}
+
+// Trash returns soft deleted records of all kinds, the latest first
+// unless the order is passed
+func (s *DB) Trash(flt FilterIface, cnt bool) (m []*Trash, count uint64, err error) {
+	var (
+		query    *Query
+		queryStr string
+		args     []interface{}
+		rows     *sql.Rows
+	)
+
+	if flt == nil {
+		flt = NewFilter()
+	}
+
+	query = flt.(*Query)
+
+	if _, expr := query.Expression("ORDER BY"); expr == nil {
+		query.Order("deleted", false)
+	}
+
+	for _, expr := range query.expressions {
+		switch expr.name {
+		case "WHERE":
+			expr.CbFunc(trashWhere)
+		case "ORDER BY":
+			expr.CbFunc(trashOrder)
+		}
+	}
+
+	query.raw = "SELECT `tr`.`kind`, `tr`.`id`, `tr`.`name`, `tr`.`deleted_at` FROM (" +
+		"SELECT '" + TrashUser + "' AS `kind`, `u`.`id` AS `id`" +
+		", CONCAT(`u`.`login`, '@', IFNULL(`t`.`domain`, '')) AS `name`, `u`.`deleted_at` AS `deleted_at` " +
+		"FROM `users` AS `u` LEFT JOIN `transport` AS `t` ON (`u`.`domid` = `t`.`id`) " +
+		"WHERE `u`.`deleted_at` IS NOT NULL " +
+		"UNION ALL " +
+		"SELECT '" + TrashAlias + "', `id`, CONCAT(`alias`, ' > ', `recipient`), `deleted_at` " +
+		"FROM `aliases` WHERE `deleted_at` IS NOT NULL " +
+		"UNION ALL " +
+		"SELECT '" + TrashBcc + "', `id`, CONCAT(`sender`, `recipient`, ' > ', `copy`), `deleted_at` " +
+		"FROM `bcc` WHERE `deleted_at` IS NOT NULL" +
+		") AS `tr` "
+
+	if queryStr, args, err = query.Compile(); err != nil {
+		return
+	}
+
+	if rows, err = s.Query(queryStr, args...); err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	m = make([]*Trash, 0)
+
+	for rows.Next() {
+		var i = &Trash{}
+
+		if err = rows.Scan(&i.Kind, &i.Id, &i.Name, &i.Deleted); err != nil {
+			return nil, 0, err
+		}
+
+		m = append(m, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if cnt {
+		query.Un("LIMIT")
+		query.Un("ORDER BY")
+
+		if queryStr, args, err = query.Compile(); err != nil {
+			return
+		}
+
+		err = s.QueryRow("SELECT COUNT(*) FROM ("+queryStr+") AS `cnt`", args...).Scan(&count)
+
+		if err != nil && err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// RestoreTrash returns soft deleted record back, sql.ErrNoRows is
+// returned if it is not in the trash
+func (s *DB) RestoreTrash(kind string, id int64) error {
+	return s.trashExec(kind, "UPDATE `%s` SET `deleted_at` = NULL "+
+		"WHERE `id` = ? AND `deleted_at` IS NOT NULL", id)
+}
+
+// PurgeTrash removes soft deleted record permanently. Suspension,
+// vacation and sieve rules of the user are removed with it
+func (s *DB) PurgeTrash(kind string, id int64) error {
+	return s.transaction(func(d *DB) (err error) {
+		if err = d.trashExec(kind, "DELETE FROM `%s` WHERE `id` = ? AND `deleted_at` IS NOT NULL", id); err != nil {
+			return
+		}
+
+		if kind != TrashUser {
+			return
+		}
+
+		for _, table := range []string{"suspension", "vacation", "sieve_rule", "password_history", "statistics"} {
+			if _, err = d.Exec("DELETE FROM `"+table+"` WHERE `uid` = ?", id); err != nil {
+				return
+			}
+		}
+
+		return
+	})
+}
+
+func (s *DB) trashExec(kind, query string, id int64) (err error) {
+	var (
+		res   sql.Result
+		rows  int64
+		table string
+		ok    bool
+	)
+
+	if table, ok = trashTables[kind]; !ok {
+		return ErrFilterArgument
+	}
+
+	if res, err = s.Exec(fmt.Sprintf(query, table), id); err != nil {
+		return
+	}
+
+	if rows, err = res.RowsAffected(); err == nil && rows == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+func trashWhere(arg *NamedArg) (string, error) {
+	switch arg.Name {
+	case "kind":
+		return "`tr`.`kind` = ?", nil
+
+	case "id":
+		return "`tr`.`id` = ?", nil
+
+	case "before":
+		return "`tr`.`deleted_at` < ?", nil
+	}
+
+	return "", ErrFilterArgument
+}
+
+func trashOrder(arg *NamedArg) (string, error) {
+	switch arg.Name {
+	case "deleted":
+		return "`tr`.`deleted_at` " + arg.First().(string), nil
+	}
+
+	return "", ErrFilterArgument
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// Usage represents mailbox space usage read from the maildir
+type Usage struct {
+	Bytes         int64      `json:"bytes"`
+	Messages      int64      `json:"messages"`
+	LimitBytes    int64      `json:"limit_bytes"`
+	LimitMessages int64      `json:"limit_messages"`
+	Percent       float64    `json:"percent"`
+	Updated       *time.Time `json:"updated"`
+}
+
+// Calc updates usage percent from the current values and limits.
+// If both limits are defined the greater ratio is used
+func (u *Usage) Calc() {
+	u.Percent = 0
+
+	if u.LimitBytes > 0 {
+		u.Percent = float64(u.Bytes) * 100 / float64(u.LimitBytes)
+	}
+
+	if u.LimitMessages > 0 {
+		if p := float64(u.Messages) * 100 / float64(u.LimitMessages); p > u.Percent {
+			u.Percent = p
+		}
+	}
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"strings"
+	"time"
 )
 
 type User struct {
@@ -20,13 +21,70 @@ type User struct {
 	Sieve      Boolean `json:"sieve" schema:"sieve"`
 	Manager    Boolean `json:"manager" schema:"manager"`
 	Email      Email   `json:"email" schema:"email"`
+	Usage      *Usage  `json:"usage,omitempty" schema:"-"`
+
+	PasswordChanged *time.Time `json:"password_changed_at" schema:"-"`
+	MustChange      Boolean    `json:"must_change" schema:"must_change"`
+	// Last IMAP login from the statistics
+	LastLogin *time.Time `json:"last_login" schema:"-"`
 
 	// protected
 	secret string
 	token  string
 }
 
+// userLastLogin joins the latest IMAP login of the user
+const userLastLogin = "LEFT JOIN (SELECT `uid`, MAX(`updated`) `updated` FROM `statistics` " +
+	"WHERE `service` = 'imap' GROUP BY `uid`) `ls` ON (`ls`.`uid` = `u`.`id`) "
+
 func (s *DB) Users(flt FilterIface, cnt bool) (m []*User, count uint64, err error) {
+	var (
+		query    *Query
+		queryStr string
+		args     []interface{}
+	)
+
+	if flt == nil {
+		flt = NewFilter()
+	}
+
+	query = flt.(*Query)
+	// Create empty slice
+	m = make([]*User, 0)
+
+	if err = s.UsersEach(flt, func(i *User) error {
+		m = append(m, i)
+		return nil
+	}); err != nil {
+		return nil, 0, err
+	}
+
+	if cnt {
+		query.raw = "SELECT COUNT(*) " +
+			"FROM `users` AS `u` " +
+			"LEFT JOIN `transport` `t` ON (`u`.`domid` = `t`.`id`) " +
+			"LEFT JOIN `password_policy` `pp` ON (`u`.`domid` = `pp`.`domid`) " +
+			userLastLogin
+
+		query.Un("LIMIT")
+
+		if queryStr, args, err = query.Compile(); err != nil {
+			return
+		}
+
+		err = s.QueryRow(queryStr, args...).Scan(&count)
+
+		if err != nil && err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// UsersEach calls fn for each user of the filtered list without
+// buffering all rows, iteration is stopped by the fn error
+func (s *DB) UsersEach(flt FilterIface, fn func(*User) error) (err error) {
 	var (
 		query    *Query
 		queryStr string
@@ -39,6 +97,7 @@ func (s *DB) Users(flt FilterIface, cnt bool) (m []*User, count uint64, err erro
 	}
 
 	query = flt.(*Query)
+	query.alive()
 
 	for _, expr := range query.expressions {
 		switch expr.name {
@@ -65,9 +124,14 @@ func (s *DB) Users(flt FilterIface, cnt bool) (m []*User, count uint64, err erro
 		", `t`.`domain` `domainname`" +
 		", `u`.`secret` `secret`" +
 		", `u`.`token` `token`" +
+		", `u`.`password_changed_at` `password_changed_at`" +
+		", `u`.`must_change` `must_change`" +
+		", `ls`.`updated` `last_login`" +
 		" " +
 		"FROM `users` AS `u` " +
-		"LEFT JOIN `transport` `t` ON (`u`.`domid` = `t`.`id`) "
+		"LEFT JOIN `transport` `t` ON (`u`.`domid` = `t`.`id`) " +
+		"LEFT JOIN `password_policy` `pp` ON (`u`.`domid` = `pp`.`domid`) " +
+		userLastLogin
 
 	// Add where
 	if queryStr, args, err = query.Compile(); err != nil {
@@ -75,12 +139,10 @@ func (s *DB) Users(flt FilterIface, cnt bool) (m []*User, count uint64, err erro
 	}
 
 	if rows, err = s.Query(queryStr, args...); err != nil {
-		return nil, 0, err
+		return
 	}
 
 	defer rows.Close()
-	// Create empty slice
-	m = make([]*User, 0)
 
 	for rows.Next() {
 		var i = &User{}
@@ -101,40 +163,23 @@ func (s *DB) Users(flt FilterIface, cnt bool) (m []*User, count uint64, err erro
 			&i.DomainName,
 			&i.secret,
 			&i.token,
+			&i.PasswordChanged,
+			&i.MustChange,
+			&i.LastLogin,
 		)
 
 		if err != nil {
-			return nil, 0, err
+			return
 		}
 
 		i.Email = Email(strings.Join([]string{i.Login, i.DomainName}, "@"))
 
-		m = append(m, i)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, 0, err
-	}
-
-	if cnt {
-		query.raw = "SELECT COUNT(*) " +
-			"FROM `users` AS `u` " +
-			"LEFT JOIN `transport` `t` ON (`u`.`domid` = `t`.`id`) "
-
-		query.Un("LIMIT")
-
-		if queryStr, args, err = query.Compile(); err != nil {
+		if err = fn(i); err != nil {
 			return
 		}
-
-		err = s.QueryRow(queryStr, args...).Scan(&count)
-
-		if err != nil && err == sql.ErrNoRows {
-			err = nil
-		}
 	}
 
-	return
+	return rows.Err()
 }
 
 func (s *DB) SetUser(user *User) (err error) {
@@ -154,6 +199,7 @@ func (s *DB) SetUser(user *User) (err error) {
 			", `pop3` = ?"+
 			", `sieve` = ?"+
 			", `manager` = ?"+
+			", `must_change` = ?"+
 			" WHERE `id` = ?",
 			user.Name,
 			user.Login,
@@ -165,6 +211,7 @@ func (s *DB) SetUser(user *User) (err error) {
 			user.Pop3,
 			user.Sieve,
 			user.Manager,
+			user.MustChange,
 			user.Id)
 	} else {
 		result, err = s.Exec("INSERT INTO `users` ("+
@@ -178,7 +225,8 @@ func (s *DB) SetUser(user *User) (err error) {
 			", `pop3`"+
 			", `sieve`"+
 			", `manager`"+
-			") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			", `must_change`"+
+			") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 			user.Name,
 			user.Login,
 			user.Domain,
@@ -188,7 +236,8 @@ func (s *DB) SetUser(user *User) (err error) {
 			user.Imap,
 			user.Pop3,
 			user.Sieve,
-			user.Manager)
+			user.Manager,
+			user.MustChange)
 
 		if err != nil {
 			return
@@ -198,14 +247,31 @@ func (s *DB) SetUser(user *User) (err error) {
 	}
 
 	if err == nil && user.Password != "" {
-		_, err = s.Exec("UPDATE `users` SET `passwd` = ? WHERE `id` = ?", user.Password, user.Id)
+		_, err = s.Exec("UPDATE `users` SET `passwd` = ?, `password_changed_at` = NOW() "+
+			"WHERE `id` = ?", user.Password, user.Id)
 	}
 
 	return
 }
 
+// SetUserPassword changes password by the owner and clears
+// the change request
+func (s *DB) SetUserPassword(user *User) (err error) {
+	_, err = s.Exec("UPDATE `users` SET "+
+		"`passwd` = ?"+
+		", `password_changed_at` = NOW()"+
+		", `must_change` = 0"+
+		" WHERE `id` = ?",
+		user.Password,
+		user.Id)
+
+	return
+}
+
+// DelUser moves the user to the trash, it is hidden from the lists
+// until restored or purged
 func (s *DB) DelUser(id int64) (err error) {
-	_, err = s.Exec("DELETE FROM `users` WHERE `id` = ?", id)
+	_, err = s.Exec("UPDATE `users` SET `deleted_at` = NOW() WHERE `id` = ? AND `deleted_at` IS NULL", id)
 
 	return
 }
@@ -238,6 +304,12 @@ func (u *User) Token() string {
 
 func userWhere(arg *NamedArg) (string, error) {
 	switch arg.Name {
+	case "alive":
+		return "`u`.`deleted_at` IS NULL", nil
+
+	case "deleted":
+		return "`u`.`deleted_at` IS NOT NULL", nil
+
 	case "emlike":
 		return "CONCAT(`u`.`login`, '@', `t`.`domain`) LIKE ?", nil
 
@@ -256,6 +328,9 @@ func userWhere(arg *NamedArg) (string, error) {
 	case "domain":
 		return "`t`.`domain` = ?", nil
 
+	case "domid":
+		return "`u`.`domid` = ?", nil
+
 	case "passwd":
 		return "`u`.`passwd` = ?", nil
 
@@ -271,20 +346,66 @@ func userWhere(arg *NamedArg) (string, error) {
 	case "pop3":
 		return "`u`.`pop3` = ?", nil
 
+	case "smtp":
+		return "`u`.`smtp` = ?", nil
+
+	case "sieve":
+		return "`u`.`sieve` = ?", nil
+
+	case "login_after":
+		return "`ls`.`updated` >= ?", nil
+
+	case "login_before":
+		return "`ls`.`updated` < ?", nil
+
+	case "login_never":
+		return "`ls`.`updated` IS NULL", nil
+
 	case "mode_on":
 		return "(`u`.`smtp` = 1 OR `u`.`imap` = 1 OR `u`.`pop3` = 1)", nil
 
 	case "mode_off":
 		return "(`u`.`smtp` = 0 AND `u`.`imap` = 0 AND `u`.`pop3` = 0)", nil
+
+	case "suspended":
+		return "EXISTS(SELECT 1 FROM `suspension` AS `sp` " +
+			"WHERE `sp`.`uid` = `u`.`id` AND `sp`.`applied` = 1)", nil
+
+	case "suspend_scheduled":
+		return "EXISTS(SELECT 1 FROM `suspension` AS `sp` " +
+			"WHERE `sp`.`uid` = `u`.`id` AND `sp`.`applied` = 0)", nil
+
+	case "passwd_expired":
+		// Value is the common maximum password age in days,
+		// it is used if the domain policy is not set
+		if arg.Value != nil && len(arg.Value) == 1 {
+			arg.Fill(arg.Value[0], 2)
+		}
+		return "(`u`.`must_change` = 1 OR (IFNULL(`pp`.`max_age`, ?) > 0 " +
+			"AND `u`.`password_changed_at` < NOW() - INTERVAL IFNULL(`pp`.`max_age`, ?) DAY))", nil
 	}
 
 	return "", ErrFilterArgument
 }
 
 func userOrder(arg *NamedArg) (string, error) {
+	var dir = arg.First().(string)
+
 	switch arg.Name {
 	case "id":
-		return "`u`.`id`", nil
+		return "`u`.`id` " + dir, nil
+
+	case "name":
+		return "`u`.`name` " + dir, nil
+
+	case "email":
+		return "CONCAT(`u`.`login`, '@', `t`.`domain`) " + dir, nil
+
+	case "domain":
+		return "`t`.`domain` " + dir + ", `u`.`login` " + dir, nil
+
+	case "last_login":
+		return "`ls`.`updated` " + dir, nil
 	}
 
 	return "", ErrFilterArgument
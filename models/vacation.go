@@ -0,0 +1,166 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Vacation represents out-of-office auto reply of the user
+type Vacation struct {
+	UID     int64      `json:"uid" schema:"-"`
+	Active  Boolean    `json:"active" schema:"active"`
+	Subject string     `json:"subject" schema:"subject"`
+	Body    string     `json:"body" schema:"body"`
+	Start   *time.Time `json:"start" schema:"start"`
+	End     *time.Time `json:"end" schema:"end"`
+	// Days between replies to the same sender
+	Interval uint `json:"interval" schema:"interval"`
+	// Additional addresses of the user, reply is not sent
+	// to messages addressed elsewhere
+	Addresses []string `json:"addresses" schema:"addresses"`
+}
+
+// Vacations returns list of the auto replies
+func (s *DB) Vacations(flt FilterIface, cnt bool) (m []*Vacation, count uint64, err error) {
+	var (
+		query    *Query
+		queryStr string
+		args     []interface{}
+		rows     *sql.Rows
+	)
+
+	if flt == nil {
+		flt = NewFilter()
+	}
+
+	query = flt.(*Query)
+
+	for _, expr := range query.expressions {
+		switch expr.name {
+		case "WHERE":
+			expr.CbFunc(vacationWhere)
+		case "ORDER BY":
+			expr.CbFunc(vacationOrder)
+		}
+	}
+
+	// Base query
+	query.raw = "SELECT `v`.`uid` `uid`" +
+		", `v`.`active` `active`" +
+		", `v`.`subject` `subject`" +
+		", `v`.`body` `body`" +
+		", `v`.`start` `start`" +
+		", `v`.`end` `end`" +
+		", `v`.`days` `days`" +
+		", `v`.`addresses` `addresses`" +
+		" " +
+		"FROM `vacation` AS `v` "
+
+	if queryStr, args, err = query.Compile(); err != nil {
+		return
+	}
+
+	if rows, err = s.Query(queryStr, args...); err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+	// Create empty slice
+	m = make([]*Vacation, 0)
+
+	for rows.Next() {
+		var (
+			i         = &Vacation{}
+			addresses string
+		)
+
+		err = rows.Scan(
+			&i.UID,
+			&i.Active,
+			&i.Subject,
+			&i.Body,
+			&i.Start,
+			&i.End,
+			&i.Interval,
+			&addresses,
+		)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if addresses != "" {
+			i.Addresses = strings.Split(addresses, ",")
+		}
+
+		m = append(m, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if cnt {
+		query.raw = "SELECT COUNT(*) " +
+			"FROM `vacation` AS `v` "
+
+		query.Un("LIMIT")
+		query.Un("ORDER BY")
+
+		if queryStr, args, err = query.Compile(); err != nil {
+			return
+		}
+
+		err = s.QueryRow(queryStr, args...).Scan(&count)
+
+		if err != nil && err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// SetVacation creates or replaces user auto reply
+func (s *DB) SetVacation(v *Vacation) (err error) {
+	_, err = s.Exec("REPLACE INTO `vacation` ("+
+		"`uid`, `active`, `subject`, `body`, `start`, `end`, `days`, `addresses`"+
+		") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		v.UID,
+		v.Active,
+		v.Subject,
+		v.Body,
+		v.Start,
+		v.End,
+		v.Interval,
+		strings.Join(v.Addresses, ","))
+
+	return
+}
+
+func vacationWhere(arg *NamedArg) (string, error) {
+	switch arg.Name {
+	case "uid":
+		return "`v`.`uid` = ?", nil
+
+	case "active":
+		return "`v`.`active` = ?", nil
+	}
+
+	return "", ErrFilterArgument
+}
+
+func vacationOrder(arg *NamedArg) (string, error) {
+	var dir = arg.First().(string)
+
+	switch arg.Name {
+	case "uid":
+		return "`v`.`uid` " + dir, nil
+
+	case "start":
+		return "`v`.`start` " + dir, nil
+	}
+
+	return "", ErrFilterArgument
+}
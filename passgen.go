@@ -0,0 +1,261 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"math"
+	"math/big"
+	"mbmi-go/models"
+	"strings"
+)
+
+const (
+	// passwordAttempts limits generator tries to satisfy the policy
+	passwordAttempts = 100
+
+	consonantsStore = "bcdfghjklmnprstvz"
+	vowelsStore     = "aeiou"
+	// Symbols without the passwd-file separator, see checkPassword
+	passwordSymbols = "~!@#$%^&*()_+-={}|[]<>?,./"
+)
+
+var errPasswordPolicy = errors.New("Cannot generate password satisfying the policy")
+
+// GeneratedPassword represents password with entropy estimate in bits
+type GeneratedPassword struct {
+	Password string  `json:"password"`
+	Entropy  float64 `json:"entropy"`
+}
+
+// passwordAlphabet returns characters set for the random password,
+// character classes required by the policy can't be switched off
+func passwordAlphabet(p *models.PasswordPolicy, noUpper, noDigits, noSymbols bool) string {
+	var letters = lowerStore
+
+	if !noUpper || bool(p.Upper) {
+		letters += upperStore
+	}
+
+	if !noDigits || bool(p.Digits) {
+		letters += digitsStore
+	}
+
+	if !noSymbols || bool(p.Symbols) {
+		letters += passwordSymbols
+	}
+
+	return letters
+}
+
+// generateRandom creates random password from the letters set
+func generateRandom(p *models.PasswordPolicy, n int, letters string) (*GeneratedPassword, error) {
+	if n < p.MinLength {
+		n = p.MinLength
+	}
+
+	if letters = uniqueLetters(letters); letters == "" {
+		return nil, errors.New("Empty characters set")
+	}
+
+	return generateWith(p, func() (string, float64, error) {
+		var (
+			err error
+			str = make([]rune, n)
+			set = []rune(letters)
+		)
+
+		for i := range str {
+			if str[i], err = randomRune(set); err != nil {
+				return "", 0, err
+			}
+		}
+
+		return string(str), float64(n) * math.Log2(float64(len(set))), nil
+	})
+}
+
+// generatePassphrase creates diceware-style passphrase. Capital letter
+// and digit are added if the policy requires them
+func generatePassphrase(p *models.PasswordPolicy, words int, sep string) (*GeneratedPassword, error) {
+	if words < 1 {
+		words = 5
+	}
+
+	return generateWith(p, func() (string, float64, error) {
+		var (
+			list    = make([]string, words)
+			entropy = float64(words) * math.Log2(float64(len(dicewareWords)))
+		)
+
+		for i := range list {
+			n, err := randomInt(len(dicewareWords))
+			if err != nil {
+				return "", 0, err
+			}
+
+			list[i] = dicewareWords[n]
+		}
+
+		if p.Upper {
+			n, err := randomInt(words)
+			if err != nil {
+				return "", 0, err
+			}
+
+			list[n] = strings.Title(list[n])
+			entropy += math.Log2(float64(words))
+		}
+
+		if p.Digits {
+			d, err := randomRune([]rune(digitsStore))
+			if err != nil {
+				return "", 0, err
+			}
+
+			list[words-1] += string(d)
+			entropy += math.Log2(float64(len(digitsStore)))
+		}
+
+		str := strings.Join(list, sep)
+
+		// Put symbol separator if it is required and there is no one
+		if p.Symbols && strings.IndexAny(str, passwordSymbols) < 0 {
+			s, err := randomRune([]rune(passwordSymbols))
+			if err != nil {
+				return "", 0, err
+			}
+
+			str += string(s)
+			entropy += math.Log2(float64(len(passwordSymbols)))
+		}
+
+		for len([]rune(str)) < p.MinLength {
+			n, err := randomInt(len(dicewareWords))
+			if err != nil {
+				return "", 0, err
+			}
+
+			str += sep + dicewareWords[n]
+			entropy += math.Log2(float64(len(dicewareWords)))
+		}
+
+		return str, entropy, nil
+	})
+}
+
+// generatePronounceable creates password of alternating consonants and
+// vowels. Capital letter, digits and symbol are added if the policy
+// requires them
+func generatePronounceable(p *models.PasswordPolicy, n int) (*GeneratedPassword, error) {
+	if n < p.MinLength {
+		n = p.MinLength
+	}
+
+	return generateWith(p, func() (string, float64, error) {
+		var (
+			entropy float64
+			tail    string
+			str     = make([]rune, 0, n)
+		)
+
+		if p.Digits {
+			for i := 0; i < 2; i++ {
+				d, err := randomRune([]rune(digitsStore))
+				if err != nil {
+					return "", 0, err
+				}
+
+				tail += string(d)
+				entropy += math.Log2(float64(len(digitsStore)))
+			}
+		}
+
+		if p.Symbols {
+			s, err := randomRune([]rune(passwordSymbols))
+			if err != nil {
+				return "", 0, err
+			}
+
+			tail += string(s)
+			entropy += math.Log2(float64(len(passwordSymbols)))
+		}
+
+		for i := 0; len(str)+len(tail) < n || i == 0; i++ {
+			set := consonantsStore
+			if i%2 == 1 {
+				set = vowelsStore
+			}
+
+			c, err := randomRune([]rune(set))
+			if err != nil {
+				return "", 0, err
+			}
+
+			str = append(str, c)
+			entropy += math.Log2(float64(len(set)))
+		}
+
+		if p.Upper {
+			str[0] = []rune(strings.ToUpper(string(str[0])))[0]
+		}
+
+		return string(str) + tail, entropy, nil
+	})
+}
+
+// generateWith repeats generator until the password satisfies the policy
+func generateWith(p *models.PasswordPolicy, fn func() (string, float64, error)) (*GeneratedPassword, error) {
+	for i := 0; i < passwordAttempts; i++ {
+		str, entropy, err := fn()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(checkPassword(p, str, nil)) == 0 {
+			return &GeneratedPassword{
+				Password: str,
+				Entropy:  math.Floor(entropy*10) / 10,
+			}, nil
+		}
+	}
+
+	return nil, errPasswordPolicy
+}
+
+// randomInt returns uniform random number in [0, n)
+func randomInt(n int) (int, error) {
+	v, err := crand.Int(crand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(v.Int64()), nil
+}
+
+// randomRune returns random character from the set
+func randomRune(set []rune) (rune, error) {
+	n, err := randomInt(len(set))
+	if err != nil {
+		return 0, err
+	}
+
+	return set[n], nil
+}
+
+// uniqueLetters removes duplicates from the characters set
+func uniqueLetters(letters string) string {
+	var (
+		seen = make(map[rune]bool)
+		list = make([]rune, 0, len(letters))
+	)
+
+	for _, c := range letters {
+		if !seen[c] {
+			seen[c] = true
+			list = append(list, c)
+		}
+	}
+
+	return string(list)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"strings"
+	"testing"
+)
+
+func Test_GeneratedPasswordsSatisfyPolicy(t *testing.T) {
+	policy := &models.PasswordPolicy{
+		MinLength: 12,
+		Lower:     true,
+		Upper:     true,
+		Digits:    true,
+		Symbols:   true,
+	}
+
+	letters := passwordAlphabet(policy, true, true, true)
+
+	for i := 0; i < 20; i++ {
+		for mode, fn := range map[string]func() (*GeneratedPassword, error){
+			"random":        func() (*GeneratedPassword, error) { return generateRandom(policy, 8, letters) },
+			"passphrase":    func() (*GeneratedPassword, error) { return generatePassphrase(policy, 3, ".") },
+			"pronounceable": func() (*GeneratedPassword, error) { return generatePronounceable(policy, 8) },
+		} {
+			p, err := fn()
+
+			if err != nil {
+				t.Fatalf("%s: %s", mode, err)
+			}
+
+			if list := checkPassword(policy, p.Password, nil); len(list) > 0 {
+				t.Errorf("%s: password %s violates policy: %s", mode, p.Password, strings.Join(list, ", "))
+			}
+
+			if p.Entropy < 20 {
+				t.Errorf("%s: unexpected low entropy %f for %s", mode, p.Entropy, p.Password)
+			}
+		}
+	}
+}
+
+func Test_CheckPasswordSeparators(t *testing.T) {
+	for _, p := range []string{"Secret1x\nboss@user.net:pw:8:12::/var/mail/user.net/boss::", "Secret1x:", "Secret1x\t"} {
+		if list := checkPassword(&models.PasswordPolicy{}, p, nil); len(list) != 1 {
+			t.Errorf("Password %q is expected to be rejected, got %v", p, list)
+		}
+	}
+}
+
+func Test_CheckPasswordHistory(t *testing.T) {
+	first, err := passwordHash("Secret1x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ := passwordHash("Secret1x")
+	if first == second {
+		t.Errorf("Hashes of the same password are expected to differ: %s", first)
+	}
+
+	// Digest saved before the salted hashes
+	legacy := string(passwordDigest("Other1x"))
+
+	for password, used := range map[string]bool{"Secret1x": true, "Other1x": true, "Third1x": false} {
+		if list := checkPassword(&models.PasswordPolicy{}, password, []string{first, legacy}); (len(list) == 1) != used {
+			t.Errorf("Password %s used=%v, got %v", password, used, list)
+		}
+	}
+}
+
+func Test_PassphraseEntropy(t *testing.T) {
+	p, err := generatePassphrase(&models.PasswordPolicy{}, 5, " ")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(strings.Split(p.Password, " ")); l != 5 {
+		t.Errorf("Expecting 5 words, but got %d", l)
+	}
+
+	// log2(7776) * 5
+	if p.Entropy != 64.6 {
+		t.Errorf("Expecting 64.6 bits, but got %f", p.Entropy)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"mbmi-go/models"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Words loaded from the POLICYBANNED file
+var bannedWords = []string{}
+
+// defaultPolicy returns common password policy from the program flags
+func defaultPolicy() *models.PasswordPolicy {
+	return &models.PasswordPolicy{
+		MinLength: POLICYLENGTH,
+		Lower:     models.Boolean(strings.Contains(POLICYCLASSES, "l")),
+		Upper:     models.Boolean(strings.Contains(POLICYCLASSES, "u")),
+		Digits:    models.Boolean(strings.Contains(POLICYCLASSES, "d")),
+		Symbols:   models.Boolean(strings.Contains(POLICYCLASSES, "s")),
+		Banned:    []string{},
+		History:   POLICYHISTORY,
+		MaxAge:    POLICYMAXAGE,
+	}
+}
+
+// passwordPolicy returns domain policy if it was overridden, otherwise
+// common one
+func passwordPolicy(env Enviroment, domain uint) (p *models.PasswordPolicy, err error) {
+	var list []*models.PasswordPolicy
+
+	if domain > 0 {
+		flt := models.NewFilter().Where("domain", domain)
+
+		if list, _, err = env.PasswordPolicies(flt, false); err != nil {
+			return
+		}
+	}
+
+	if len(list) == 1 {
+		p = list[0]
+	} else {
+		p = defaultPolicy()
+	}
+
+	return
+}
+
+// checkPassword returns list of the policy violations, history
+// contains hashes of the previous passwords. Banned words from the
+// file are applied to any policy
+func checkPassword(p *models.PasswordPolicy, password string, history []string) (list []string) {
+	var lower, upper, digits, symbols bool
+
+	list = make([]string, 0)
+
+	if l := len([]rune(password)); l < p.MinLength {
+		list = append(list, "too short")
+	}
+
+	// Password is written to the colon separated passwd-file
+	if strings.IndexFunc(password, unicode.IsControl) >= 0 || strings.ContainsRune(password, ':') {
+		list = append(list, "control characters and colon are not allowed")
+	}
+
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digits = true
+		default:
+			symbols = true
+		}
+	}
+
+	if bool(p.Lower) && !lower {
+		list = append(list, "lower case letter required")
+	}
+
+	if bool(p.Upper) && !upper {
+		list = append(list, "upper case letter required")
+	}
+
+	if bool(p.Digits) && !digits {
+		list = append(list, "digit required")
+	}
+
+	if bool(p.Symbols) && !symbols {
+		list = append(list, "symbol required")
+	}
+
+	for _, w := range append(p.Banned, bannedWords...) {
+		if w != "" && strings.Contains(strings.ToLower(password), strings.ToLower(w)) {
+			list = append(list, "contains banned word")
+			break
+		}
+	}
+
+	for _, h := range history {
+		if passwordUsed(h, password) {
+			list = append(list, "was used before")
+			break
+		}
+	}
+
+	return
+}
+
+// validatePassword checks user password with domain policy and breached
+// passwords corpus, returns field errors if the password is not acceptable
+func validatePassword(env Enviroment, user *models.User) (fields map[string]string, p *models.PasswordPolicy, err error) {
+	var history []string
+
+	if p, err = passwordPolicy(env, user.Domain); err != nil {
+		return
+	}
+
+	if p.History > 0 && user.Id > 0 {
+		if history, err = env.PasswordHistory(user.Id, p.History); err != nil {
+			return
+		}
+	}
+
+	list := checkPassword(p, user.Password, history)
+
+	if breaches != nil {
+		var count int64
+
+		if count, err = breaches.Breached(user.Password); err != nil {
+			return
+		}
+
+		if count > 0 {
+			list = append(list, "found in known data breaches, choose another one")
+		}
+	}
+
+	if len(list) > 0 {
+		fields = map[string]string{
+			"password": strings.Join(list, ", "),
+		}
+	}
+
+	return
+}
+
+// passwordExpired returns true if the user must change the password
+// or the password is older than maximum age of the policy
+func passwordExpired(u *models.User, p *models.PasswordPolicy) bool {
+	if u.MustChange {
+		return true
+	}
+
+	if p == nil || p.MaxAge < 1 || u.PasswordChanged == nil {
+		return false
+	}
+
+	return time.Since(*u.PasswordChanged) > time.Duration(p.MaxAge)*24*time.Hour
+}
+
+// passwordDigest returns SHA-256 of the password. Bcrypt uses the first
+// 72 bytes only, so the digest is hashed instead of the password
+func passwordDigest(password string) []byte {
+	var sum = sha256.Sum256([]byte(password))
+
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+// passwordHash returns salted password hash to keep in the history
+func passwordHash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(passwordDigest(password), bcrypt.DefaultCost)
+
+	return string(hash), err
+}
+
+// passwordUsed compares password with the history hash, the rows saved
+// before bcrypt keep the plain digest
+func passwordUsed(hash, password string) bool {
+	if !strings.HasPrefix(hash, "$2") {
+		return hash == string(passwordDigest(password))
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), passwordDigest(password)) == nil
+}
+
+// loadBannedWords reads words list, one word per line
+func loadBannedWords(name string) (list []string, err error) {
+	var (
+		f  *os.File
+		sc *bufio.Scanner
+	)
+
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	list = make([]string, 0)
+	sc = bufio.NewScanner(f)
+
+	for sc.Scan() {
+		if w := strings.TrimSpace(sc.Text()); w != "" && !strings.HasPrefix(w, "#") {
+			list = append(list, w)
+		}
+	}
+
+	return list, sc.Err()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"strings"
+)
+
+// postfixLookup returns the map value of the key, empty value means
+// the key is not found
+type postfixLookup func(env Enviroment, key string) (string, error)
+
+// postfixMaps are the lookup tables served to Postfix
+var postfixMaps = map[string]postfixLookup{
+	"virtual_mailbox": lookupMailbox,
+	"virtual_alias":   lookupAlias,
+	"transport":       lookupTransport,
+	"sender_bcc":      lookupSenderBcc,
+	"recipient_bcc":   lookupRecipientBcc,
+	"client_access":   lookupClientAccess,
+}
+
+// lookupMailbox returns maildir path of the mailbox relative to the
+// virtual_mailbox_base, the trailing slash selects maildir format
+func lookupMailbox(env Enviroment, key string) (string, error) {
+	login, domain, err := models.Email(key).Split()
+	if err != nil || login == "" {
+		return "", nil
+	}
+
+	flt := models.NewFilter().
+		Where("login", login).
+		Where("domain", domain)
+
+	u, _, err := env.Users(flt, false)
+	if err != nil || len(u) == 0 {
+		return "", err
+	}
+
+	return mailboxPath(nil, u[0].Login, u[0].DomainName) + "/", nil
+}
+
+// lookupAlias returns comma separated recipients of the alias
+func lookupAlias(env Enviroment, key string) (string, error) {
+	var list []string
+
+	flt := models.NewFilter().
+		Where("alias", key).
+		Where("active", nil)
+
+	err := env.AliasesEach(flt, func(a *models.Alias) error {
+		list = append(list, string(a.Recipient))
+		return nil
+	})
+
+	return strings.Join(list, ","), err
+}
+
+// lookupTransport returns transport of the domain
+func lookupTransport(env Enviroment, key string) (string, error) {
+	t, _, err := env.Transports(models.NewFilter().Where("domain", key), false)
+	if err != nil || len(t) == 0 {
+		return "", err
+	}
+
+	return t[0].Transport, nil
+}
+
+// lookupSenderBcc returns copy address of the sender, Postfix
+// accepts only one address
+func lookupSenderBcc(env Enviroment, key string) (string, error) {
+	return lookupBcc(env, "sender_is", key)
+}
+
+// lookupRecipientBcc returns copy address of the recipient
+func lookupRecipientBcc(env Enviroment, key string) (string, error) {
+	return lookupBcc(env, "recipient_is", key)
+}
+
+func lookupBcc(env Enviroment, field, key string) (string, error) {
+	b, _, err := env.Bccs(models.NewFilter().Where(field, key).Limit(1, 0), false)
+	if err != nil || len(b) == 0 {
+		return "", err
+	}
+
+	return string(b[0].Copy), nil
+}
+
+// lookupClientAccess returns access action of the client host or
+// address, Postfix looks up parent domains and networks itself
+func lookupClientAccess(env Enviroment, key string) (string, error) {
+	a, _, err := env.Accesses(models.NewFilter().Where("client", key), false)
+	if err != nil || len(a) == 0 {
+		return "", err
+	}
+
+	return a[0].Access, nil
+}
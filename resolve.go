@@ -0,0 +1,342 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"strings"
+)
+
+const (
+	// Nested aliases limit, Postfix virtual_alias_recursion_limit
+	resolveDepth = 100
+	// Final recipients limit, Postfix virtual_alias_expansion_limit
+	resolveLimit = 1000
+)
+
+// Rules of the resolution tree nodes
+const (
+	ruleRecipient    = "recipient"
+	ruleAlias        = "alias"
+	ruleCatchAll     = "catch_all"
+	ruleAliasDomain  = "alias_domain"
+	ruleSenderBcc    = "sender_bcc"
+	ruleRecipientBcc = "recipient_bcc"
+)
+
+// ResolveNode is the address with the rule it was derived by. Leaf
+// nodes are delivered to the transport
+type ResolveNode struct {
+	Address   string         `json:"address"`
+	Rule      string         `json:"rule"`
+	Key       string         `json:"key,omitempty"`
+	Transport string         `json:"transport,omitempty"`
+	Mailbox   bool           `json:"mailbox"`
+	Error     string         `json:"error,omitempty"`
+	Path      []string       `json:"path,omitempty"`
+	Children  []*ResolveNode `json:"children,omitempty"`
+}
+
+// Delivery is the final recipient of the message
+type Delivery struct {
+	Address   string `json:"address"`
+	Transport string `json:"transport"`
+	Mailbox   bool   `json:"mailbox"`
+}
+
+// Resolution is the derivation of the message recipients the way
+// Postfix cleanup does: bcc maps are applied to the envelope, then
+// every address is expanded with the virtual aliases
+type Resolution struct {
+	Address    string         `json:"address"`
+	Sender     string         `json:"sender,omitempty"`
+	Tree       []*ResolveNode `json:"tree"`
+	Deliveries []*Delivery    `json:"deliveries"`
+}
+
+type resolver struct {
+	env        Enviroment
+	transports map[string]*models.Transport
+	leaves     int
+	// Alias being saved replaces its stored version
+	pending *models.Alias
+	// Only the alias graph is walked, leaves are not delivered
+	graph bool
+	// Addresses expanded in the graph mode
+	expanded map[string]bool
+	// Aliases filter of the validity period, the validation takes
+	// the aliases which are not active yet
+	period string
+}
+
+func newResolver(env Enviroment) *resolver {
+	return &resolver{
+		env:        env,
+		transports: make(map[string]*models.Transport),
+		expanded:   make(map[string]bool),
+		period:     "active",
+	}
+}
+
+// resolveAddress returns delivery tree of the recipient address
+func resolveAddress(env Enviroment, address, sender string) (res *Resolution, err error) {
+	var (
+		node *ResolveNode
+		copy string
+		key  string
+
+		rs = newResolver(env)
+	)
+
+	res = &Resolution{
+		Address:    address,
+		Sender:     sender,
+		Tree:       make([]*ResolveNode, 0),
+		Deliveries: make([]*Delivery, 0),
+	}
+
+	if node, err = rs.expand(address, ruleRecipient, "", nil); err != nil {
+		return nil, err
+	}
+
+	res.Tree = append(res.Tree, node)
+
+	if copy, key, err = rs.bcc("recipient_is", address); err != nil {
+		return nil, err
+	} else if copy != "" {
+		if node, err = rs.expand(copy, ruleRecipientBcc, key, nil); err != nil {
+			return nil, err
+		}
+
+		res.Tree = append(res.Tree, node)
+	}
+
+	if sender != "" {
+		if copy, key, err = rs.bcc("sender_is", sender); err != nil {
+			return nil, err
+		} else if copy != "" {
+			if node, err = rs.expand(copy, ruleSenderBcc, key, nil); err != nil {
+				return nil, err
+			}
+
+			res.Tree = append(res.Tree, node)
+		}
+	}
+
+	// Postfix removes duplicated recipients
+	var seen = make(map[string]bool)
+
+	for _, n := range res.Tree {
+		n.walk(func(leaf *ResolveNode) {
+			if leaf.Error != "" || seen[strings.ToLower(leaf.Address)] {
+				return
+			}
+
+			seen[strings.ToLower(leaf.Address)] = true
+
+			res.Deliveries = append(res.Deliveries, &Delivery{
+				Address:   leaf.Address,
+				Transport: leaf.Transport,
+				Mailbox:   leaf.Mailbox,
+			})
+		})
+	}
+
+	return
+}
+
+// walk calls fn for each leaf node
+func (n *ResolveNode) walk(fn func(*ResolveNode)) {
+	if len(n.Children) == 0 {
+		fn(n)
+		return
+	}
+
+	for _, c := range n.Children {
+		c.walk(fn)
+	}
+}
+
+// expand looks up the aliases of the address, the chain contains
+// addresses of the parent nodes to detect loops
+func (rs *resolver) expand(address, rule, key string, chain []string) (node *ResolveNode, err error) {
+	var (
+		list  []string
+		found string
+	)
+
+	node = &ResolveNode{Address: address, Rule: rule, Key: key}
+
+	for _, a := range chain {
+		if strings.EqualFold(a, address) {
+			node.Error = "alias loop"
+			node.Path = append(append([]string{}, chain...), address)
+			return
+		}
+	}
+
+	if len(chain) >= resolveDepth {
+		node.Error = "recursion limit exceeded"
+		return
+	}
+
+	// Loops under the address are already checked, so the graph
+	// is walked once whatever number of paths leads to the address
+	if rs.graph {
+		if rs.expanded[strings.ToLower(address)] {
+			return
+		}
+
+		rs.expanded[strings.ToLower(address)] = true
+	}
+
+	if list, found, err = rs.aliases(address); err != nil {
+		return
+	}
+
+	chain = append(chain, address)
+
+	for _, r := range list {
+		var (
+			child *ResolveNode
+			crule = ruleAlias
+		)
+
+		if found != address {
+			crule = ruleCatchAll
+		}
+
+		// @domain result keeps the user part
+		if strings.HasPrefix(r, "@") {
+			if login, _, err := models.Email(address).Split(); err == nil {
+				r = login + r
+				crule = ruleAliasDomain
+			}
+		}
+
+		// Alias to itself stops the expansion
+		if strings.EqualFold(r, address) {
+			child = &ResolveNode{Address: r, Rule: crule, Key: found}
+
+			if err = rs.deliver(child); err != nil {
+				return
+			}
+		} else if child, err = rs.expand(r, crule, found, chain); err != nil {
+			return
+		}
+
+		node.Children = append(node.Children, child)
+	}
+
+	if len(node.Children) == 0 {
+		err = rs.deliver(node)
+	}
+
+	return
+}
+
+// lookupKeys returns the address and its @domain, the order Postfix
+// looks up the virtual and bcc maps
+func lookupKeys(address string) []string {
+	var keys = []string{address}
+
+	if i := strings.LastIndexByte(address, '@'); i > 0 {
+		keys = append(keys, address[i:])
+	}
+
+	return keys
+}
+
+// bcc returns copy address of the sender or recipient, @domain is
+// looked up if the address has no own rule. Key is the matched rule
+func (rs *resolver) bcc(field, address string) (copy, key string, err error) {
+	for _, key = range lookupKeys(address) {
+		if copy, err = lookupBcc(rs.env, field, key); err != nil || copy != "" {
+			return
+		}
+	}
+
+	return "", "", nil
+}
+
+// aliases returns recipients of the address, catch-all @domain is
+// looked up if the address has no own aliases. Key is the matched alias
+func (rs *resolver) aliases(address string) (list []string, key string, err error) {
+	for _, key = range lookupKeys(address) {
+		flt := models.NewFilter().
+			Where("alias", key).
+			Where(rs.period, nil)
+
+		err = rs.env.AliasesEach(flt, func(a *models.Alias) error {
+			if rs.pending == nil || rs.pending.Id == 0 || rs.pending.Id != a.Id {
+				list = append(list, string(a.Recipient))
+			}
+
+			return nil
+		})
+
+		if rs.pending != nil && strings.EqualFold(string(rs.pending.Alias), key) {
+			list = append(list, string(rs.pending.Recipient))
+		}
+
+		if err != nil || len(list) > 0 {
+			return
+		}
+	}
+
+	return nil, "", nil
+}
+
+// deliver sets transport of the final recipient, local domains
+// require the mailbox
+func (rs *resolver) deliver(node *ResolveNode) error {
+	if rs.graph {
+		return nil
+	}
+
+	if rs.leaves++; rs.leaves > resolveLimit {
+		node.Error = "expansion limit exceeded"
+		return nil
+	}
+
+	login, domain, err := models.Email(node.Address).Split()
+	if err != nil {
+		node.Error = err.Error()
+		return nil
+	}
+
+	t, ok := rs.transports[domain]
+	if !ok {
+		list, _, err := rs.env.Transports(models.NewFilter().Where("domain", domain), false)
+		if err != nil {
+			return err
+		}
+
+		if len(list) > 0 {
+			t = list[0]
+		}
+
+		rs.transports[domain] = t
+	}
+
+	// Not local domain is relayed
+	if t == nil {
+		node.Transport = "relay"
+		return nil
+	}
+
+	node.Transport = t.Transport
+
+	flt := models.NewFilter().
+		Where("login", login).
+		Where("domain", domain)
+
+	u, _, err := rs.env.Users(flt, false)
+	if err != nil {
+		return err
+	}
+
+	if node.Mailbox = len(u) > 0; !node.Mailbox {
+		node.Error = "unknown mailbox"
+	}
+
+	return nil
+}
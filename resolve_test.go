@@ -0,0 +1,125 @@
+package main
+
+import (
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+)
+
+func Test_ResolveAddress(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := []string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}
+
+	// Alias domain to the user.net
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("info@alias.net").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("@alias.net").WillReturnRows(
+		sqlmock.NewRows(aliases).AddRow(1, "@alias.net", "@user.net", "", nil, nil))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("info@user.net").WillReturnRows(
+		sqlmock.NewRows(aliases).
+			AddRow(2, "info@user.net", "some@user.net", "", nil, nil).
+			AddRow(3, "info@user.net", "loop@user.net", "", nil, nil))
+
+	// Mailbox
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("some@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+domain").WithArgs("user.net").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "domain", "transport", "rootdir", "uid", "gid"}).
+			AddRow(1, "user.net", "lmtp:unix:private/dovecot-lmtp", "/var/mail", 8, 8))
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("some", "user.net").WillReturnRows(
+		sqlmock.NewRows(userMockColumns).
+			AddRow(userRow(1, "some", "user.net")...))
+
+	// Loop back to the info@user.net
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("loop@user.net").WillReturnRows(
+		sqlmock.NewRows(aliases).AddRow(4, "loop@user.net", "info@user.net", "", nil, nil))
+
+	// Bcc of the recipient and the sender domain
+	mock.ExpectQuery("^SELECT.+bcc.+recipient").WithArgs("info@alias.net", 0, 1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "sender", "recipient", "copy", "comment"}))
+	mock.ExpectQuery("^SELECT.+bcc.+recipient").WithArgs("@alias.net", 0, 1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "sender", "recipient", "copy", "comment"}))
+	mock.ExpectQuery("^SELECT.+bcc.+sender").WithArgs("boss@other.net", 0, 1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "sender", "recipient", "copy", "comment"}))
+	mock.ExpectQuery("^SELECT.+bcc.+sender").WithArgs("@other.net", 0, 1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "sender", "recipient", "copy", "comment"}).
+			AddRow(1, "@other.net", "", "archive@user.net", ""))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("archive@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+login.+domain").WithArgs("archive", "user.net").WillReturnRows(
+		sqlmock.NewRows(userMockColumns))
+
+	res, err := resolveAddress(env, "info@alias.net", "boss@other.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if len(res.Tree) != 2 {
+		t.Fatalf("Expected recipient and sender bcc trees, got %d", len(res.Tree))
+	}
+
+	domain := res.Tree[0].Children[0]
+	if domain.Address != "info@user.net" || domain.Rule != ruleAliasDomain || domain.Key != "@alias.net" {
+		t.Errorf("Unexpected alias domain node %+v", domain)
+	}
+
+	if loop := domain.Children[1].Children[0]; loop.Error != "alias loop" {
+		t.Errorf("Expected alias loop, got %+v", loop)
+	}
+
+	if bcc := res.Tree[1]; bcc.Rule != ruleSenderBcc || bcc.Key != "@other.net" || bcc.Error != "unknown mailbox" {
+		t.Errorf("Expected unknown sender bcc mailbox, got %+v", bcc)
+	}
+
+	if len(res.Deliveries) != 1 || res.Deliveries[0].Address != "some@user.net" ||
+		res.Deliveries[0].Transport != "lmtp:unix:private/dovecot-lmtp" || !res.Deliveries[0].Mailbox {
+		t.Errorf("Unexpected deliveries %+v", res.Deliveries)
+	}
+}
+
+func Test_ResolveGraphOnce(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := []string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}
+
+	// Both b and c lead to d, it is looked up once
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("a@user.net").WillReturnRows(
+		sqlmock.NewRows(aliases).
+			AddRow(1, "a@user.net", "b@user.net", "", nil, nil).
+			AddRow(2, "a@user.net", "c@user.net", "", nil, nil))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("b@user.net").WillReturnRows(
+		sqlmock.NewRows(aliases).AddRow(3, "b@user.net", "D@user.net", "", nil, nil))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("D@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("@user.net").WillReturnRows(sqlmock.NewRows(aliases))
+	mock.ExpectQuery("^SELECT.+aliases").WithArgs("c@user.net").WillReturnRows(
+		sqlmock.NewRows(aliases).AddRow(4, "c@user.net", "d@user.net", "", nil, nil))
+
+	rs := newResolver(env)
+	rs.graph = true
+
+	node, err := rs.expand("a@user.net", ruleAlias, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if len(node.Children) != 2 || node.Children[1].Children[0].Address != "d@user.net" {
+		t.Errorf("Unexpected graph %+v", node)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"net/http"
 )
 
 // ResponseIface represents interface to work with
@@ -23,9 +24,10 @@ type Response struct {
 // Error is a trivial implementation of error with
 // code, message and title to use in the response
 type Error struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-	Title   string `json:"title"`
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Title   string            `json:"title"`
+	Fields  map[string]string `json:"fields,omitempty"`
 }
 
 // NewResponse returns Response given a data
@@ -82,3 +84,83 @@ func (s *Response) Status() int {
 
 	return 200
 }
+
+// StreamIface represents response written to the client directly
+// without buffering of the whole data
+type StreamIface interface {
+	ResponseIface
+	Stream(http.ResponseWriter) error
+}
+
+// Stream is the successful response written by the callback
+type Stream struct {
+	ContentType string
+	Filename    string
+	Write       func(http.ResponseWriter) error
+}
+
+// Get is not used by the stream
+func (s *Stream) Get() ([]byte, error) {
+	return nil, nil
+}
+
+// Ok returns true, the error before the first written byte is sent by
+// Stream as the JSON response
+func (s *Stream) Ok() bool {
+	return true
+}
+
+// Status returns 200
+func (s *Stream) Status() int {
+	return 200
+}
+
+// Stream sends headers with the first written data, so the error
+// occurred before it is reported with the error status
+func (s *Stream) Stream(w http.ResponseWriter) (err error) {
+	var sw = &streamWriter{ResponseWriter: w, stream: s}
+
+	if err = s.Write(sw); err != nil && !sw.started {
+		data, _ := NewResponse(&Error{
+			Code:    500,
+			Message: "Cannot write data",
+			Title:   http.StatusText(500),
+		}).Get()
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(500)
+		w.Write(data)
+
+		return
+	}
+
+	sw.start()
+
+	return
+}
+
+// streamWriter delays the stream headers until the data is written
+type streamWriter struct {
+	http.ResponseWriter
+	stream  *Stream
+	started bool
+}
+
+func (s *streamWriter) start() {
+	if s.started {
+		return
+	}
+
+	s.started = true
+	s.Header().Set("Content-Type", s.stream.ContentType)
+
+	if s.stream.Filename != "" {
+		s.Header().Set("Content-Disposition", "attachment; filename=\""+s.stream.Filename+"\"")
+	}
+}
+
+func (s *streamWriter) Write(data []byte) (int, error) {
+	s.start()
+
+	return s.ResponseWriter.Write(data)
+}
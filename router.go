@@ -6,7 +6,9 @@ import (
 	"github.com/gorilla/schema"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
+	"reflect"
 	"strings"
+	"time"
 )
 
 // Shortcut
@@ -73,6 +75,28 @@ func parseFormTo(r *http.Request, v interface{}) (err error) {
 		return jsonDecoder.Decode(&v)
 	}
 
-	schemaDecoder = schema.NewDecoder()
+	schemaDecoder = formDecoder()
+
 	return schemaDecoder.Decode(v, r.PostForm)
 }
+
+// formDecoder returns decoder of the form values to the struct
+func formDecoder() *schema.Decoder {
+	var d = schema.NewDecoder()
+
+	d.RegisterConverter(time.Time{}, parseTimeValue)
+
+	return d
+}
+
+// parseTimeValue converts form value to time, date (YYYY-MM-DD)
+// or RFC3339 formats are accepted
+func parseTimeValue(str string) reflect.Value {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return reflect.ValueOf(t)
+		}
+	}
+
+	return reflect.Value{}
+}
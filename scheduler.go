@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Scheduler runs registered jobs periodically in the background
+type Scheduler struct {
+	log  LogIface
+	jobs []*schedulerJob
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type schedulerJob struct {
+	name  string
+	every time.Duration
+	fn    func() error
+}
+
+// NewScheduler returns scheduler without jobs
+func NewScheduler(log LogIface) *Scheduler {
+	return &Scheduler{
+		log:  log,
+		stop: make(chan struct{}),
+	}
+}
+
+// Every registers job, it should be called before Start
+func (s *Scheduler) Every(name string, every time.Duration, fn func() error) {
+	s.jobs = append(s.jobs, &schedulerJob{
+		name:  name,
+		every: every,
+		fn:    fn,
+	})
+}
+
+// Start runs each job immediately and then with its period
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+
+		go s.run(j)
+	}
+}
+
+// Stop waits while running jobs are finished
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(j *schedulerJob) {
+	var ticker = time.NewTicker(j.every)
+
+	defer func() {
+		ticker.Stop()
+		s.wg.Done()
+	}()
+
+	for {
+		s.log.Debug("Scheduler: run %s", j.name)
+
+		if err := j.fn(); err != nil {
+			s.log.Error("Scheduler: %s: %s", j.name, err.Error())
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
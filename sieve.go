@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mbmi-go/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// vacationScript is the name of the generated auto reply script
+	vacationScript = "vacation.sieve"
+	// sieveMainScript includes generated scripts, the active link
+	// points to it
+	sieveMainScript = "mbmi.sieve"
+)
+
+// errSieveActive is returned if the active script is not managed by us
+var errSieveActive = errors.New("Active sieve script is managed by user")
+
+// sieveQuote returns RFC 5228 quoted string
+func sieveQuote(str string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(str) + `"`
+}
+
+// sieveDate formats date for the currentdate test
+func sieveDate(t *time.Time) string {
+	return sieveQuote(t.Format("2006-01-02"))
+}
+
+// renderVacation returns RFC 5230 script for the auto reply. Date range
+// is also checked by the script with RFC 5260 currentdate test in case
+// the script stays active longer than expected
+func renderVacation(v *models.Vacation) []byte {
+	var (
+		b     strings.Builder
+		tests []string
+		args  []string
+		req   = []string{sieveQuote("vacation")}
+	)
+
+	if v.Start != nil {
+		tests = append(tests, `currentdate :value "ge" "date" `+sieveDate(v.Start))
+	}
+
+	if v.End != nil {
+		tests = append(tests, `currentdate :value "le" "date" `+sieveDate(v.End))
+	}
+
+	if len(tests) > 0 {
+		req = append(req, sieveQuote("date"), sieveQuote("relational"))
+	}
+
+	if v.Interval > 0 {
+		args = append(args, fmt.Sprintf(":days %d", v.Interval))
+	}
+
+	if v.Subject != "" {
+		args = append(args, ":subject "+sieveQuote(v.Subject))
+	}
+
+	if len(v.Addresses) > 0 {
+		var list = make([]string, len(v.Addresses))
+
+		for i, a := range v.Addresses {
+			list[i] = sieveQuote(a)
+		}
+
+		args = append(args, ":addresses ["+strings.Join(list, ", ")+"]")
+	}
+
+	args = append(args, sieveQuote(v.Body))
+
+	b.WriteString("# Generated by " + programName + ", do not edit\n")
+	b.WriteString("require [" + strings.Join(req, ", ") + "];\n\n")
+
+	if len(tests) == 0 {
+		b.WriteString("vacation " + strings.Join(args, " ") + ";\n")
+	} else {
+		b.WriteString("if allof(" + strings.Join(tests, ", ") + ") {\n")
+		b.WriteString("\tvacation " + strings.Join(args, " ") + ";\n")
+		b.WriteString("}\n")
+	}
+
+	return []byte(b.String())
+}
+
+// vacationOn checks if the auto reply should be active at the moment,
+// end date is inclusive
+func vacationOn(v *models.Vacation, now time.Time) bool {
+	if !v.Active {
+		return false
+	}
+
+	if v.Start != nil && now.Before(*v.Start) {
+		return false
+	}
+
+	if v.End != nil && !now.Before(v.End.AddDate(0, 0, 1)) {
+		return false
+	}
+
+	return true
+}
+
+// sieveLocation returns sieve scripts directory and active script link
+// of the mailbox
+func sieveLocation(mailbox string) (dir, active string) {
+	dir, active = SIEVEDIR, SIEVEACTIVE
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(mailbox, dir)
+	}
+
+	if !filepath.IsAbs(active) {
+		active = filepath.Join(mailbox, active)
+	}
+
+	return
+}
+
+// writeSieve replaces script in the directory, file owner is changed
+// to the mailbox owner if we can do it
+func writeSieve(dir, name string, data []byte, uid, gid uint) (err error) {
+	var (
+		tmp  *os.File
+		path = filepath.Join(dir, name)
+	)
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+
+	if old, err := ioutil.ReadFile(path); err == nil && string(old) == string(data) {
+		return nil
+	}
+
+	if tmp, err = ioutil.TempFile(dir, "."+name); err != nil {
+		return
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	if err = os.Chmod(tmp.Name(), 0600); err != nil {
+		return
+	}
+
+	if os.Geteuid() == 0 {
+		os.Chown(dir, int(uid), int(gid))
+
+		if err = os.Chown(tmp.Name(), int(uid), int(gid)); err != nil {
+			return
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// activateSieve points active link to the script. Script activated
+// by the user is not replaced
+func activateSieve(active, script string) (err error) {
+	var target string
+
+	if target, err = os.Readlink(active); err == nil {
+		if target == script || filepath.Join(filepath.Dir(active), target) == script {
+			return nil
+		}
+
+		return errSieveActive
+	}
+
+	if _, err = os.Lstat(active); err == nil {
+		return errSieveActive
+	}
+
+	if !os.IsNotExist(err) {
+		return
+	}
+
+	if rel, err := filepath.Rel(filepath.Dir(active), script); err == nil {
+		script = rel
+	}
+
+	return os.Symlink(script, active)
+}
+
+// deactivateSieve removes active link if it points to the script
+func deactivateSieve(active, script string) (err error) {
+	var target string
+
+	if target, err = os.Readlink(active); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		// Not a link, user script
+		if _, serr := os.Lstat(active); serr == nil {
+			return nil
+		}
+
+		return
+	}
+
+	if target != script && filepath.Join(filepath.Dir(active), target) != script {
+		return nil
+	}
+
+	return os.Remove(active)
+}
+
+// renderMain returns active script including the personal scripts
+func renderMain(includes []string) []byte {
+	var b strings.Builder
+
+	b.WriteString("# Generated by " + programName + ", do not edit\n")
+	b.WriteString("require [\"include\"];\n\n")
+
+	for _, i := range includes {
+		b.WriteString("include :personal " + sieveQuote(i) + ";\n")
+	}
+
+	return []byte(b.String())
+}
+
+// applySieve writes generated scripts of the user and points the active
+// link to the main script. Rules are included first, so the spam rule
+// with stop suppresses the auto reply. The auto reply is included only
+// in its date range
+func applySieve(u *models.User, t *models.Transport, v *models.Vacation, rules []*models.SieveRule, now time.Time) (err error) {
+	var (
+		includes []string
+		uid, gid = u.Uid, u.Gid
+
+		mailbox     = mailboxPath(t, u.Login, u.DomainName)
+		dir, active = sieveLocation(mailbox)
+		main        = filepath.Join(dir, sieveMainScript)
+	)
+
+	if uid == 0 {
+		uid, gid = t.Uid, t.Gid
+	}
+
+	if len(rules) > 0 {
+		if err = writeSieve(dir, sieveRulesScript, compileRules(rules), uid, gid); err != nil {
+			return
+		}
+
+		includes = append(includes, strings.TrimSuffix(sieveRulesScript, ".sieve"))
+	}
+
+	if v != nil {
+		if err = writeSieve(dir, vacationScript, renderVacation(v), uid, gid); err != nil {
+			return
+		}
+
+		if vacationOn(v, now) {
+			includes = append(includes, strings.TrimSuffix(vacationScript, ".sieve"))
+		}
+	}
+
+	if len(includes) == 0 {
+		return deactivateSieve(active, main)
+	}
+
+	if err = writeSieve(dir, sieveMainScript, renderMain(includes), uid, gid); err != nil {
+		return
+	}
+
+	return activateSieve(active, main)
+}
+
+// applyUserSieve loads auto reply and rules of the user and applies them
+func applyUserSieve(env Enviroment, u *models.User, t *models.Transport) (err error) {
+	var (
+		v     *models.Vacation
+		list  []*models.Vacation
+		rules []*models.SieveRule
+	)
+
+	if t == nil || t.Root == "" {
+		return
+	}
+
+	if list, _, err = env.Vacations(models.NewFilter().Where("uid", u.Id), false); err != nil {
+		return
+	}
+
+	if len(list) == 1 {
+		v = list[0]
+	}
+
+	if rules, err = env.SieveRules(u.Id); err != nil {
+		return
+	}
+
+	return applySieve(u, t, v, rules, time.Now())
+}
+
+// syncVacations applies scripts of the users with auto reply or rules,
+// it is called by the scheduler to switch the reply on the date range
+// bounds and to retry the scripts failed on save
+func syncVacations(env Enviroment) (err error) {
+	var (
+		list       []*models.Vacation
+		ruled      []int64
+		uids       []int64
+		transports []*models.Transport
+		seen       = make(map[int64]bool)
+		roots      = make(map[uint]*models.Transport)
+	)
+
+	if list, _, err = env.Vacations(nil, false); err != nil {
+		return
+	}
+
+	if ruled, err = env.SieveRuleUsers(); err != nil {
+		return
+	}
+
+	for _, v := range list {
+		ruled = append(ruled, v.UID)
+	}
+
+	for _, uid := range ruled {
+		if !seen[uid] {
+			seen[uid] = true
+			uids = append(uids, uid)
+		}
+	}
+
+	if len(uids) == 0 {
+		return
+	}
+
+	if transports, _, err = env.Transports(nil, false); err != nil {
+		return
+	}
+
+	for _, t := range transports {
+		roots[uint(t.Id)] = t
+	}
+
+	for _, uid := range uids {
+		var u []*models.User
+
+		if u, _, err = env.Users(models.NewFilter().Where("id", uid), false); err != nil {
+			return
+		}
+
+		if len(u) != 1 {
+			continue
+		}
+
+		if err := applyUserSieve(env, u[0], roots[u[0].Domain]); err != nil {
+			env.Warn("Cannot apply sieve scripts of %s: %s", u[0].Email, err.Error())
+		}
+	}
+
+	return nil
+}
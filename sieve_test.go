@@ -0,0 +1,169 @@
+package main
+
+import (
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"io/ioutil"
+	"mbmi-go/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_RenderVacation(t *testing.T) {
+	start := time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2024, 7, 14, 0, 0, 0, 0, time.Local)
+
+	script := string(renderVacation(&models.Vacation{
+		Active:    true,
+		Subject:   `Out of "office"`,
+		Body:      "Back soon",
+		Start:     &start,
+		End:       &end,
+		Interval:  7,
+		Addresses: []string{"alias@user.net"},
+	}))
+
+	for _, str := range []string{
+		`require ["vacation", "date", "relational"];`,
+		`currentdate :value "ge" "date" "2024-07-01"`,
+		`currentdate :value "le" "date" "2024-07-14"`,
+		`vacation :days 7 :subject "Out of \"office\"" :addresses ["alias@user.net"] "Back soon";`,
+	} {
+		if !strings.Contains(script, str) {
+			t.Errorf("Expecting %s in script:\n%s", str, script)
+		}
+	}
+
+	script = string(renderVacation(&models.Vacation{Body: "Away"}))
+
+	if strings.Contains(script, "currentdate") || !strings.Contains(script, `vacation "Away";`) {
+		t.Errorf("Unexpected script without dates:\n%s", script)
+	}
+}
+
+func Test_VacationOn(t *testing.T) {
+	start := time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2024, 7, 14, 0, 0, 0, 0, time.Local)
+	v := &models.Vacation{Active: true, Start: &start, End: &end}
+
+	for now, on := range map[time.Time]bool{
+		start.Add(-time.Hour):   false,
+		start:                   true,
+		end.Add(23 * time.Hour): true,
+		end.Add(25 * time.Hour): false,
+	} {
+		if vacationOn(v, now) != on {
+			t.Errorf("Expecting %v at %s", on, now)
+		}
+	}
+
+	v.Active = false
+
+	if vacationOn(v, start) {
+		t.Error("Inactive vacation is on")
+	}
+}
+
+func Test_ApplySieve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbmi-sieve")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var (
+		now    = time.Now()
+		user   = &models.User{Login: "some", DomainName: "user.net"}
+		tr     = &models.Transport{Root: dir}
+		v      = &models.Vacation{Active: true, Body: "Away"}
+		active = filepath.Join(dir, "user.net", "some", ".dovecot.sieve")
+		main   = filepath.Join(dir, "user.net", "some", "sieve", sieveMainScript)
+	)
+
+	if err = applySieve(user, tr, v, nil, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, err := os.Readlink(active); err != nil || target != filepath.Join("sieve", sieveMainScript) {
+		t.Errorf("Expecting active link to the main script, but got %s, %v", target, err)
+	}
+
+	if data, _ := ioutil.ReadFile(main); !strings.Contains(string(data), `include :personal "vacation";`) {
+		t.Errorf("Expecting vacation included, but got:\n%s", data)
+	}
+
+	v.Active = false
+
+	if err = applySieve(user, tr, v, nil, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Lstat(active); !os.IsNotExist(err) {
+		t.Errorf("Expecting active link removed, but got %v", err)
+	}
+
+	// Rules stay active without auto reply
+	rules := []*models.SieveRule{{Field: "subject", Match: "contains", Value: "news", Action: "discard"}}
+
+	if err = applySieve(user, tr, v, rules, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadFile(main); !strings.Contains(string(data), `include :personal "rules";`) ||
+		strings.Contains(string(data), "vacation") {
+		t.Errorf("Expecting rules only, but got:\n%s", data)
+	}
+
+	// Script activated by the user is kept
+	os.Remove(active)
+
+	if err = ioutil.WriteFile(active, []byte("keep;"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = applySieve(user, tr, v, rules, now); err != errSieveActive {
+		t.Errorf("Expecting errSieveActive, but got %v", err)
+	}
+}
+
+func Test_GeneratedScriptsAreValid(t *testing.T) {
+	start := time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local)
+
+	for _, script := range [][]byte{
+		renderVacation(&models.Vacation{Body: "Away\n\"quoted\"", Start: &start, Interval: 3}),
+		renderMain([]string{"rules", "vacation"}),
+	} {
+		if err := validateSieve(string(script)); err != nil {
+			t.Errorf("%s:\n%s", err, script)
+		}
+	}
+}
+
+func Test_SyncVacationsWithRules(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// User with rules only is synced as well, the empty root skips the script
+	mock.ExpectQuery("^SELECT.+vacation").WillReturnRows(sqlmock.NewRows([]string{"uid"}))
+	mock.ExpectQuery("^SELECT DISTINCT `uid` FROM `sieve_rule`").WillReturnRows(sqlmock.NewRows([]string{"uid"}).AddRow(1))
+	mock.ExpectQuery("^SELECT.+transport").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "domain", "transport", "rootdir", "uid", "gid"}).
+			AddRow(1, "user.net", "virtual", "", 8, 8))
+	mock.ExpectQuery("^SELECT.+users.+WHERE.+id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows(userMockColumns).AddRow(userRow(1, "some", "user.net")...))
+
+	if err := syncVacations(env); err != nil {
+		t.Error(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sieve token kinds
+const (
+	sieveIdent = iota
+	sieveTag
+	sieveNumber
+	sieveString
+	sievePunct
+	sieveEOF
+)
+
+type sieveToken struct {
+	kind    int
+	text    string
+	line    int
+	comment string
+}
+
+// sieveNode is command or test of the RFC 5228 script
+type sieveNode struct {
+	Name    string
+	Comment string
+	Line    int
+	Args    []*sieveArg
+	Tests   []*sieveNode
+	Block   []*sieveNode
+
+	block bool
+}
+
+// sieveArg is tag, number or string list argument
+type sieveArg struct {
+	Tag     string
+	Number  string
+	Strings []string
+}
+
+// sieveCommands are known commands and extensions they require
+var sieveCommands = map[string]string{
+	"require":  "",
+	"if":       "",
+	"elsif":    "",
+	"else":     "",
+	"stop":     "",
+	"keep":     "",
+	"discard":  "",
+	"redirect": "",
+	"fileinto": "fileinto",
+	"reject":   "reject",
+	"vacation": "vacation",
+	"include":  "include",
+	"return":   "include",
+}
+
+// sieveTests are known tests and extensions they require
+var sieveTests = map[string]string{
+	"address":     "",
+	"header":      "",
+	"exists":      "",
+	"size":        "",
+	"allof":       "",
+	"anyof":       "",
+	"not":         "",
+	"true":        "",
+	"false":       "",
+	"envelope":    "envelope",
+	"date":        "date",
+	"currentdate": "date",
+}
+
+// sieveTags are tags provided by extensions
+var sieveTags = map[string]string{
+	"copy":  "copy",
+	"value": "relational",
+	"count": "relational",
+}
+
+// lexSieve splits script to tokens, hash comment is attached
+// to the next token
+func lexSieve(src string) (toks []sieveToken, err error) {
+	var (
+		line    = 1
+		comment string
+	)
+
+	push := func(kind int, text string) {
+		toks = append(toks, sieveToken{kind: kind, text: text, line: line, comment: comment})
+		comment = ""
+	}
+
+	for i := 0; i < len(src); {
+		var c = src[i]
+
+		switch {
+		case c == '\n':
+			line++
+			i++
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case c == '#':
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+
+			comment = strings.TrimSpace(src[i+1 : i+end])
+			i += end
+
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+
+		case c == '"':
+			var b strings.Builder
+
+			start := line
+			i++
+
+			for ; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+
+				if src[i] == '\n' {
+					line++
+				}
+
+				b.WriteByte(src[i])
+			}
+
+			if i >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated string", start)
+			}
+
+			i++
+			push(sieveString, b.String())
+
+		case strings.HasPrefix(src[i:], "text:"):
+			var b strings.Builder
+
+			start := line
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated text", start)
+			}
+
+			i += end + 1
+			line++
+
+			for {
+				end = strings.IndexByte(src[i:], '\n')
+				if end < 0 {
+					return nil, fmt.Errorf("line %d: unterminated text", start)
+				}
+
+				str := strings.TrimSuffix(src[i:i+end], "\r")
+				i += end + 1
+				line++
+
+				if str == "." {
+					break
+				}
+
+				b.WriteString(strings.TrimPrefix(str, ".") + "\n")
+			}
+
+			push(sieveString, b.String())
+
+		case c == ':' || isSieveIdent(c, true):
+			var kind = sieveIdent
+
+			if c == ':' {
+				kind = sieveTag
+				i++
+			}
+
+			start := i
+			for i < len(src) && isSieveIdent(src[i], i == start) {
+				i++
+			}
+
+			if start == i {
+				return nil, fmt.Errorf("line %d: empty tag", line)
+			}
+
+			push(kind, src[start:i])
+
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+				i++
+			}
+
+			if i < len(src) && strings.IndexByte("KMG", src[i]) >= 0 {
+				i++
+			}
+
+			push(sieveNumber, src[start:i])
+
+		case strings.IndexByte("[](){},;", c) >= 0:
+			push(sievePunct, string(c))
+			i++
+
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+		}
+	}
+
+	push(sieveEOF, "")
+
+	return
+}
+
+func isSieveIdent(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(!first && c >= '0' && c <= '9')
+}
+
+type sieveParser struct {
+	toks []sieveToken
+	pos  int
+}
+
+// parseSieve returns commands tree of the script
+func parseSieve(src string) (nodes []*sieveNode, err error) {
+	var p = &sieveParser{}
+
+	if p.toks, err = lexSieve(src); err != nil {
+		return
+	}
+
+	if nodes, err = p.commands(); err != nil {
+		return
+	}
+
+	if t := p.peek(); t.kind != sieveEOF {
+		return nil, fmt.Errorf("line %d: unexpected %q", t.line, t.text)
+	}
+
+	return
+}
+
+func (p *sieveParser) peek() sieveToken {
+	return p.toks[p.pos]
+}
+
+func (p *sieveParser) next() sieveToken {
+	var t = p.toks[p.pos]
+
+	if t.kind != sieveEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *sieveParser) is(text string) bool {
+	var t = p.peek()
+
+	return t.kind == sievePunct && t.text == text
+}
+
+func (p *sieveParser) expect(text string) error {
+	if t := p.next(); t.kind != sievePunct || t.text != text {
+		return fmt.Errorf("line %d: expecting %q, but got %q", t.line, text, t.text)
+	}
+
+	return nil
+}
+
+func (p *sieveParser) commands() (nodes []*sieveNode, err error) {
+	for p.peek().kind == sieveIdent {
+		var n *sieveNode
+
+		if n, err = p.command(); err != nil {
+			return
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return
+}
+
+func (p *sieveParser) command() (n *sieveNode, err error) {
+	var t = p.next()
+
+	n = &sieveNode{Name: strings.ToLower(t.text), Comment: t.comment, Line: t.line}
+
+	if err = p.arguments(n); err != nil {
+		return
+	}
+
+	if p.is(";") {
+		p.next()
+		return
+	}
+
+	if err = p.expect("{"); err != nil {
+		return
+	}
+
+	n.block = true
+
+	if n.Block, err = p.commands(); err != nil {
+		return
+	}
+
+	return n, p.expect("}")
+}
+
+func (p *sieveParser) arguments(n *sieveNode) (err error) {
+	for {
+		var t = p.peek()
+
+		switch {
+		case t.kind == sieveTag:
+			n.Args = append(n.Args, &sieveArg{Tag: strings.ToLower(p.next().text)})
+
+		case t.kind == sieveNumber:
+			n.Args = append(n.Args, &sieveArg{Number: p.next().text})
+
+		case t.kind == sieveString:
+			n.Args = append(n.Args, &sieveArg{Strings: []string{p.next().text}})
+
+		case p.is("["):
+			var a = &sieveArg{Strings: []string{}}
+
+			p.next()
+
+			for {
+				if t = p.next(); t.kind != sieveString {
+					return fmt.Errorf("line %d: expecting string in list, but got %q", t.line, t.text)
+				}
+
+				a.Strings = append(a.Strings, t.text)
+
+				if !p.is(",") {
+					break
+				}
+
+				p.next()
+			}
+
+			if err = p.expect("]"); err != nil {
+				return
+			}
+
+			n.Args = append(n.Args, a)
+
+		default:
+			return p.tests(n)
+		}
+	}
+}
+
+func (p *sieveParser) tests(n *sieveNode) (err error) {
+	var test *sieveNode
+
+	if p.peek().kind == sieveIdent {
+		if test, err = p.test(); err != nil {
+			return
+		}
+
+		n.Tests = append(n.Tests, test)
+
+		return
+	}
+
+	if !p.is("(") {
+		return
+	}
+
+	p.next()
+
+	for {
+		if test, err = p.test(); err != nil {
+			return
+		}
+
+		n.Tests = append(n.Tests, test)
+
+		if !p.is(",") {
+			break
+		}
+
+		p.next()
+	}
+
+	return p.expect(")")
+}
+
+func (p *sieveParser) test() (n *sieveNode, err error) {
+	var t = p.next()
+
+	if t.kind != sieveIdent {
+		return nil, fmt.Errorf("line %d: expecting test, but got %q", t.line, t.text)
+	}
+
+	n = &sieveNode{Name: strings.ToLower(t.text), Line: t.line}
+
+	return n, p.arguments(n)
+}
+
+// validateSieve checks script syntax, known commands and tests
+// and extensions they require
+func validateSieve(src string) (err error) {
+	var (
+		nodes []*sieveNode
+		caps  = make(map[string]bool)
+	)
+
+	if nodes, err = parseSieve(src); err != nil {
+		return
+	}
+
+	for i, n := range nodes {
+		if n.Name != "require" {
+			break
+		}
+
+		if n.block || len(n.Tests) > 0 || len(n.Args) != 1 || n.Args[0].Strings == nil {
+			return fmt.Errorf("line %d: require expects string list", n.Line)
+		}
+
+		for _, c := range n.Args[0].Strings {
+			caps[c] = true
+		}
+
+		nodes[i] = nil
+	}
+
+	return validateSieveCommands(nodes, caps)
+}
+
+func validateSieveCommands(nodes []*sieveNode, caps map[string]bool) (err error) {
+	var prev string
+
+	for _, n := range nodes {
+		if n == nil {
+			continue
+		}
+
+		ext, ok := sieveCommands[n.Name]
+
+		switch {
+		case !ok:
+			return fmt.Errorf("line %d: unknown command %s", n.Line, n.Name)
+
+		case ext != "" && !caps[ext]:
+			return fmt.Errorf("line %d: %s requires %q extension", n.Line, n.Name, ext)
+
+		case n.Name == "require":
+			return fmt.Errorf("line %d: require must be at the beginning", n.Line)
+
+		case (n.Name == "elsif" || n.Name == "else") && prev != "if" && prev != "elsif":
+			return fmt.Errorf("line %d: %s without if", n.Line, n.Name)
+
+		case (n.Name == "if" || n.Name == "elsif") && (len(n.Tests) != 1 || !n.block):
+			return fmt.Errorf("line %d: %s expects test and block", n.Line, n.Name)
+
+		case n.Name == "else" && (len(n.Tests) != 0 || !n.block):
+			return fmt.Errorf("line %d: else expects block", n.Line)
+
+		case n.block && n.Name != "if" && n.Name != "elsif" && n.Name != "else":
+			return fmt.Errorf("line %d: %s can't have block", n.Line, n.Name)
+		}
+
+		if err = validateSieveArgs(n, caps); err != nil {
+			return
+		}
+
+		if err = validateSieveTests(n.Tests, caps); err != nil {
+			return
+		}
+
+		if err = validateSieveCommands(n.Block, caps); err != nil {
+			return
+		}
+
+		prev = n.Name
+	}
+
+	return
+}
+
+func validateSieveTests(tests []*sieveNode, caps map[string]bool) (err error) {
+	for _, t := range tests {
+		ext, ok := sieveTests[t.Name]
+
+		if !ok {
+			return fmt.Errorf("line %d: unknown test %s", t.Line, t.Name)
+		}
+
+		if ext != "" && !caps[ext] {
+			return fmt.Errorf("line %d: %s requires %q extension", t.Line, t.Name, ext)
+		}
+
+		if err = validateSieveArgs(t, caps); err != nil {
+			return
+		}
+
+		if err = validateSieveTests(t.Tests, caps); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func validateSieveArgs(n *sieveNode, caps map[string]bool) error {
+	for _, a := range n.Args {
+		if ext := sieveTags[a.Tag]; ext != "" && !caps[ext] {
+			return fmt.Errorf("line %d: :%s requires %q extension", n.Line, a.Tag, ext)
+		}
+	}
+
+	return nil
+}
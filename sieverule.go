@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mbmi-go/models"
+	"strconv"
+	"strings"
+)
+
+// sieveRulesScript is the name of the generated filter rules script
+const sieveRulesScript = "rules.sieve"
+
+// spamHeader is added by SpamAssassin, each star is one point of the score
+const spamHeader = "X-Spam-Level"
+
+// sieveFields maps rule condition to the test and its header
+var sieveFields = map[string][2]string{
+	"from":          {"address", "From"},
+	"to":            {"address", "To"},
+	"cc":            {"address", "Cc"},
+	"subject":       {"header", "Subject"},
+	"header":        {"header", ""},
+	"envelope_from": {"envelope", "from"},
+	"envelope_to":   {"envelope", "to"},
+	"spam":          {"header", spamHeader},
+}
+
+// errSieveNotGenerated is returned by the read back of foreign script
+var errSieveNotGenerated = errors.New("Script was not generated by rules builder")
+
+// validateRule returns field errors of the rule
+func validateRule(r *models.SieveRule) map[string]string {
+	var fields = make(map[string]string)
+
+	if _, ok := sieveFields[r.Field]; !ok {
+		fields["field"] = "unknown condition " + r.Field
+	}
+
+	if r.Field == "header" && (r.Header == "" || strings.ContainsAny(r.Header, ": \t\r\n")) {
+		fields["header"] = "invalid header name"
+	}
+
+	switch r.Match {
+	case "is", "contains", "matches":
+	case "":
+		r.Match = "contains"
+	default:
+		fields["match"] = "unknown match type " + r.Match
+	}
+
+	if r.Field == "spam" {
+		if n, err := strconv.Atoi(r.Value); err != nil || n < 1 || n > 50 {
+			fields["value"] = "spam score must be between 1 and 50"
+		}
+	} else if r.Value == "" {
+		fields["value"] = "required"
+	}
+
+	switch r.Action {
+	case "fileinto", "reject":
+		if r.Target == "" {
+			fields["target"] = "required"
+		}
+
+	case "redirect":
+		if _, _, err := models.Email(r.Target).Split(); err != nil {
+			fields["target"] = "invalid address"
+		}
+
+	case "discard", "keep":
+
+	default:
+		fields["action"] = "unknown action " + r.Action
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return fields
+}
+
+// compileRules returns script of the rules, every rule must be valid
+func compileRules(rules []*models.SieveRule) []byte {
+	var (
+		b    strings.Builder
+		body strings.Builder
+		caps = make(map[string]bool)
+		req  []string
+	)
+
+	for _, r := range rules {
+		var (
+			field = sieveFields[r.Field]
+			name  = field[1]
+			value = r.Value
+			match = ":" + r.Match
+		)
+
+		switch r.Field {
+		case "header":
+			name = r.Header
+
+		case "spam":
+			n, _ := strconv.Atoi(r.Value)
+			value, match = strings.Repeat("*", n), ":contains"
+		}
+
+		if field[0] == "envelope" {
+			caps["envelope"] = true
+		}
+
+		body.WriteString("\n# rule: " + strings.NewReplacer("\r", " ", "\n", " ").Replace(r.Name) + "\n")
+		body.WriteString(fmt.Sprintf("if %s %s %s %s {\n", field[0], match, sieveQuote(name), sieveQuote(value)))
+
+		switch r.Action {
+		case "fileinto", "reject":
+			caps[r.Action] = true
+			body.WriteString("\t" + r.Action + " " + sieveQuote(r.Target) + ";\n")
+
+		case "redirect":
+			if r.Copy {
+				caps["copy"] = true
+				body.WriteString("\tredirect :copy " + sieveQuote(r.Target) + ";\n")
+			} else {
+				body.WriteString("\tredirect " + sieveQuote(r.Target) + ";\n")
+			}
+
+		default:
+			body.WriteString("\t" + r.Action + ";\n")
+		}
+
+		if r.Stop {
+			body.WriteString("\tstop;\n")
+		}
+
+		body.WriteString("}\n")
+	}
+
+	for _, c := range []string{"fileinto", "reject", "envelope", "copy"} {
+		if caps[c] {
+			req = append(req, sieveQuote(c))
+		}
+	}
+
+	b.WriteString("# Generated by " + programName + ", do not edit\n")
+
+	if len(req) > 0 {
+		b.WriteString("require [" + strings.Join(req, ", ") + "];\n")
+	}
+
+	b.WriteString(body.String())
+
+	return []byte(b.String())
+}
+
+// readRules parses script generated by compileRules back to the rules
+func readRules(src string) (rules []*models.SieveRule, err error) {
+	var nodes []*sieveNode
+
+	if err = validateSieve(src); err != nil {
+		return
+	}
+
+	if nodes, err = parseSieve(src); err != nil {
+		return
+	}
+
+	rules = make([]*models.SieveRule, 0)
+
+	for _, n := range nodes {
+		var r *models.SieveRule
+
+		if n.Name == "require" {
+			continue
+		}
+
+		if n.Name != "if" {
+			return nil, errSieveNotGenerated
+		}
+
+		if r, err = readRule(n); err != nil {
+			return
+		}
+
+		rules = append(rules, r)
+	}
+
+	return
+}
+
+func readRule(n *sieveNode) (r *models.SieveRule, err error) {
+	var (
+		test = n.Tests[0]
+		strs [][]string
+	)
+
+	r = &models.SieveRule{
+		Name:  strings.TrimPrefix(n.Comment, "rule: "),
+		Match: "is",
+	}
+
+	for _, a := range test.Args {
+		switch {
+		case a.Tag == "is" || a.Tag == "contains" || a.Tag == "matches":
+			r.Match = a.Tag
+
+		case a.Strings != nil && len(a.Strings) == 1:
+			strs = append(strs, a.Strings)
+
+		default:
+			return nil, errSieveNotGenerated
+		}
+	}
+
+	if len(strs) != 2 {
+		return nil, errSieveNotGenerated
+	}
+
+	r.Value = strs[1][0]
+
+	for f, v := range sieveFields {
+		if v[0] == test.Name && strings.EqualFold(v[1], strs[0][0]) {
+			r.Field = f
+		}
+	}
+
+	switch {
+	case r.Field == "spam":
+		if strings.Trim(r.Value, "*") != "" {
+			return nil, errSieveNotGenerated
+		}
+
+		r.Value = strconv.Itoa(len(r.Value))
+
+	case r.Field == "" && test.Name == "header":
+		r.Field, r.Header = "header", strs[0][0]
+
+	case r.Field == "":
+		return nil, errSieveNotGenerated
+	}
+
+	for _, c := range n.Block {
+		switch c.Name {
+		case "stop":
+			r.Stop = true
+
+		case "fileinto", "reject", "redirect", "discard", "keep":
+			if r.Action != "" {
+				return nil, errSieveNotGenerated
+			}
+
+			r.Action = c.Name
+
+			for _, a := range c.Args {
+				if a.Tag == "copy" {
+					r.Copy = true
+				} else if len(a.Strings) == 1 {
+					r.Target = a.Strings[0]
+				}
+			}
+
+		default:
+			return nil, errSieveNotGenerated
+		}
+	}
+
+	if r.Action == "" {
+		return nil, errSieveNotGenerated
+	}
+
+	return
+}
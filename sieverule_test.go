@@ -0,0 +1,75 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_CompileAndReadRules(t *testing.T) {
+	rules := []*models.SieveRule{
+		{Name: "spam", Field: "spam", Match: "contains", Value: "5", Action: "discard", Stop: true},
+		{Name: "boss", Field: "from", Match: "is", Value: "boss@user.net", Action: "fileinto", Target: "Work/\"Boss\""},
+		{Name: "list", Field: "header", Header: "List-Id", Match: "contains", Value: "golang", Action: "fileinto", Target: "Lists"},
+		{Name: "copy", Field: "envelope_to", Match: "matches", Value: "*@user.net", Action: "redirect", Target: "me@other.net", Copy: true},
+		{Name: "no", Field: "subject", Match: "contains", Value: "viagra", Action: "reject", Target: "Go away"},
+	}
+
+	for _, r := range rules {
+		if f := validateRule(r); f != nil {
+			t.Fatalf("Unexpected rule errors %v", f)
+		}
+	}
+
+	script := string(compileRules(rules))
+
+	if !strings.Contains(script, `require ["fileinto", "reject", "envelope", "copy"];`) {
+		t.Errorf("Unexpected require:\n%s", script)
+	}
+
+	if err := validateSieve(script); err != nil {
+		t.Fatalf("%s:\n%s", err, script)
+	}
+
+	back, err := readRules(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(rules, back) {
+		for i := range back {
+			t.Errorf("Expecting %#v, but got %#v", rules[i], back[i])
+		}
+	}
+}
+
+func Test_ValidateSieve(t *testing.T) {
+	for script, valid := range map[string]bool{
+		`require "fileinto"; if header :contains "Subject" "x" { fileinto "X"; }`: true,
+		`if header :contains "Subject" "x" { fileinto "X"; }`:                     false,
+		`if header :contains "Subject" "x" { keep; } else { discard; }`:           true,
+		`else { discard; }`:                                       false,
+		`keep; require "fileinto";`:                               false,
+		`redirect :copy "a@b.c";`:                                 false,
+		`if header :contains "Subject" "x { keep; }`:              false,
+		`if true { keep; `:                                        false,
+		"# comment\n/* block\ncomment */ keep;":                   true,
+		"require \"vacation\"; vacation text:\nAway\n..dot\n.\n;": true,
+		`unknown;`: false,
+	} {
+		if err := validateSieve(script); (err == nil) != valid {
+			t.Errorf("Expecting valid=%v for %q, but got %v", valid, script, err)
+		}
+	}
+}
+
+func Test_ValidateRule(t *testing.T) {
+	f := validateRule(&models.SieveRule{Field: "spam", Value: "100", Action: "redirect", Target: "nobody"})
+
+	for _, name := range []string{"value", "target"} {
+		if _, ok := f[name]; !ok {
+			t.Errorf("Expecting %s error, but got %v", name, f)
+		}
+	}
+}
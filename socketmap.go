@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Postfix limits socketmap request and reply size
+	socketmapMaxSize = 100000
+	// Digits of socketmapMaxSize in the netstring length
+	netstringHeadSize = 6
+	// Postfix closes idle connections after ipc_idle (5s) and
+	// max_idle (100s), so the longer idle ones are dropped
+	socketmapIdle = 120 * time.Second
+)
+
+var errNetstring = errors.New("Invalid netstring")
+
+// SocketmapServer serves postfixMaps with the Postfix socketmap protocol
+type SocketmapServer struct {
+	env Enviroment
+}
+
+// NewSocketmapServer returns socketmap server with the maps from env
+func NewSocketmapServer(env Enviroment) *SocketmapServer {
+	return &SocketmapServer{env: env}
+}
+
+// listenAddress opens unix socket for the unix:/path address, otherwise
+// TCP host:port is expected. Stale unix socket file is removed
+func listenAddress(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", strings.TrimPrefix(address, "tcp:"))
+}
+
+// ListenAndServe listens the address and serves connections
+func (s *SocketmapServer) ListenAndServe(address string) error {
+	l, err := listenAddress(address)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections, each one is served in own goroutine
+func (s *SocketmapServer) Serve(l net.Listener) error {
+	return acceptLoop(l, s.serveConn)
+}
+
+// acceptLoop calls fn in own goroutine for each accepted connection
+func acceptLoop(l net.Listener, fn func(net.Conn)) error {
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+
+			return err
+		}
+
+		go fn(conn)
+	}
+}
+
+// serveConn answers requests until the client closes connection
+func (s *SocketmapServer) serveConn(conn net.Conn) {
+	var (
+		r  = bufio.NewReader(conn)
+		id = RandStringId(12)
+	)
+
+	defer conn.Close()
+
+	s.env.Debug("%s: socketmap connection from %s", id, conn.RemoteAddr())
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(socketmapIdle))
+
+		req, err := readNetstring(r)
+		if err != nil {
+			if err != io.EOF {
+				s.env.Debug("%s: %s", id, err.Error())
+			}
+
+			return
+		}
+
+		if err = writeNetstring(conn, s.lookup(id, string(req))); err != nil {
+			s.env.Debug("%s: %s", id, err.Error())
+			return
+		}
+	}
+}
+
+// lookup answers "name key" request
+func (s *SocketmapServer) lookup(id interface{}, req string) string {
+	var (
+		name, key string
+		value     string
+		err       error
+	)
+
+	if i := strings.IndexByte(req, ' '); i > 0 {
+		name, key = req[:i], req[i+1:]
+	}
+
+	fn, ok := postfixMaps[name]
+	if !ok {
+		return "PERM Unknown map " + name
+	}
+
+	if value, err = fn(s.env, key); err != nil {
+		s.env.Error("%s: socketmap %s %s: %s", id, name, key, err.Error())
+
+		return "TEMP Lookup failed"
+	}
+
+	s.env.Debug("%s: socketmap %s %s = %q", id, name, key, value)
+
+	if value == "" {
+		return "NOTFOUND "
+	}
+
+	if len(value) > socketmapMaxSize-3 {
+		return "PERM Result too long"
+	}
+
+	return "OK " + value
+}
+
+// readNetstring reads "length:data," record. The length is read byte
+// by byte, so the endless header is not buffered
+func readNetstring(r *bufio.Reader) ([]byte, error) {
+	var (
+		size int
+		head = make([]byte, 0, netstringHeadSize)
+	)
+
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(head) == 0 {
+				return nil, io.EOF
+			}
+
+			return nil, err
+		}
+
+		if c == ':' {
+			break
+		}
+
+		if c < '0' || c > '9' || len(head) == netstringHeadSize {
+			return nil, errNetstring
+		}
+
+		head = append(head, c)
+	}
+
+	size, err := strconv.Atoi(string(head))
+	if err != nil || size > socketmapMaxSize {
+		return nil, errNetstring
+	}
+
+	data := make([]byte, size+1)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	if data[size] != ',' {
+		return nil, errNetstring
+	}
+
+	return data[:size], nil
+}
+
+func writeNetstring(w io.Writer, data string) error {
+	_, err := fmt.Fprintf(w, "%d:%s,", len(data), data)
+
+	return err
+}
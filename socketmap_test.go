@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_Netstring(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("5:hello,0:,3:abc;"))
+
+	for _, want := range []string{"hello", ""} {
+		if data, err := readNetstring(r); err != nil || string(data) != want {
+			t.Errorf("Expected %q, got %q (%v)", want, data, err)
+		}
+	}
+
+	if _, err := readNetstring(r); err != errNetstring {
+		t.Errorf("Expected invalid netstring, got %v", err)
+	}
+
+	// Long header is rejected before the colon
+	for _, data := range []string{"1234567:", "-1:,", strings.Repeat("1", 1<<20)} {
+		r = bufio.NewReader(strings.NewReader(data))
+
+		if _, err := readNetstring(r); err != errNetstring {
+			t.Errorf("Expected invalid netstring for %.10q, got %v", data, err)
+		}
+	}
+}
+
+func Test_Socketmap(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("^SELECT.+aliases.+WHERE.+alias.+deleted_at.+IS NULL").WithArgs("info@user.net").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "alias", "recipient", "comment", "valid_from", "valid_until"}).
+			AddRow(1, "info@user.net", "one@user.net", "", nil, nil).
+			AddRow(2, "info@user.net", "two@user.net", "", nil, nil))
+	mock.ExpectQuery("^SELECT.+transport.+WHERE.+domain").WithArgs("other.net").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "domain", "transport", "rootdir", "uid", "gid"}))
+
+	client, server := net.Pipe()
+	go NewSocketmapServer(env).serveConn(server)
+
+	defer client.Close()
+
+	r := bufio.NewReader(client)
+
+	for req, want := range [][2]string{
+		{"virtual_alias info@user.net", "OK one@user.net,two@user.net"},
+		{"transport other.net", "NOTFOUND "},
+		{"unknown key", "PERM Unknown map unknown"},
+	} {
+		if err := writeNetstring(client, want[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := readNetstring(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != want[1] {
+			t.Errorf("Request %d: expected %q, got %q", req, want[1], data)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
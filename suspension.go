@@ -0,0 +1,56 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"time"
+)
+
+// suspensionDue returns actions of the scheduled suspension at the moment
+func suspensionDue(s *models.Suspension, now time.Time) (apply, resume bool) {
+	if s.End != nil && !now.Before(*s.End) {
+		return false, true
+	}
+
+	if !bool(s.Applied) && (s.Start == nil || !now.Before(*s.Start)) {
+		return true, false
+	}
+
+	return
+}
+
+// syncSuspensions applies and lifts suspensions by their period, it is
+// called by the scheduler
+func syncSuspensions(env Enviroment) (err error) {
+	var (
+		list []*models.Suspension
+		now  = time.Now()
+	)
+
+	if list, _, err = env.Suspensions(nil, false); err != nil {
+		return
+	}
+
+	for _, s := range list {
+		apply, resume := suspensionDue(s, now)
+
+		switch {
+		case resume:
+			if err := env.ResumeUser(s.UID); err != nil {
+				env.Error("Cannot resume the user id=(%d): %s", s.UID, err.Error())
+				continue
+			}
+
+			env.Notice("Suspension of the user id=(%d) is over", s.UID)
+
+		case apply:
+			if err := env.SuspendUser(s, true); err != nil {
+				env.Error("Cannot suspend the user id=(%d): %s", s.UID, err.Error())
+				continue
+			}
+
+			env.Notice("User id=(%d) is suspended: %s", s.UID, s.Reason)
+		}
+	}
+
+	return
+}
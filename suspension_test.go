@@ -0,0 +1,33 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"testing"
+	"time"
+)
+
+func Test_SuspensionDue(t *testing.T) {
+	var (
+		now    = time.Now()
+		past   = now.Add(-time.Hour)
+		future = now.Add(time.Hour)
+	)
+
+	for _, c := range []struct {
+		s             models.Suspension
+		apply, resume bool
+	}{
+		{models.Suspension{}, true, false},
+		{models.Suspension{Start: &past, End: &future}, true, false},
+		{models.Suspension{Start: &future}, false, false},
+		{models.Suspension{Applied: true, End: &future}, false, false},
+		{models.Suspension{Applied: true, End: &past}, false, true},
+		{models.Suspension{Start: &past, End: &past}, false, true},
+	} {
+		apply, resume := suspensionDue(&c.s, now)
+
+		if apply != c.apply || resume != c.resume {
+			t.Errorf("Expecting apply=%v, resume=%v for %+v, but got %v, %v", c.apply, c.resume, c.s, apply, resume)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TCPTableServer serves one of postfixMaps with the Postfix tcp_table
+// protocol, each map needs own listener
+type TCPTableServer struct {
+	env    Enviroment
+	name   string
+	lookup postfixLookup
+}
+
+// NewTCPTableServer returns tcp_table server of the named map
+func NewTCPTableServer(env Enviroment, name string) (*TCPTableServer, error) {
+	fn, ok := postfixMaps[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown Postfix map %s", name)
+	}
+
+	return &TCPTableServer{env: env, name: name, lookup: fn}, nil
+}
+
+// ListenAndServe listens the address and serves connections
+func (s *TCPTableServer) ListenAndServe(address string) error {
+	l, err := listenAddress(address)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections, each one is served in own goroutine
+func (s *TCPTableServer) Serve(l net.Listener) error {
+	return acceptLoop(l, s.serveConn)
+}
+
+// serveConn answers "get key" requests until the client closes connection
+func (s *TCPTableServer) serveConn(conn net.Conn) {
+	var (
+		r  = bufio.NewScanner(conn)
+		id = RandStringId(12)
+	)
+
+	defer conn.Close()
+
+	r.Buffer(make([]byte, 4096), socketmapMaxSize)
+
+	s.env.Debug("%s: tcp_table %s connection from %s", id, s.name, conn.RemoteAddr())
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(socketmapIdle))
+
+		if !r.Scan() {
+			if err := r.Err(); err != nil {
+				s.env.Debug("%s: %s", id, err.Error())
+			}
+
+			return
+		}
+
+		if _, err := fmt.Fprintf(conn, "%s\n", s.reply(id, r.Text())); err != nil {
+			s.env.Debug("%s: %s", id, err.Error())
+			return
+		}
+	}
+}
+
+// reply returns 200 with the value, 500 if the key is not found
+// or 400 on the failure
+func (s *TCPTableServer) reply(id interface{}, req string) string {
+	var (
+		key   string
+		value string
+		err   error
+	)
+
+	req = strings.TrimSuffix(req, "\r")
+
+	if !strings.HasPrefix(req, "get ") {
+		return "400 " + tcpTableEncode("Unsupported request")
+	}
+
+	if key, err = tcpTableDecode(req[4:]); err != nil {
+		return "400 " + tcpTableEncode(err.Error())
+	}
+
+	if value, err = s.lookup(s.env, key); err != nil {
+		s.env.Error("%s: tcp_table %s %s: %s", id, s.name, key, err.Error())
+
+		return "400 " + tcpTableEncode("Lookup failed")
+	}
+
+	s.env.Debug("%s: tcp_table %s %s = %q", id, s.name, key, value)
+
+	if value == "" {
+		return "500 " + tcpTableEncode("Not found")
+	}
+
+	return "200 " + tcpTableEncode(value)
+}
+
+// tcpTableEncode escapes whitespace, control characters and percent
+// sign as %XX
+func tcpTableEncode(str string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(str); i++ {
+		if c := str[i]; c <= ' ' || c >= 0x7f || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// tcpTableDecode replaces %XX sequences with the characters
+func tcpTableDecode(str string) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(str); i++ {
+		if str[i] != '%' {
+			b.WriteByte(str[i])
+			continue
+		}
+
+		if i+2 >= len(str) {
+			return "", fmt.Errorf("Invalid escape in %s", str)
+		}
+
+		c, err := strconv.ParseUint(str[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("Invalid escape in %s", str)
+		}
+
+		b.WriteByte(byte(c))
+		i += 2
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net"
+	"testing"
+)
+
+func Test_TCPTableEncoding(t *testing.T) {
+	var str = "a b%c\n"
+
+	if enc := tcpTableEncode(str); enc != "a%20b%25c%0A" {
+		t.Errorf("Unexpected encoded value %s", enc)
+	}
+
+	if dec, err := tcpTableDecode("a%20b%25c%0a"); err != nil || dec != str {
+		t.Errorf("Unexpected decoded value %q (%v)", dec, err)
+	}
+
+	if _, err := tcpTableDecode("a%2"); err == nil {
+		t.Error("Truncated escape should fail")
+	}
+}
+
+func Test_TCPTable(t *testing.T) {
+	db, mock := initDBMock(t)
+	env := initTestBus(t, true)
+
+	if err := env.openDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("^SELECT.+bcc.+WHERE.+sender.+=").WithArgs("some@user.net", 0, 1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "sender", "recipient", "copy", "comment"}).
+			AddRow(1, "some@user.net", "", "archive@user.net", ""))
+	mock.ExpectQuery("^SELECT.+bcc.+WHERE.+sender.+=").WithArgs("other@user.net", 0, 1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "sender", "recipient", "copy", "comment"}))
+
+	srv, err := NewTCPTableServer(env, "sender_bcc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	go srv.serveConn(server)
+
+	defer client.Close()
+
+	r := bufio.NewReader(client)
+
+	for _, want := range [][2]string{
+		{"get some%40user.net", "200 archive@user.net\n"},
+		{"get other@user.net", "500 Not%20found\n"},
+		{"put some value", "400 Unsupported%20request\n"},
+	} {
+		if _, err = fmt.Fprintf(client, "%s\n", want[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if line != want[1] {
+			t.Errorf("%s: expected %q, got %q", want[0], want[1], line)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = NewTCPTableServer(env, "unknown"); err == nil {
+		t.Error("Unknown map should fail")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"mbmi-go/models"
+	"time"
+)
+
+// purgeTrashItem removes soft deleted record permanently. Maildir of the
+// user is passed to the mailbox hooks only now, so the restored user
+// gets it back as is. The maildir is kept if the address belongs to
+// another user by now
+func purgeTrashItem(env Enviroment, id interface{}, kind string, itemId int64) (err error) {
+	var users []*models.User
+
+	if kind == models.TrashUser {
+		flt := models.NewFilter().Where("deleted", nil).Where("id", itemId)
+
+		if users, _, err = env.Users(flt, false); err != nil {
+			return
+		}
+	}
+
+	if err = env.PurgeTrash(kind, itemId); err != nil {
+		return
+	}
+
+	for _, u := range users {
+		var live []*models.User
+
+		// The address may be taken again, the maildir belongs to the new user
+		flt := models.NewFilter().
+			Where("login", u.Login).
+			Where("domain", u.DomainName)
+
+		if live, _, err = env.Users(flt, false); err != nil {
+			return
+		}
+
+		if len(live) > 0 {
+			env.Notice("%s: Maildir of %s is kept, the address is used by user %d", id, u.Email, live[0].Id)
+			continue
+		}
+
+		runMailboxHook(env, id, hookDeleted, u)
+	}
+
+	return
+}
+
+// purgeTrash removes records deleted more than days ago, zero days
+// keeps them forever
+func purgeTrash(env Enviroment, days int) error {
+	var (
+		err   error
+		items []*models.Trash
+	)
+
+	if days <= 0 {
+		return nil
+	}
+
+	flt := models.NewFilter().Where("before", time.Now().AddDate(0, 0, -days))
+
+	if items, _, err = env.Trash(flt, false); err != nil {
+		return err
+	}
+
+	for _, i := range items {
+		if err = purgeTrashItem(env, "trash", i.Kind, i.Id); err != nil {
+			env.Error("Cannot purge %s %s: %s", i.Kind, i.Name, err.Error())
+			continue
+		}
+
+		env.Info("Purged %s %s deleted at %s", i.Kind, i.Name, i.Deleted.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// expireAliases moves temporary aliases to the trash after valid_until,
+// lookups skip them already
+func expireAliases(env Enviroment) error {
+	n, err := env.ExpireAliases()
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		env.Info("Moved %d expired aliases to the trash", n)
+	}
+
+	return nil
+}
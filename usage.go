@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"mbmi-go/models"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UsageCollector reads mailbox usage from the maildir and keeps
+// results in the cache for the ttl period
+type UsageCollector struct {
+	ttl   time.Duration
+	mu    sync.RWMutex
+	cache map[string]*models.Usage
+}
+
+// NewUsageCollector returns collector with given cache lifetime
+func NewUsageCollector(ttl time.Duration) *UsageCollector {
+	return &UsageCollector{
+		ttl:   ttl,
+		cache: make(map[string]*models.Usage),
+	}
+}
+
+// Get returns usage of the maildir, cached value is used if it
+// is not expired
+func (c *UsageCollector) Get(path string) (u *models.Usage, err error) {
+	var now = time.Now()
+
+	c.mu.RLock()
+	u = c.cache[path]
+	c.mu.RUnlock()
+
+	if u != nil && u.Updated != nil && now.Sub(*u.Updated) < c.ttl {
+		return
+	}
+
+	if u, err = readMaildirsize(filepath.Join(path, "maildirsize")); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		// Quota is not enabled for the mailbox, calculate
+		// size from the message files
+		if u, err = scanMaildir(path); err != nil {
+			return nil, err
+		}
+	}
+
+	u.Updated = &now
+	u.Calc()
+
+	c.mu.Lock()
+	c.cache[path] = u
+	c.mu.Unlock()
+
+	return
+}
+
+// Fill attaches usage to the users list. Transport root is
+// used to build mailbox path, errors are skipped to show as much
+// as possible
+func (c *UsageCollector) Fill(users []*models.User, transports []*models.Transport, log LogIface) {
+	var roots = make(map[uint]*models.Transport)
+
+	for _, t := range transports {
+		roots[uint(t.Id)] = t
+	}
+
+	for _, u := range users {
+		var (
+			err error
+			t   = roots[u.Domain]
+		)
+
+		if t == nil || t.Root == "" {
+			continue
+		}
+
+		if u.Usage, err = c.Get(mailboxPath(t, u.Login, u.DomainName)); err != nil {
+			log.Debug("Cannot read usage of %s: %s", u.Email, err.Error())
+		}
+	}
+}
+
+// Flush removes cached value for the path
+func (c *UsageCollector) Flush(path string) {
+	c.mu.Lock()
+	delete(c.cache, path)
+	c.mu.Unlock()
+}
+
+// readMaildirsize parses Maildir++ quota file used by Dovecot maildir quota
+// backend. The first line is the quota definition (e.g. 1000000S,1000C),
+// the others are "bytes count" deltas
+func readMaildirsize(name string) (u *models.Usage, err error) {
+	var (
+		f  *os.File
+		sc *bufio.Scanner
+	)
+
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	u = &models.Usage{}
+	sc = bufio.NewScanner(f)
+
+	if sc.Scan() {
+		for _, def := range strings.Split(sc.Text(), ",") {
+			if l := len(def); l > 1 {
+				v, _ := strconv.ParseInt(def[:l-1], 10, 64)
+
+				switch def[l-1] {
+				case 'S':
+					u.LimitBytes = v
+				case 'C':
+					u.LimitMessages = v
+				}
+			}
+		}
+	}
+
+	for sc.Scan() {
+		var fields = strings.Fields(sc.Text())
+
+		if len(fields) != 2 {
+			continue
+		}
+
+		b, _ := strconv.ParseInt(fields[0], 10, 64)
+		m, _ := strconv.ParseInt(fields[1], 10, 64)
+
+		u.Bytes += b
+		u.Messages += m
+	}
+
+	return u, sc.Err()
+}
+
+// scanMaildir walks the cur and new folders of the maildir and
+// its subfolders
+func scanMaildir(path string) (u *models.Usage, err error) {
+	u = &models.Usage{}
+
+	if _, err = os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if info.Mode().IsRegular() {
+			if dir := filepath.Base(filepath.Dir(name)); dir == "cur" || dir == "new" {
+				u.Bytes += info.Size()
+				u.Messages++
+			}
+		}
+
+		return nil
+	})
+
+	return
+}
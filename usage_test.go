@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"mbmi-go/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_UsageFromMaildirsize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbmi-usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	data := "1000S,10C\n300 2\n200 3\n-100 -1\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "maildirsize"), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := NewUsageCollector(time.Minute).Get(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Bytes != 400 || u.Messages != 4 {
+		t.Errorf("Expecting 400 bytes and 4 messages, but got %d and %d", u.Bytes, u.Messages)
+	}
+
+	if u.LimitBytes != 1000 || u.LimitMessages != 10 {
+		t.Errorf("Expecting limits 1000S,10C, but got %dS,%dC", u.LimitBytes, u.LimitMessages)
+	}
+
+	if u.Percent != 40 {
+		t.Errorf("Expecting 40 percent, but got %f", u.Percent)
+	}
+}
+
+func Test_UsageFromMaildirWithoutQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbmi-usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"cur/1", "new/2", ".Sent/cur/3", "tmp/4"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0700)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("12345"), 0600)
+	}
+
+	u, err := NewUsageCollector(time.Minute).Get(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Bytes != 15 || u.Messages != 3 {
+		t.Errorf("Expecting 15 bytes and 3 messages, but got %d and %d", u.Bytes, u.Messages)
+	}
+}
+
+func Test_UsersFilterByUsage(t *testing.T) {
+	req, _ := request("GET", "/users?usage_min=50&sort=usage&dir=desc", nil)
+	req.ParseForm()
+
+	users := []*models.User{
+		&models.User{Id: 1, Usage: &models.Usage{Percent: 10}},
+		&models.User{Id: 2, Usage: &models.Usage{Percent: 60}},
+		&models.User{Id: 3},
+		&models.User{Id: 4, Usage: &models.Usage{Percent: 90}},
+	}
+
+	list, count := usageFilter(req, users)
+
+	if count != 2 {
+		t.Fatalf("Expecting 2 users, but got %d", count)
+	}
+
+	if list[0].Id != 4 || list[1].Id != 2 {
+		t.Errorf("Unexpected order: %d, %d", list[0].Id, list[1].Id)
+	}
+}